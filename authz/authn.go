@@ -0,0 +1,72 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrUnauthenticated is wrapped by errors of missing or invalid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+var errNoCredentials = fmt.Errorf("%w: no credentials", ErrUnauthenticated)
+
+// AccessTokenCookie carries the token of browsers' websocket handshakes,
+// since they can not set the Authorization header. It is not accepted by REST
+// middlewares, so cross-site requests can not use it.
+//
+// Browsers send cookies with cross-site handshakes too, so it must be accepted
+// only from allowed origins, see [TokenFromCookie].
+const AccessTokenCookie = "access_token"
+
+// Authenticator verifies credentials of requests.
+type Authenticator interface {
+	// Authenticate returns the user of the token and when the token expires,
+	// or an error wrapping [ErrUnauthenticated] if the token is invalid.
+	// Zero expiresAt means the token does not expire.
+	Authenticate(token string) (userId string, expiresAt time.Time, err error)
+}
+
+// authenticate is like [Authenticator.Authenticate], but empty tokens are rejected
+// without calling authn.
+func authenticate(authn Authenticator, token string) (string, error) {
+	if token == "" {
+		return "", errNoCredentials
+	}
+	userId, _, err := authn.Authenticate(token)
+	return userId, err
+}
+
+// TokenFromHeader returns the bearer token of the Authorization header. if not found returns ""
+func TokenFromHeader(h http.Header) string {
+	return bearerToken(h.Get("Authorization"))
+}
+
+// TokenFromCookie returns the value of [AccessTokenCookie]. if not found returns ""
+//
+// Callers must check that the Origin header of the request is allowed,
+// otherwise any site can use the token of its visitors.
+func TokenFromCookie(h http.Header) string {
+	for _, line := range h["Cookie"] {
+		if cookies, err := http.ParseCookie(line); err == nil {
+			for _, c := range cookies {
+				if c.Name == AccessTokenCookie {
+					return c.Value
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// returns the token of "Bearer <token>" authorization, or "".
+func bearerToken(authorization string) string {
+	scheme, token, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
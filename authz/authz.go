@@ -2,6 +2,7 @@ package authz
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
@@ -10,9 +11,15 @@ import (
 	"github.com/authzed/grpcutil"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
+// ErrCursorExpired is wrapped by errors of [Authoriz.WatchObjects] when SpiceDB
+// can not resume watching from the cursor, e.g. it's older than the garbage collection window.
+var ErrCursorExpired = errors.New("watch cursor is expired")
+
 type Tuple struct{ UserId, Relation, ObjType, ObjId string }
 
 type Conf struct {
@@ -61,7 +68,15 @@ func (a Authoriz) Check(ctx context.Context, userId, relation, objType, objId st
 }
 
 func (a Authoriz) WhoHasRel(ctx context.Context, relation, objType, objId string) ([]string, error) {
+	return a.WhoHasRelAsFreshAs(ctx, "", relation, objType, objId)
+}
+
+// WhoHasRelAsFreshAs is like [Authoriz.WhoHasRel], but relationships are read at least
+// as fresh as the ZedToken, e.g. the one which is passed by [Authoriz.WatchObjects].
+// Empty token reads them with minimal latency, so they may be stale.
+func (a Authoriz) WhoHasRelAsFreshAs(ctx context.Context, token, relation, objType, objId string) ([]string, error) {
 	stream, err := a.cli.LookupSubjects(ctx, &v1.LookupSubjectsRequest{
+		Consistency:       atLeastAsFresh(token),
 		Resource:          &v1.ObjectReference{ObjectType: objType, ObjectId: objId},
 		Permission:        relation,
 		SubjectObjectType: "user",
@@ -107,6 +122,64 @@ func (a Authoriz) WhichObjsRelateToUser(ctx context.Context, userId, relation, o
 	return objectIds, nil
 }
 
+// returns the consistency of reading relationships at least as fresh as the ZedToken,
+// or nil (minimal latency) if token is empty.
+func atLeastAsFresh(token string) *v1.Consistency {
+	if token == "" {
+		return nil
+	}
+	return &v1.Consistency{Requirement: &v1.Consistency_AtLeastAsFresh{
+		AtLeastAsFresh: &v1.ZedToken{Token: token},
+	}}
+}
+
+// WatchObjects calls changed with ids of objects of objType whose relationships are changed,
+// until ctx is done or the stream fails. changed is also passed the ZedToken of the changes,
+// so relationships can be read at least as fresh as them.
+// cursor is the ZedToken to resume watching from, and it is updated after changes;
+// empty cursor watches changes from now.
+// The error wraps [ErrCursorExpired] if the cursor can not be resumed.
+func (a Authoriz) WatchObjects(ctx context.Context, objType string, cursor *string, changed func(objId, token string)) error {
+	req := &v1.WatchRequest{OptionalObjectTypes: []string{objType}}
+	if *cursor != "" {
+		req.OptionalStartCursor = &v1.ZedToken{Token: *cursor}
+	}
+
+	stream, err := a.cli.Watch(ctx, req)
+	if err != nil {
+		return watchErr(err)
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return watchErr(err)
+		}
+
+		token := resp.GetChangesThrough().GetToken()
+		seen := make(map[string]struct{}, len(resp.Updates))
+		for _, u := range resp.Updates {
+			id := u.GetRelationship().GetResource().GetObjectId()
+			if _, found := seen[id]; !found {
+				seen[id] = struct{}{}
+				changed(id, token)
+			}
+		}
+
+		if token != "" {
+			*cursor = token
+		}
+	}
+}
+
+// wraps errors of expired cursors in [ErrCursorExpired].
+func watchErr(err error) error {
+	if status.Code(err) == codes.FailedPrecondition {
+		return fmt.Errorf("%w: %w", ErrCursorExpired, err)
+	}
+	return err
+}
+
 func (a Authoriz) BulkCheck(ctx context.Context, tuples []Tuple) ([]bool, []error) {
 	requests := make([]*v1.CheckBulkPermissionsRequestItem, len(tuples))
 	allowed := make([]bool, len(tuples))
@@ -0,0 +1,262 @@
+package authz
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// minimum size of HS256 keys, as the hash size.
+const minHMACKeyLen = 32
+
+// JWTConf configures [JWTAuthenticator].
+type JWTConf struct {
+	// JSON Web Key Set with "oct" keys for HS256 and "RSA" keys for RS256.
+	JWKSFile string
+	Issuer   string // required "iss" claim
+	Audience string // required in "aud" claim
+
+	// tolerated clock skew in checking "exp" and "nbf".
+	Leeway time.Duration
+}
+
+// JWTAuthenticator implements [Authenticator] for JWTs signed by HS256 or RS256.
+// The subject of a valid token is the user.
+//
+// Keys are loaded from the JWKS file, and they are replaced by [JWTAuthenticator.Reload],
+// so keys can be rotated without restarting.
+type JWTAuthenticator struct {
+	conf JWTConf
+	keys atomic.Pointer[[]jwk]
+	now  func() time.Time
+}
+
+// a verification key of JWKS.
+type jwk struct {
+	kid  string
+	alg  string // "HS256" or "RS256"
+	hmac []byte
+	rsa  *rsa.PublicKey
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub string      `json:"sub"`
+	Iss string      `json:"iss"`
+	Aud jwtAudience `json:"aud"`
+	Exp *float64    `json:"exp"`
+	Nbf *float64    `json:"nbf"`
+}
+
+// "aud" claim, which is a string or an array of strings.
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// NewJWTAuthenticator loads keys of the JWKS file. Issuer and Audience are required.
+func NewJWTAuthenticator(conf JWTConf) (*JWTAuthenticator, error) {
+	if conf.Issuer == "" || conf.Audience == "" {
+		return nil, errors.New("issuer and audience of tokens are required")
+	}
+
+	a := &JWTAuthenticator{conf: conf, now: time.Now}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload loads keys of the JWKS file. Current keys are kept if it fails.
+func (a *JWTAuthenticator) Reload() error {
+	data, err := os.ReadFile(a.conf.JWKSFile)
+	if err != nil {
+		return fmt.Errorf("can not read jwks file: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("can not parse jwks file %s: %w", a.conf.JWKSFile, err)
+	}
+
+	a.keys.Store(&keys)
+	return nil
+}
+
+// Authenticate implements [Authenticator]. It verifies the signature,
+// "exp", "nbf", "iss" and "aud" claims of the token, and returns its "sub"
+// and "exp" with the leeway.
+func (a *JWTAuthenticator) Authenticate(token string) (string, time.Time, error) {
+	claims, err := a.verify(token)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+
+	var expiresAt time.Time
+	if claims.Exp != nil {
+		expiresAt = numericDate(*claims.Exp).Add(a.conf.Leeway)
+	}
+
+	now := a.now()
+	switch {
+	case claims.Exp == nil:
+		err = errors.New("token has no expiration")
+	case now.After(expiresAt):
+		err = errors.New("token is expired")
+	case claims.Nbf != nil && now.Add(a.conf.Leeway).Before(numericDate(*claims.Nbf)):
+		err = errors.New("token is not valid yet")
+	case claims.Iss != a.conf.Issuer:
+		err = fmt.Errorf("invalid issuer %q", claims.Iss)
+	case !slices.Contains(claims.Aud, a.conf.Audience):
+		err = errors.New("invalid audience")
+	case claims.Sub == "" || claims.Sub == AnonymousUserId:
+		err = fmt.Errorf("invalid subject %q", claims.Sub)
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: %w", ErrUnauthenticated, err)
+	}
+
+	return claims.Sub, expiresAt, nil
+}
+
+// verifies the signature of the token and decodes its claims.
+func (a *JWTAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	if !a.verifySignature(header, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, errors.New("invalid signature")
+	}
+
+	claims := &jwtClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	return claims, nil
+}
+
+// reports whether a key of the header's algorithm, and its kid if any, signed the input.
+func (a *JWTAuthenticator) verifySignature(header jwtHeader, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	for _, k := range *a.keys.Load() {
+		if k.alg != header.Alg || (header.Kid != "" && k.kid != header.Kid) {
+			continue
+		}
+
+		switch k.alg {
+		case "HS256":
+			mac := hmac.New(sha256.New, k.hmac)
+			mac.Write(signed)
+			if hmac.Equal(sig, mac.Sum(nil)) {
+				return true
+			}
+		case "RS256":
+			if rsa.VerifyPKCS1v15(k.rsa, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodes a base64url segment of the token as json.
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// parses "oct" keys as HS256 and "RSA" keys as RS256. Other keys are ignored.
+func parseJWKS(data []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			K   string `json:"k"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]jwk, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch {
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			if len(secret) < minHMACKeyLen {
+				return nil, fmt.Errorf("key %q: HS256 keys must have at least %d bytes", k.Kid, minHMACKeyLen)
+			}
+			keys = append(keys, jwk{kid: k.Kid, alg: "HS256", hmac: secret})
+
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if err := errors.Join(errN, errE); err != nil || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("key %q: invalid rsa public key", k.Kid)
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, jwk{kid: k.Kid, alg: "RS256", rsa: pub})
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no HS256 or RS256 keys")
+	}
+	return keys, nil
+}
+
+// converts seconds since epoch to time.
+func numericDate(sec float64) time.Time {
+	return time.UnixMilli(int64(sec * 1000))
+}
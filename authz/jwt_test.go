@@ -0,0 +1,206 @@
+package authz
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACKey = []byte("0123456789abcdef0123456789abcdef")
+	testNow     = time.Unix(1_700_000_000, 0)
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// signs the claims with the HMAC key or the RSA key.
+func signToken(t *testing.T, header, claims map[string]any, key any) string {
+	t.Helper()
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64(sig)
+}
+
+// writes a JWKS file with the HMAC key "hs" and the public key of rsaKey "rs".
+func writeJWKS(t *testing.T, path string, hmacKey []byte, rsaKey *rsa.PrivateKey) {
+	t.Helper()
+
+	keys := []map[string]string{{"kty": "oct", "kid": "hs", "alg": "HS256", "k": b64(hmacKey)}}
+	if rsaKey != nil {
+		keys = append(keys, map[string]string{
+			"kty": "RSA", "kid": "rs", "use": "sig",
+			"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		})
+	}
+
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestAuthenticator(t *testing.T, rsaKey *rsa.PrivateKey) (*JWTAuthenticator, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, testHMACKey, rsaKey)
+
+	a, err := NewJWTAuthenticator(JWTConf{JWKSFile: path, Issuer: "https://issuer", Audience: "chat", Leeway: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return testNow }
+	return a, path
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSAKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	a, _ := newTestAuthenticator(t, rsaKey)
+
+	claims := func(changes map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "iss": "https://issuer", "aud": "chat", "exp": testNow.Add(time.Hour).Unix()}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]any{"alg": "RS256", "kid": "rs"}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", signToken(t, hs, claims(nil), testHMACKey), true},
+		{"rs256", signToken(t, rs, claims(nil), rsaKey), true},
+		{"audience-list", signToken(t, hs, claims(map[string]any{"aud": []string{"other", "chat"}}), testHMACKey), true},
+		{"expired-within-leeway", signToken(t, hs, claims(map[string]any{"exp": testNow.Add(-time.Second).Unix()}), testHMACKey), true},
+		{"expired", signToken(t, hs, claims(map[string]any{"exp": testNow.Add(-time.Hour).Unix()}), testHMACKey), false},
+		{"without-exp", signToken(t, hs, claims(map[string]any{"exp": nil}), testHMACKey), false},
+		{"not-before", signToken(t, hs, claims(map[string]any{"nbf": testNow.Add(time.Hour).Unix()}), testHMACKey), false},
+		{"wrong-issuer", signToken(t, hs, claims(map[string]any{"iss": "https://attacker"}), testHMACKey), false},
+		{"wrong-audience", signToken(t, hs, claims(map[string]any{"aud": "other"}), testHMACKey), false},
+		{"anonymous-subject", signToken(t, hs, claims(map[string]any{"sub": AnonymousUserId}), testHMACKey), false},
+		{"wrong-hmac-key", signToken(t, hs, claims(nil), []byte("another key which is long enough!")), false},
+		{"wrong-rsa-key", signToken(t, rs, claims(nil), otherRSAKey), false},
+		{"unknown-kid", signToken(t, map[string]any{"alg": "HS256", "kid": "rs"}, claims(nil), testHMACKey), false},
+		{"alg-none", signToken(t, map[string]any{"alg": "none"}, claims(nil), nil), false},
+		{"malformed", "not.a-token", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, _, err := a.Authenticate(tt.token)
+			if tt.ok && (err != nil || userId != "alice") {
+				t.Errorf("token should be valid, got %q, %v", userId, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf("token should be invalid, got %q, %v", userId, err)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticator_Reload(t *testing.T) {
+	a, path := newTestAuthenticator(t, nil)
+	claims := map[string]any{"sub": "alice", "iss": "https://issuer", "aud": "chat", "exp": testNow.Add(time.Hour).Unix()}
+
+	rotated := []byte("rotated key which is long enough!")
+	writeJWKS(t, path, rotated, nil)
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := a.Authenticate(signToken(t, map[string]any{"alg": "HS256"}, claims, testHMACKey)); err == nil {
+		t.Error("tokens of the old key should be rejected after reloading")
+	}
+	if _, _, err := a.Authenticate(signToken(t, map[string]any{"alg": "HS256"}, claims, rotated)); err != nil {
+		t.Errorf("tokens of the new key should be accepted, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("{"), 0o600)
+	if err := a.Reload(); err == nil {
+		t.Error("reloading an invalid file should fail")
+	}
+	if _, _, err := a.Authenticate(signToken(t, map[string]any{"alg": "HS256"}, claims, rotated)); err != nil {
+		t.Errorf("keys should be kept if reloading fails, got %v", err)
+	}
+}
+
+func TestNewHttpAuthMiddleware(t *testing.T) {
+	a, _ := newTestAuthenticator(t, nil)
+	claims := map[string]any{"sub": "alice", "iss": "https://issuer", "aud": "chat", "exp": testNow.Add(time.Hour).Unix()}
+	token := signToken(t, map[string]any{"alg": "HS256"}, claims, testHMACKey)
+
+	var userId string
+	handler := NewHttpAuthMiddleware(a, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId = UserIdFromCtx(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"bearer", http.Header{"Authorization": []string{"Bearer " + token}}, http.StatusOK},
+		{"cookie", http.Header{"Cookie": []string{AccessTokenCookie + "=" + token}}, http.StatusUnauthorized},
+		{"missing", http.Header{"Cookie": []string{"userId=alice"}}, http.StatusUnauthorized},
+		{"invalid", http.Header{"Authorization": []string{"Bearer " + token + "x"}}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId = ""
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header = tt.header
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+			if tt.status == http.StatusOK && userId != "alice" {
+				t.Errorf("subject should be the user, got %q", userId)
+			}
+		})
+	}
+}
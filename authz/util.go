@@ -4,18 +4,25 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
-	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// Creates a new middleware handler
-func NewFiberAuthMiddleware() fiber.Handler {
+// Creates a new middleware handler which authenticates requests by authn
+// and responds 401 for missing or invalid credentials.
+//
+// Only bearer tokens of the Authorization header are accepted. Cookies are sent
+// by browsers with cross-site requests, so they would allow request forgery.
+func NewFiberAuthMiddleware(authn Authenticator) fiber.Handler {
 
 	// Return new handler
 	return func(c *fiber.Ctx) error {
-		userId := c.Cookies("userId", "343")
-		userId = strings.Clone(userId)
+		userId, err := authenticate(authn, bearerToken(c.Get(fiber.HeaderAuthorization)))
+		if err != nil {
+			slog.Debug("request is not authenticated", "err", err)
+			c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+			return fiber.NewError(fiber.StatusUnauthorized, "unauthenticated")
+		}
 
 		c.Locals(UserIdCtxKey, userId)
 
@@ -23,7 +30,8 @@ func NewFiberAuthMiddleware() fiber.Handler {
 	}
 }
 
-func NewHttpAuthMiddleware(next http.Handler) http.HandlerFunc {
+// NewHttpAuthMiddleware is like [NewFiberAuthMiddleware] for [http.Handler].
+func NewHttpAuthMiddleware(authn Authenticator, next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			err := recover()
@@ -32,10 +40,12 @@ func NewHttpAuthMiddleware(next http.Handler) http.HandlerFunc {
 			}
 		}()
 
-		userIdCookie, err := r.Cookie("userId")
-		userId := "343"
-		if err == nil {
-			userId = userIdCookie.Value
+		userId, err := authenticate(authn, bearerToken(r.Header.Get("Authorization")))
+		if err != nil {
+			slog.Debug("request is not authenticated", "err", err)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
 		}
 
 		ctx := context.WithValue(r.Context(), UserIdCtxKey, userId)
@@ -58,22 +68,7 @@ type userIdType string
 
 var UserIdCtxKey = userIdType("userId")
 
-// return authenticated user. if not found returns ""
-func UserIdFromCookieHeader(h http.Header) string {
-	lines := h["Cookie"]
-	if len(lines) == 0 {
-		return ""
-	}
-
-	for _, line := range lines {
-		if cookies, err := http.ParseCookie(line); err == nil {
-			for _, c := range cookies {
-				if c.Name == "userId" {
-					return c.Value
-				}
-			}
-		}
-	}
-
-	return ""
-}
+// AnonymousUserId is the subject of unauthenticated websocket clients.
+// It should not be a real user, so it only has permissions granted
+// to every user (`user:*`), e.g. watching public topics.
+const AnonymousUserId = "anonymous"
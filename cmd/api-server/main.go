@@ -4,14 +4,21 @@ import (
 	"chat-system/authz"
 	"chat-system/config"
 	"chat-system/core/api"
+	"chat-system/core/members"
 	"chat-system/core/messages"
 	"chat-system/core/repo"
 	kafkarep "chat-system/core/repo/kafkaRep"
 	"chat-system/pkg/observe"
+	"chat-system/ws/presence"
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -20,6 +27,20 @@ type Config struct {
 	KafkaWriter  *kafkarep.WriterConf
 	SpiceDbUrl   string `env:"AUTHZED_URL"`
 	SpiceDBToken string `env:"AUTHZED_TOKEN"`
+
+	// comma-separated origin patterns allowed by CORS, shared with the ws-server.
+	AllowedOrigins string `env:"ALLOWED_ORIGINS"`
+
+	// requests are authenticated by JWTs, verified with keys of the JWKS file.
+	// The file is reloaded on SIGHUP. Shared with the ws-server.
+	JWKSFile    string        `env:"JWT_JWKS_FILE" required:"true"`
+	JWTIssuer   string        `env:"JWT_ISSUER" required:"true"`
+	JWTAudience string        `env:"JWT_AUDIENCE" required:"true"`
+	JWTLeeway   time.Duration `env:"JWT_LEEWAY" default:"30s"`
+
+	// serves presence of topics' members, which ws-servers store with WS_PRESENCE=mongo.
+	// Disable it if ws-servers use "local" presence.
+	MembersPresence bool `env:"API_MEMBERS_PRESENCE" default:"true"`
 }
 
 func getMessageRepository(conf *Config) messages.Repository {
@@ -81,15 +102,52 @@ func main() {
 
 	authoriz := authz.NewAuthoriz(authzed)
 
+	authn, err := authz.NewJWTAuthenticator(authz.JWTConf{
+		JWKSFile: conf.JWKSFile,
+		Issuer:   conf.JWTIssuer,
+		Audience: conf.JWTAudience,
+		Leeway:   conf.JWTLeeway,
+	})
+	if err != nil {
+		panic(err)
+	}
+	go reloadOnSIGHUP(authn)
+
 	messageRepo := getMessageRepository(conf)
 
-	fiberApp, err := api.Initialize(messages.NewService(messageRepo, authoriz))
+	var presenceSVC api.PresenceService
+	if conf.MembersPresence {
+		presenceStore, err := presence.NewMongoStore(context.TODO(), repo.NewInsecureMongoCli(conf.MongoDB).Database("chatting2"))
+		if err != nil {
+			panic(err)
+		}
+		presenceSVC = members.NewService(presenceStore, authoriz)
+	}
+
+	fiberApp, err := api.Initialize(
+		messages.NewService(messageRepo, authoriz),
+		presenceSVC,
+		strings.Split(conf.AllowedOrigins, ","),
+		authn,
+	)
 	if err != nil {
 		panic(err)
 	}
 
-	fiberApp.Use(healthcheck.New())
-
 	fiberApp.Listen(":8888")
 
 }
+
+// reloads keys of the authenticator on SIGHUP.
+func reloadOnSIGHUP(authn *authz.JWTAuthenticator) {
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
+	for range hupCh {
+		if err := authn.Reload(); err != nil {
+			slog.Error("can not reload jwks", "err", err)
+		} else {
+			slog.Info("jwks is reloaded")
+		}
+	}
+}
@@ -12,11 +12,15 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 type Config struct {
 	KafkaReader *kafkarep.ReaderConf
 	MongoDB     *repo.MongoConf
+
+	// stored events are published to AppliedTopic, which ws-servers watch.
+	AppliedTopic string `env:"KAFKA_APPLIED_TOPIC" default:"chat-messages-applied"`
 }
 
 func main() {
@@ -45,8 +49,15 @@ func main() {
 	kafkaReader := kafkarep.NewInsecureReader(conf.KafkaReader)
 	defer kafkaReader.Close()
 
+	appliedWriter := kafkarep.NewInsecureWriter(&kafkarep.WriterConf{
+		KafkaHost:    conf.KafkaReader.KafkaHost,
+		MsgTopic:     conf.AppliedTopic,
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	defer appliedWriter.Close()
+
 	mongoDB := mongoCli.Database("chatting2")
-	mongoKConnect := kafkarep.NewMongoConnect(context.Background(), mongoDB, kafkaReader)
+	mongoKConnect := kafkarep.NewMongoConnect(context.Background(), mongoDB, kafkaReader, appliedWriter)
 	defer mongoKConnect.Close()
 
 	s := make(chan os.Signal, 1)
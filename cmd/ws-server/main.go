@@ -3,26 +3,122 @@ package main
 import (
 	"chat-system/authz"
 	"chat-system/config"
+	"chat-system/core/messages"
 	"chat-system/core/repo"
 	kafkarep "chat-system/core/repo/kafkaRep"
 	"chat-system/pkg/observe"
+	"chat-system/pkg/origins"
 	"chat-system/ws"
+	"chat-system/ws/presence"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
-
-	"github.com/segmentio/kafka-go"
 )
 
 type Config struct {
 	MongoDB      *repo.MongoConf
 	KafkaReader  *kafkarep.ReaderConf
+	KafkaWriter  *kafkarep.WriterConf
 	SpiceDbUrl   string `env:"AUTHZED_URL"`
 	SpiceDBToken string `env:"AUTHZED_TOKEN"`
+
+	// messages are watched in the topic which the db-sink publishes stored events to.
+	AppliedTopic string `env:"KAFKA_APPLIED_TOPIC" default:"chat-messages-applied"`
+
+	// comma-separated origin patterns allowed to open websockets, like
+	// "https://example.com,https://*.preview.example.com". Shared with the api-server.
+	// Browsers authenticated by the access_token cookie are accepted only from these origins.
+	AllowedOrigins string `env:"ALLOWED_ORIGINS"`
+
+	// "auto" joins clients to all authorized rooms, "explicit" only to subscribed rooms.
+	SubscriptionMode string `env:"WS_SUBSCRIPTION_MODE" default:"auto"`
+
+	// "broadcast" reads all kafka partitions on every instance without a consumer group,
+	// "shared" splits partitions between instances (only for a single instance).
+	WatcherMode string `env:"WS_WATCHER_MODE" default:"broadcast"`
+	InstanceID  string `env:"WS_INSTANCE_ID"` // default is hostname with a random suffix
+
+	// "local" only knows users of this instance, "mongo" shares presence between instances
+	// and with the members endpoint of the api-server, which reports everyone offline with "local".
+	Presence    string        `env:"WS_PRESENCE" default:"mongo"`
+	PresenceTTL time.Duration `env:"WS_PRESENCE_TTL" default:"30s"`
+
+	// clients are sent "heartbeat" envelopes every interval and closed if they do not
+	// reply "pong" (or any other frame) within timeout, so half-open connections are reaped.
+	// Zero interval disables heartbeats, e.g. for clients which can not reply.
+	HeartbeatInterval time.Duration `env:"WS_HEARTBEAT_INTERVAL" default:"30s"`
+	HeartbeatTimeout  time.Duration `env:"WS_HEARTBEAT_TIMEOUT" default:"10s"`
+
+	// frames queued for each client, and "drop-oldest", "coalesce" or "disconnect" when it's full.
+	SendQueueSize int    `env:"WS_SEND_QUEUE_SIZE" default:"1024"`
+	SlowConsumer  string `env:"WS_SLOW_CONSUMER_POLICY" default:"disconnect"`
+	Compression   bool   `env:"WS_COMPRESSION" default:"false"` // permessage-deflate
+
+	// address of unauthenticated debug endpoints like "localhost:7101", empty disables them.
+	DebugAddr string `env:"WS_DEBUG_ADDR" default:""`
+
+	// messages of rooms with at least BatchMinRate messages per second are batched
+	// within BatchWindow for clients which support it. Zero window disables batching.
+	BatchWindow  time.Duration `env:"WS_BATCH_WINDOW" default:"50ms"`
+	BatchMinRate int           `env:"WS_BATCH_MIN_RATE" default:"20"`
+
+	// viewers of rooms with at least SamplingMinViewers clients receive at most
+	// SamplingRate messages per second. Zero rate disables sampling.
+	SamplingRate       int           `env:"WS_SAMPLING_RATE" default:"0"`
+	SamplingMinViewers int           `env:"WS_SAMPLING_MIN_VIEWERS" default:"1000"`
+	ViewersInterval    time.Duration `env:"WS_VIEWERS_INTERVAL" default:"5s"`
+
+	// on SIGTERM clients are drained within DrainTimeout, and told to reconnect
+	// after a random delay less than ReconnectJitter.
+	DrainTimeout    time.Duration `env:"WS_DRAIN_TIMEOUT" default:"20s"`
+	ReconnectJitter time.Duration `env:"WS_RECONNECT_JITTER" default:"10s"`
+
+	// maximum connections of each user, each IP and this instance. Zero means unlimited.
+	// IPs are peers of TCP connections, so keep MaxConnsPerIP zero behind a load balancer.
+	MaxConnsPerUser int `env:"WS_MAX_CONNS_PER_USER" default:"20"`
+	MaxConnsPerIP   int `env:"WS_MAX_CONNS_PER_IP" default:"0"`
+	MaxConns        int `env:"WS_MAX_CONNS" default:"0"`
+
+	// serves wss:// if both are set. The files are reloaded when they change or on SIGHUP.
+	TLSCertFile string `env:"WS_TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"WS_TLS_KEY_FILE"`
+
+	// clients are authenticated by JWTs, verified with keys of the JWKS file.
+	// The file is reloaded on SIGHUP. Shared with the api-server.
+	JWKSFile    string        `env:"JWT_JWKS_FILE" required:"true"`
+	JWTIssuer   string        `env:"JWT_ISSUER" required:"true"`
+	JWTAudience string        `env:"JWT_AUDIENCE" required:"true"`
+	JWTLeeway   time.Duration `env:"JWT_LEEWAY" default:"30s"`
+
+	// accepts clients without a token, which can only watch public topics.
+	AllowAnonymous bool `env:"WS_ALLOW_ANONYMOUS" default:"false"`
+
+	// watches SpiceDB to add or remove clients of rooms when permissions of topics change.
+	// The datastore of SpiceDB must support the Watch API.
+	WatchPermissions bool `env:"WS_WATCH_PERMISSIONS" default:"true"`
+}
+
+const watcherGroupID = "chat-messages-watcher"
+
+// returns conf.InstanceID or hostname with a random suffix.
+func instanceID(conf *Config) string {
+	if conf.InstanceID != "" {
+		return conf.InstanceID
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "ws"
+	}
+	return fmt.Sprintf("%s-%08x", hostname, rand.Uint32())
 }
 
 func getMessageWatcher(conf *Config) (ws.MessageWatcher, error) {
@@ -39,13 +135,20 @@ func getMessageWatcher(conf *Config) (ws.MessageWatcher, error) {
 		return mongoRepo, nil
 
 	case "kafka":
-		kafkaReader := kafka.NewReader(kafka.ReaderConfig{
-			Brokers:  []string{conf.KafkaReader.KafkaHost},
-			Topic:    conf.KafkaReader.Topic,
-			MaxBytes: conf.KafkaReader.MaxBytes,
-			MaxWait:  conf.KafkaReader.MaxWait,
-			GroupID:  "chat-messages-watcher",
-		})
+		readerConf := *conf.KafkaReader
+		readerConf.GroupID = watcherGroupID
+		readerConf.Topic = conf.AppliedTopic
+
+		var kafkaReader kafkarep.MessageReader
+		switch conf.WatcherMode {
+		case "broadcast":
+			kafkaReader = kafkarep.NewBroadcastReader(&readerConf)
+		case "shared":
+			kafkaReader = kafkarep.NewInsecureReader(&readerConf)
+		default:
+			return nil, fmt.Errorf("watcher mode %s not found", conf.WatcherMode)
+		}
+
 		watcher := kafkarep.NewMessageWatcher(kafkaReader)
 
 		return watcher, nil
@@ -54,7 +157,9 @@ func getMessageWatcher(conf *Config) (ws.MessageWatcher, error) {
 	return nil, fmt.Errorf("watcher type %s not found", wType)
 }
 
-func prepare(conf *Config) (*ws.Server, error) {
+func prepare(conf *Config, authn authz.Authenticator) (*ws.Server, error) {
+	conf.InstanceID = instanceID(conf)
+
 	authzed, err := authz.NewInsecureAuthZedCli(authz.Conf{BearerToken: conf.SpiceDBToken, ApiUrl: conf.SpiceDbUrl})
 	if err != nil {
 		return nil, fmt.Errorf("can't create authzed client: %w", err)
@@ -66,7 +171,71 @@ func prepare(conf *Config) (*ws.Server, error) {
 		return nil, err
 	}
 
-	return ws.NewServer(msgWatcher, ws.NewWSAuthorizer(authoriz)), nil
+	// used by websocket "send" command
+	mongoCli := repo.NewInsecureMongoCli(conf.MongoDB)
+	kafkaWriter := kafkarep.NewInsecureWriter(conf.KafkaWriter)
+	messageRepo := kafkarep.NewKafkaRepo(kafkaWriter, mongoCli.Database("chatting2"))
+	messageSvc := messages.NewService(messageRepo, authoriz)
+
+	subscriptionMode, err := ws.ParseSubscriptionMode(conf.SubscriptionMode)
+	if err != nil {
+		return nil, err
+	}
+
+	slowConsumerPolicy, err := ws.ParseSlowConsumerPolicy(conf.SlowConsumer)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []ws.ServerOpt{
+		ws.WithMessageService(messageSvc),
+		ws.WithSubscriptionMode(subscriptionMode),
+		ws.WithHeartbeat(conf.HeartbeatInterval, conf.HeartbeatTimeout),
+		ws.WithSendQueue(conf.SendQueueSize, slowConsumerPolicy),
+		ws.WithBatching(conf.BatchWindow, conf.BatchMinRate),
+		ws.WithSampling(conf.SamplingRate, conf.SamplingMinViewers),
+		ws.WithViewersInterval(conf.ViewersInterval),
+		ws.WithReconnectJitter(conf.ReconnectJitter),
+		ws.WithConnLimits(conf.MaxConnsPerUser, conf.MaxConnsPerIP, conf.MaxConns),
+		ws.WithPermissionWatch(conf.WatchPermissions),
+		ws.WithAuthenticator(authn),
+	}
+
+	allowedOrigins := strings.Split(conf.AllowedOrigins, ",")
+	if _, err := origins.Parse(allowedOrigins...); err != nil {
+		return nil, err
+	}
+	opts = append(opts, ws.WithAllowedOrigin(allowedOrigins...))
+
+	if conf.TLSCertFile != "" || conf.TLSKeyFile != "" {
+		if conf.TLSCertFile == "" || conf.TLSKeyFile == "" {
+			return nil, fmt.Errorf("both WS_TLS_CERT_FILE and WS_TLS_KEY_FILE are required for TLS")
+		}
+		opts = append(opts, ws.WithTLS(conf.TLSCertFile, conf.TLSKeyFile))
+	}
+	if conf.AllowAnonymous {
+		opts = append(opts, ws.WithAnonymousClients())
+	}
+	if conf.DebugAddr != "" {
+		opts = append(opts, ws.WithDebugEndpoints(conf.DebugAddr))
+	}
+	if conf.Compression {
+		opts = append(opts, ws.WithCompression())
+	}
+
+	switch conf.Presence {
+	case "local":
+	case "mongo":
+		store, err := presence.NewMongoStore(context.TODO(), mongoCli.Database("chatting2"))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ws.WithPresence(presence.NewDistributedService[ws.Client](store, conf.InstanceID, conf.PresenceTTL)))
+	default:
+		return nil, fmt.Errorf("presence %s not found", conf.Presence)
+	}
+
+	return ws.NewServer(msgWatcher, ws.NewWSAuthorizer(authoriz), opts...), nil
 }
 
 func main() {
@@ -78,6 +247,7 @@ func main() {
 	observeOpts := observe.Options().
 		WithService("ws-server", "chatting").
 		EnableTraceProvider().
+		EnableMeterProvider().
 		EnableLoggerProvider()
 
 	otelShutdown, err := observe.SetupOTelSDK(context.TODO(), observeOpts)
@@ -91,7 +261,17 @@ func main() {
 		panic(err)
 	}
 
-	wsServer, err := prepare(conf)
+	authn, err := authz.NewJWTAuthenticator(authz.JWTConf{
+		JWKSFile: conf.JWKSFile,
+		Issuer:   conf.JWTIssuer,
+		Audience: conf.JWTAudience,
+		Leeway:   conf.JWTLeeway,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	wsServer, err := prepare(conf, authn)
 	if err != nil {
 		panic(err)
 	}
@@ -99,16 +279,41 @@ func main() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+
 	go func() {
+		for range hupCh {
+			if err := authn.Reload(); err != nil {
+				slog.Error("can not reload jwks", "err", err)
+			}
+			if conf.TLSCertFile == "" {
+				continue
+			}
+			if err := wsServer.ReloadCertificate(); err != nil {
+				slog.Error("can not reload tls certificate", "err", err)
+			}
+		}
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
 		<-sigCh
-		ctx, _ := context.WithTimeout(context.Background(), 2*time.Second)
-		err := wsServer.Shutdown(ctx)
-		slog.Error("shutdown websocket failed", "err", err)
-		panic("shutdown failed: " + err.Error())
+
+		ctx, cancel := context.WithTimeout(context.Background(), conf.DrainTimeout)
+		defer cancel()
+
+		if err := wsServer.Shutdown(ctx); err != nil {
+			slog.Error("shutdown websocket failed", "err", err)
+		}
 	}()
 
-	if err := wsServer.ListenAndServe(":7100"); err != nil {
+	if err := wsServer.ListenAndServe(":7100"); !errors.Is(err, http.ErrServerClosed) {
 		slog.Error("Listen failed", "err", err)
 		panic("listen failed: " + err.Error())
 	}
+
+	<-drained
+	slog.Info("websocket server is drained")
 }
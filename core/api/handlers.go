@@ -1,6 +1,7 @@
 package api
 
 import (
+	"chat-system/core/members"
 	"chat-system/core/messages"
 	"context"
 	"errors"
@@ -12,6 +13,12 @@ import (
 type MessageService interface {
 	ListMessages(ctx context.Context, topicID string, p messages.Pagination) ([]messages.Message, error)
 	SendMessage(ctx context.Context, topicID string, message string) (messages.Message, error)
+	EditMessage(ctx context.Context, topicID, messageID string, version uint, text string) (messages.Message, error)
+	DeleteMessage(ctx context.Context, topicID, messageID string, version uint) error
+}
+
+type PresenceService interface {
+	TopicMembersPresence(ctx context.Context, topicID string) ([]members.Presence, error)
 }
 
 type Handler struct {
@@ -64,10 +71,59 @@ func (h *Handler) sendMessage(ctx context.Context, input *sendMessageInput) (*Re
 	return &ResBody[messages.Message]{Body: msg}, err
 }
 
+func (h *Handler) editMessage(ctx context.Context, input *editMessageInput) (*ResBody[messages.Message], error) {
+	id, version, err := input.ID.GetIdVersion()
+	if err != nil {
+		return nil, huma.Error422UnprocessableEntity(err.Error())
+	}
+
+	msg, err := h.svc.EditMessage(ctx, input.TopicID, id, version, input.Body.Message)
+	if err != nil {
+		return nil, humaErr(err)
+	}
+	return &ResBody[messages.Message]{Body: msg}, nil
+}
+
+func (h *Handler) deleteMessage(ctx context.Context, input *deleteMessageInput) (*struct{}, error) {
+	id, version, err := input.ID.GetIdVersion()
+	if err != nil {
+		return nil, huma.Error422UnprocessableEntity(err.Error())
+	}
+
+	err = h.svc.DeleteMessage(ctx, input.TopicID, id, version)
+	if err != nil {
+		return nil, humaErr(err)
+	}
+	return nil, nil
+}
+
+type membersHandler struct {
+	svc PresenceService
+}
+
+func (h *membersHandler) membersPresence(ctx context.Context, in *membersPresenceInput) (*membersPresenceOutput, error) {
+	presence, err := h.svc.TopicMembersPresence(ctx, in.TopicID)
+	if err != nil {
+		return nil, humaErr(err)
+	}
+
+	res := &membersPresenceOutput{}
+	res.Body.Members = presence
+	return res, nil
+}
+
 func humaErr(err error) error {
-	if errors.Is(err, messages.ErrNotAuthorized{}) {
+	if errors.As(err, &messages.ErrNotAuthorized{}) {
 		return huma.Error403Forbidden("not authorized")
 	}
 
+	if errors.As(err, &messages.ErrNotFound{}) {
+		return huma.Error404NotFound(err.Error())
+	}
+
+	if errors.As(err, &messages.ErrVersionConflict{}) {
+		return huma.Error409Conflict(err.Error())
+	}
+
 	return err
 }
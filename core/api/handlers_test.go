@@ -51,6 +51,14 @@ func (s mockService) SendMessage(ctx context.Context, topicID string, message st
 	return messages.Message{ID: "id_test"}, s.err
 }
 
+func (s mockService) EditMessage(ctx context.Context, topicID, messageID string, version uint, text string) (messages.Message, error) {
+	return messages.Message{ID: messageID, Version: version + 1, Text: text}, s.err
+}
+
+func (s mockService) DeleteMessage(ctx context.Context, topicID, messageID string, version uint) error {
+	return s.err
+}
+
 func TestHandler_listMessages(t *testing.T) {
 	_, api := humatest.New(t)
 	mockSvc := &mockService{}
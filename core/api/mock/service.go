@@ -10,6 +10,7 @@
 package mock_api
 
 import (
+	members "chat-system/core/members"
 	messages "chat-system/core/messages"
 	context "context"
 	reflect "reflect"
@@ -69,3 +70,70 @@ func (mr *MockMessageServiceMockRecorder) SendMessage(ctx, topicID, message any)
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockMessageService)(nil).SendMessage), ctx, topicID, message)
 }
+
+// EditMessage mocks base method.
+func (m *MockMessageService) EditMessage(ctx context.Context, topicID, messageID string, version uint, text string) (messages.Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EditMessage", ctx, topicID, messageID, version, text)
+	ret0, _ := ret[0].(messages.Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EditMessage indicates an expected call of EditMessage.
+func (mr *MockMessageServiceMockRecorder) EditMessage(ctx, topicID, messageID, version, text any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EditMessage", reflect.TypeOf((*MockMessageService)(nil).EditMessage), ctx, topicID, messageID, version, text)
+}
+
+// DeleteMessage mocks base method.
+func (m *MockMessageService) DeleteMessage(ctx context.Context, topicID, messageID string, version uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMessage", ctx, topicID, messageID, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMessage indicates an expected call of DeleteMessage.
+func (mr *MockMessageServiceMockRecorder) DeleteMessage(ctx, topicID, messageID, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMessage", reflect.TypeOf((*MockMessageService)(nil).DeleteMessage), ctx, topicID, messageID, version)
+}
+
+// MockPresenceService is a mock of PresenceService interface.
+type MockPresenceService struct {
+	ctrl     *gomock.Controller
+	recorder *MockPresenceServiceMockRecorder
+}
+
+// MockPresenceServiceMockRecorder is the mock recorder for MockPresenceService.
+type MockPresenceServiceMockRecorder struct {
+	mock *MockPresenceService
+}
+
+// NewMockPresenceService creates a new mock instance.
+func NewMockPresenceService(ctrl *gomock.Controller) *MockPresenceService {
+	mock := &MockPresenceService{ctrl: ctrl}
+	mock.recorder = &MockPresenceServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPresenceService) EXPECT() *MockPresenceServiceMockRecorder {
+	return m.recorder
+}
+
+// TopicMembersPresence mocks base method.
+func (m *MockPresenceService) TopicMembersPresence(ctx context.Context, topicID string) ([]members.Presence, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TopicMembersPresence", ctx, topicID)
+	ret0, _ := ret[0].([]members.Presence)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TopicMembersPresence indicates an expected call of TopicMembersPresence.
+func (mr *MockPresenceServiceMockRecorder) TopicMembersPresence(ctx, topicID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TopicMembersPresence", reflect.TypeOf((*MockPresenceService)(nil).TopicMembersPresence), ctx, topicID)
+}
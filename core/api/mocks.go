@@ -14,6 +14,16 @@ func (m MockRepo) DeleteMessage(ctx context.Context, msg *messages.Message) erro
 	panic("unimplemented")
 }
 
+// GetMessage implements messages.Repository.
+func (m MockRepo) GetMessage(ctx context.Context, topicID string, messageID string) (messages.Message, error) {
+	panic("unimplemented")
+}
+
+// EditMessage implements messages.Repository.
+func (m MockRepo) EditMessage(ctx context.Context, msg *messages.Message, text string) (messages.Message, error) {
+	panic("unimplemented")
+}
+
 func (m MockRepo) ListMessages(ctx context.Context, topicID string, p messages.Pagination) ([]messages.Message, error) {
 	res := make([]messages.Message, 0, p.Limit)
 	for i := 0; i < p.Limit; i++ {
@@ -2,7 +2,9 @@ package api
 
 import (
 	"chat-system/authz"
+	"chat-system/core/members"
 	"chat-system/core/messages"
+	"chat-system/pkg/origins"
 	"context"
 	"errors"
 	"fmt"
@@ -18,6 +20,8 @@ import (
 	// "github.com/felixge/fgprof"
 	// "github.com/gofiber/fiber/v2/middleware/adaptor"
 	// "github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/pprof"
 	fiberRecover "github.com/gofiber/fiber/v2/middleware/recover"
 	// "github.com/maruel/panicparse/v2/stack/webstack"
@@ -30,6 +34,19 @@ type sendMessageInput struct {
 	}
 }
 
+type editMessageInput struct {
+	TopicID string    `path:"TopicID" maxLength:"30" example:"456" required:"true"`
+	ID      IdVersion `path:"id" maxLength:"45" example:"67a1b2c3d4e5f6a7b8c9d0e1-1" required:"true" doc:"message id and its current version joined by '-'"`
+	Body    struct {
+		Message string `json:"message" minLength:"1" maxLength:"300" required:"true"`
+	}
+}
+
+type deleteMessageInput struct {
+	TopicID string    `path:"TopicID" maxLength:"30" example:"456" required:"true"`
+	ID      IdVersion `path:"id" maxLength:"45" example:"67a1b2c3d4e5f6a7b8c9d0e1-1" required:"true" doc:"message id and its current version joined by '-'"`
+}
+
 type getMessagesInput struct {
 	TopicID  string `path:"TopicID" maxLength:"30" example:"456" required:"true"`
 	Limit    int    `query:"limit" minimum:"1" maximum:"50" default:"20"`
@@ -45,21 +62,46 @@ type getMessagesOutput struct {
 	}
 }
 
+type membersPresenceInput struct {
+	TopicID string `path:"TopicID" maxLength:"30" example:"456" required:"true"`
+}
+
+type membersPresenceOutput struct {
+	Body struct {
+		Members []members.Presence `json:"members"`
+	}
+}
+
 type ResBody[T any] struct {
 	Body T
 }
 
-func setFiberMiddleWares(app *fiber.App) {
+func setFiberMiddleWares(app *fiber.App, allowedOrigins *origins.Policy, authn authz.Authenticator) {
 	// app.Use(requestid.New())
 	// app.Use(logger.New())
 	app.Use(pprof.New())
 	app.Use(fiberRecover.New())
-	app.Use(authz.NewFiberAuthMiddleware())
+	app.Use(corsMiddleware(allowedOrigins))
+	app.Use(healthcheck.New()) // probes are not authenticated
+	app.Use(authz.NewFiberAuthMiddleware(authn))
 	// app.Get("/debug/fgprof", adaptor.HTTPHandler(fgprof.Handler()))
 	// app.Get("go", adaptor.HTTPHandlerFunc(webstack.SnapshotHandler))
 	app.Use(otelfiber.Middleware(otelfiber.WithTracerProvider(otel.GetTracerProvider())))
 }
 
+// corsMiddleware allows the same origins as websocket handshakes.
+// Requests are authenticated by the Authorization header, so credentials
+// (cookies) are never allowed, and any origin can not act on behalf of visitors.
+func corsMiddleware(allowedOrigins *origins.Policy) fiber.Handler {
+	switch {
+	case allowedOrigins.Empty():
+		return func(c *fiber.Ctx) error { return c.Next() }
+	case allowedOrigins.AllowsAny():
+		return cors.New(cors.Config{AllowOrigins: "*"})
+	}
+	return cors.New(cors.Config{AllowOriginsFunc: allowedOrigins.Allowed})
+}
+
 func registerEndpoints(api huma.API, handler Handler) {
 	huma.Register(api, huma.Operation{
 		OperationID: "list-messages",
@@ -74,12 +116,48 @@ func registerEndpoints(api huma.API, handler Handler) {
 		Path:          "/topics/{TopicID}/messages",
 		DefaultStatus: 201,
 	}, handler.sendMessage)
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "edit-message",
+		Summary:       "Editing text of a message",
+		Description:   "The edit is applied asynchronously, and it's pushed to websocket clients once applied.",
+		Method:        "PATCH",
+		Path:          "/topics/{TopicID}/messages/{id}",
+		DefaultStatus: 202,
+	}, handler.editMessage)
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-message",
+		Summary:       "Deleting a message",
+		Description:   "The deletion is applied asynchronously, and it's pushed to websocket clients once applied.",
+		Method:        "DELETE",
+		Path:          "/topics/{TopicID}/messages/{id}",
+		DefaultStatus: 202,
+	}, handler.deleteMessage)
 }
 
-func Initialize(messageSVC MessageService) (*fiber.App, error) {
+func registerMembersEndpoints(api huma.API, handler membersHandler) {
+	huma.Register(api, huma.Operation{
+		OperationID: "members-presence",
+		Summary:     "Online status and last seen time of topic's members",
+		Method:      "GET",
+		Path:        "/topics/{TopicID}/members/presence",
+	}, handler.membersPresence)
+}
+
+// Initialize creates the REST API. Members endpoints are registered
+// only if presenceSVC is not nil. Cross-origin requests are allowed from
+// origins matching allowedOrigins patterns, see [origins.Parse].
+// Requests are authenticated by authn, and get 401 responses without valid credentials.
+func Initialize(messageSVC MessageService, presenceSVC PresenceService, allowedOrigins []string, authn authz.Authenticator) (*fiber.App, error) {
+	policy, err := origins.Parse(allowedOrigins...)
+	if err != nil {
+		return nil, err
+	}
+
 	app := fiber.New()
 
-	setFiberMiddleWares(app)
+	setFiberMiddleWares(app, policy, authn)
 	// otel.ServeFiberPromMetrics("/metrics", app)
 
 	api := humafiber.New(app, huma.DefaultConfig("Chat API", "0.0.0-alpha-0"))
@@ -95,6 +173,10 @@ func Initialize(messageSVC MessageService) (*fiber.App, error) {
 
 	registerEndpoints(api, handler)
 
+	if presenceSVC != nil {
+		registerMembersEndpoints(api, membersHandler{presenceSVC})
+	}
+
 	return app, nil
 }
 
@@ -9,8 +9,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"chat-system/authz"
 	mock_api "chat-system/core/api/mock"
+	"chat-system/core/members"
 	"chat-system/core/messages"
 
 	"github.com/danielgtaylor/huma/v2/humatest"
@@ -122,6 +125,138 @@ func Test_restSendMessage(t *testing.T) {
 	}
 }
 
+func Test_restEditMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	tests := []struct {
+		name            string
+		status          int
+		idVersion       string
+		svcErr          error
+		expectSvcCalled bool
+	}{
+		{"normal-req", http.StatusAccepted, "msgid42-3", nil, true},
+		{"malformed-id-version", http.StatusUnprocessableEntity, "msg-id-1-3", nil, false},
+		{"without-version", http.StatusUnprocessableEntity, "msgid42", nil, false},
+		{"stale-version", http.StatusConflict, "msgid42-3", messages.ErrVersionConflict{ID: "msgid42", Version: 3}, true},
+		{"not-found", http.StatusNotFound, "msgid42-3", messages.ErrNotFound{Type: "message", ID: "msgid42"}, true},
+		{"not-sender", http.StatusForbidden, "msgid42-3", messages.ErrNotAuthorized{Subject: "u", ResorceType: "message", ResorceId: "msgid42"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_api.NewMockMessageService(ctrl)
+
+			_, api := humatest.New(t)
+			handler := Handler{
+				m,
+				"http://test",
+			}
+
+			registerEndpoints(api, handler)
+
+			if tt.expectSvcCalled {
+				m.
+					EXPECT().
+					EditMessage(gomock.AssignableToTypeOf(contextType), gomock.Eq("topic-id-1"), gomock.Eq("msgid42"), gomock.Eq(uint(3)), gomock.Eq("edited")).
+					DoAndReturn(func(context.Context, string, string, uint, string) (messages.Message, error) {
+						return messages.Message{ID: "msgid42", Version: 4, Text: "edited"}, tt.svcErr
+					})
+			}
+
+			resp := api.Patch(fmt.Sprintf("/topics/topic-id-1/messages/%s", tt.idVersion), map[string]string{"message": "edited"})
+			if resp.Code != tt.status {
+				t.Fatal("Unexpected status code", resp.Code, "wants", tt.status)
+			}
+
+			if resp.Code == http.StatusAccepted && !strings.Contains(resp.Body.String(), `"v":4`) {
+				t.Fatal("unexpected response body, got:", resp.Body.String())
+			}
+		})
+	}
+}
+
+func Test_restDeleteMessage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	tests := []struct {
+		name            string
+		status          int
+		idVersion       string
+		svcErr          error
+		expectSvcCalled bool
+	}{
+		{"normal-req", http.StatusAccepted, "msgid42-3", nil, true},
+		{"malformed-id-version", http.StatusUnprocessableEntity, "msgid42", nil, false},
+		{"stale-version", http.StatusConflict, "msgid42-3", messages.ErrVersionConflict{ID: "msgid42", Version: 3}, true},
+		{"not-found", http.StatusNotFound, "msgid42-3", messages.ErrNotFound{Type: "message", ID: "msgid42"}, true},
+		{"not-authorized", http.StatusForbidden, "msgid42-3", messages.ErrNotAuthorized{Subject: "u", ResorceType: "message", ResorceId: "msgid42"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_api.NewMockMessageService(ctrl)
+
+			_, api := humatest.New(t)
+			handler := Handler{
+				m,
+				"http://test",
+			}
+
+			registerEndpoints(api, handler)
+
+			if tt.expectSvcCalled {
+				m.
+					EXPECT().
+					DeleteMessage(gomock.AssignableToTypeOf(contextType), gomock.Eq("topic-id-1"), gomock.Eq("msgid42"), gomock.Eq(uint(3))).
+					Return(tt.svcErr)
+			}
+
+			resp := api.Delete(fmt.Sprintf("/topics/topic-id-1/messages/%s", tt.idVersion))
+			if resp.Code != tt.status {
+				t.Fatal("Unexpected status code", resp.Code, "wants", tt.status)
+			}
+		})
+	}
+}
+
+func Test_restMembersPresence(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lastSeen := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		status int
+		svcErr error
+	}{
+		{"normal-req", http.StatusOK, nil},
+		{"not-authorized", http.StatusForbidden, messages.ErrNotAuthorized{Subject: "u", ResorceType: "topic", ResorceId: "topic-id-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mock_api.NewMockPresenceService(ctrl)
+
+			_, api := humatest.New(t)
+			registerMembersEndpoints(api, membersHandler{m})
+
+			m.
+				EXPECT().
+				TopicMembersPresence(gomock.AssignableToTypeOf(contextType), gomock.Eq("topic-id-1")).
+				Return([]members.Presence{{UserId: "u1", Online: true}, {UserId: "u2", LastSeen: &lastSeen}}, tt.svcErr)
+
+			resp := api.Get("/topics/topic-id-1/members/presence")
+			if resp.Code != tt.status {
+				t.Fatal("Unexpected status code", resp.Code, "wants", tt.status)
+			}
+
+			if resp.Code == 200 && !strings.Contains(resp.Body.String(), `"lastSeen":"2025-01-02T03:04:05Z"`) {
+				t.Fatal("unexpected response body, got:", resp.Body.String())
+			}
+		})
+	}
+}
+
 func Test404(t *testing.T) {
 	_, api := humatest.New(t)
 	handler := Handler{
@@ -136,3 +271,98 @@ func Test404(t *testing.T) {
 		t.Fatal("Unexpected status code", resp.Code)
 	}
 }
+
+// tokens are userIds, except "invalid".
+type mockAuthenticator struct{}
+
+func (mockAuthenticator) Authenticate(token string) (string, time.Time, error) {
+	if token == "invalid" {
+		return "", time.Time{}, authz.ErrUnauthenticated
+	}
+	return token, time.Time{}, nil
+}
+
+func Test_restAuthentication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	m := mock_api.NewMockMessageService(ctrl)
+	m.EXPECT().ListMessages(gomock.Any(), "topic", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ string, _ messages.Pagination) ([]messages.Message, error) {
+			if userId := authz.UserIdFromCtx(ctx); userId != "alice" {
+				t.Errorf("the subject should be the user, got %q", userId)
+			}
+			return nil, nil
+		})
+
+	app, err := Initialize(m, nil, nil, mockAuthenticator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"authenticated", "/topics/topic/messages", "Bearer alice", http.StatusOK},
+		{"missing", "/topics/topic/messages", "", http.StatusUnauthorized},
+		{"cookie", "/topics/topic/messages", "", http.StatusUnauthorized},
+		{"invalid", "/topics/topic/messages", "Bearer invalid", http.StatusUnauthorized},
+		{"healthcheck", "/livez", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			req.Header.Set("Cookie", authz.AccessTokenCookie+"=alice") // cookies are not accepted
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_restCORS(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		allowOrigin    string
+		credentials    bool
+	}{
+		{"any-origin-without-credentials", []string{"*"}, "https://attacker.com", "*", false},
+		{"listed-origin", []string{"https://*.example.com"}, "https://app.example.com", "https://app.example.com", false},
+		{"not-listed-origin", []string{"https://*.example.com"}, "https://attacker.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := Initialize(nil, nil, tt.allowedOrigins, mockAuthenticator{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest("GET", "/livez", nil)
+			req.Header.Set("Origin", tt.origin)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("expected allowed origin %q, got %q", tt.allowOrigin, got)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Credentials") == "true"; got != tt.credentials {
+				t.Errorf("expected credentials %v, got %v", tt.credentials, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package members
+
+import (
+	"chat-system/authz"
+	"chat-system/core/messages"
+	"context"
+	"slices"
+	"time"
+)
+
+// Presence is the online status of a topic's member.
+type Presence struct {
+	UserId   string     `json:"userId"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"` // only for offline users
+}
+
+type presenceStore interface {
+	// returns at most limit users among userIds which are online.
+	OnlineUsers(ctx context.Context, userIds []string, limit int) ([]string, error)
+
+	// returns the last time which users were online.
+	LastSeen(ctx context.Context, userIds []string) (map[string]time.Time, error)
+}
+
+type authorizer interface {
+	Check(ctx context.Context, userId, relation, objType, objId string) (bool, error)
+	WhoHasRel(ctx context.Context, relation, objType, objId string) ([]string, error)
+}
+
+func NewService(store presenceStore, authz authorizer) *svc {
+	return &svc{store, authz}
+}
+
+type svc struct {
+	store presenceStore
+	authz authorizer
+}
+
+// TopicMembersPresence returns presence of users who can read the topic,
+// if the user in ctx can read it.
+func (s *svc) TopicMembersPresence(ctx context.Context, topicID string) ([]Presence, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if topicID == "" {
+		return nil, messages.ErrEmptyTopicId
+	}
+
+	userId := authz.UserIdFromCtx(ctx)
+	can, err := s.authz.Check(ctx, userId, "read", "topic", topicID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !can {
+		return nil, messages.ErrNotAuthorized{Subject: userId, ResorceType: "topic", ResorceId: topicID}
+	}
+
+	members, err := s.authz.WhoHasRel(ctx, "read", "topic", topicID)
+	if err != nil {
+		return nil, err
+	}
+
+	online, err := s.store.OnlineUsers(ctx, members, len(members))
+	if err != nil {
+		return nil, err
+	}
+
+	lastSeen, err := s.store.LastSeen(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Presence, 0, len(members))
+	for _, member := range members {
+		p := Presence{UserId: member, Online: slices.Contains(online, member)}
+
+		if t, seen := lastSeen[member]; seen && !p.Online {
+			p.LastSeen = &t
+		}
+		res = append(res, p)
+	}
+
+	return res, nil
+}
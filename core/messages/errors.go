@@ -20,3 +20,12 @@ type ErrNotAuthorized struct {
 func (e ErrNotAuthorized) Error() string {
 	return fmt.Sprintf("subject %s can not access %s with id %s", e.Subject, e.ResorceType, e.ResorceId)
 }
+
+type ErrVersionConflict struct {
+	ID      string
+	Version uint
+}
+
+func (e ErrVersionConflict) Error() string {
+	return fmt.Sprintf("version %d of message '%s' is stale", e.Version, e.ID)
+}
@@ -13,5 +13,19 @@ type permissionChecker interface {
 type Repository interface {
 	ListMessages(ctx context.Context, topicID string, p Pagination) ([]Message, error)
 	SendMsgToTopic(ctx context.Context, sender Sender, topicID string, message string) (Message, error)
+
+	// deletes msg if its version is still msg.Version.
+	// It may be applied after returning, see EditMessage.
 	DeleteMessage(ctx context.Context, msg *Message) error
+
+	// returns the message with specified id or [ErrNotFound].
+	GetMessage(ctx context.Context, topicID string, messageID string) (Message, error)
+
+	// replaces the text of msg if its version is still msg.Version
+	// and returns the edited message with the next version.
+	//
+	// The edit may be applied after returning, and it is skipped if another
+	// change is applied before it, so the returned message is only accepted,
+	// not stored. Applied edits are pushed to watchers of the topic.
+	EditMessage(ctx context.Context, msg *Message, text string) (Message, error)
 }
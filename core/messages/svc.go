@@ -18,12 +18,18 @@ func NewService(repo Repository, auth permissionChecker) *svc {
 type Sender struct{ ID string }
 
 type Message struct {
-	SenderId string    `json:"senderId"`
-	ID       string    `json:"id"`
-	Version  uint      `json:"v"`
-	TopicID  string    `json:"topicId"`
-	SentAt   time.Time `json:"sentAt"`
-	Text     string    `json:"text"`
+	SenderId string `json:"senderId"`
+	ID       string `json:"id"`
+	Version  uint   `json:"v"`
+
+	// Seq increases by one for each message of the topic,
+	// so clients can detect missing messages.
+	// It is 0 if the message is not stored yet.
+	Seq uint64 `json:"seq"`
+
+	TopicID string    `json:"topicId"`
+	SentAt  time.Time `json:"sentAt"`
+	Text    string    `json:"text"`
 }
 
 func (m *Message) MarshalJSON() ([]byte, error) {
@@ -42,6 +48,10 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 	s, _ = json.Marshal(m.Version)
 	sb.Write(s)
 
+	sb.WriteString(`,"seq":`)
+	s, _ = json.Marshal(m.Seq)
+	sb.Write(s)
+
 	sb.WriteString(`,"topicId":`)
 	s, _ = json.Marshal(m.TopicID)
 	sb.Write(s)
@@ -114,3 +124,101 @@ func (s *svc) SendMessage(ctx context.Context, topicID string, message string) (
 	msg, err := s.repo.SendMsgToTopic(ctx, Sender{ID: userId}, topicID, message)
 	return msg, err
 }
+
+// EditMessage replaces the text of the message with specified messageID.
+//
+// Only the sender of the message can edit it, and version must be
+// the current version of the message, otherwise [ErrVersionConflict] is returned.
+//
+// The edit is eventually consistent: the returned message is accepted but
+// may not be stored yet, and a concurrent change of the same version wins, see [Repository].
+func (s *svc) EditMessage(ctx context.Context, topicID, messageID string, version uint, text string) (Message, error) {
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
+
+	if topicID == "" {
+		return Message{}, ErrEmptyTopicId
+	}
+
+	userId := authz.UserIdFromCtx(ctx)
+
+	can, err := s.authz.Check(ctx, userId, "write", "topic", topicID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if !can {
+		return Message{}, ErrNotAuthorized{Subject: userId, ResorceType: "topic", ResorceId: topicID}
+	}
+
+	msg, err := s.repo.GetMessage(ctx, topicID, messageID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if msg.SenderId != userId {
+		return Message{}, ErrNotAuthorized{Subject: userId, ResorceType: "message", ResorceId: messageID}
+	}
+
+	if msg.Version != version {
+		return Message{}, ErrVersionConflict{ID: messageID, Version: version}
+	}
+
+	return s.repo.EditMessage(ctx, &msg, text)
+}
+
+// DeleteMessage deletes the message with specified messageID.
+//
+// Users who can not read the topic get [ErrNotAuthorized] before the message is loaded,
+// so they can not tell whether it exists. The sender of the message needs "write"
+// permission on the topic, like [svc.EditMessage], so users who left the topic
+// can not delete their messages. Other users need "delete" permission on the topic
+// (e.g. moderators).
+// version must be the current version of the message, otherwise [ErrVersionConflict] is returned.
+// Like [svc.EditMessage], the deletion is eventually consistent.
+func (s *svc) DeleteMessage(ctx context.Context, topicID, messageID string, version uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if topicID == "" {
+		return ErrEmptyTopicId
+	}
+
+	userId := authz.UserIdFromCtx(ctx)
+
+	can, err := s.authz.Check(ctx, userId, "read", "topic", topicID)
+	if err != nil {
+		return err
+	}
+
+	if !can {
+		return ErrNotAuthorized{Subject: userId, ResorceType: "topic", ResorceId: topicID}
+	}
+
+	msg, err := s.repo.GetMessage(ctx, topicID, messageID)
+	if err != nil {
+		return err
+	}
+
+	perm := "delete"
+	if msg.SenderId == userId {
+		perm = "write"
+	}
+
+	can, err = s.authz.Check(ctx, userId, perm, "topic", topicID)
+	if err != nil {
+		return err
+	}
+
+	if !can {
+		return ErrNotAuthorized{Subject: userId, ResorceType: "message", ResorceId: messageID}
+	}
+
+	if msg.Version != version {
+		return ErrVersionConflict{ID: messageID, Version: version}
+	}
+
+	return s.repo.DeleteMessage(ctx, &msg)
+}
@@ -0,0 +1,109 @@
+package messages
+
+import (
+	"chat-system/authz"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// fakeRepo stores one message, and records deleted messages.
+type fakeRepo struct {
+	Repository
+	msg     Message
+	gets    int
+	deleted []Message
+}
+
+func (r *fakeRepo) GetMessage(ctx context.Context, topicID string, messageID string) (Message, error) {
+	r.gets++
+	if r.msg.TopicID != topicID || r.msg.ID != messageID {
+		return Message{}, ErrNotFound{Type: "message", ID: messageID}
+	}
+	return r.msg, nil
+}
+
+func (r *fakeRepo) DeleteMessage(ctx context.Context, msg *Message) error {
+	r.deleted = append(r.deleted, *msg)
+	return nil
+}
+
+// fakePermissions grants perms to users, and records checks.
+type fakePermissions struct {
+	granted map[string][]string // userId -> permissions on all topics
+	checked []string
+}
+
+func (p *fakePermissions) Check(ctx context.Context, userId, perm, objType, objId string) (bool, error) {
+	p.checked = append(p.checked, userId+":"+perm)
+	for _, granted := range p.granted[userId] {
+		if granted == perm {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestSvc_DeleteMessage(t *testing.T) {
+	msg := Message{ID: "msg", TopicID: "topic", SenderId: "alice", Version: 2}
+
+	tests := []struct {
+		name       string
+		userId     string
+		messageId  string
+		version    uint
+		granted    map[string][]string
+		wantChecks []string
+		wantErr    error
+	}{
+		{"sender", "alice", "msg", 2, map[string][]string{"alice": {"read", "write"}}, []string{"alice:read", "alice:write"}, nil},
+		{"sender-without-write", "alice", "msg", 2, map[string][]string{"alice": {"read"}}, []string{"alice:read", "alice:write"}, ErrNotAuthorized{}},
+		{"moderator", "bob", "msg", 2, map[string][]string{"bob": {"read", "write", "delete"}}, []string{"bob:read", "bob:delete"}, nil},
+		{"not-sender-nor-moderator", "bob", "msg", 2, map[string][]string{"bob": {"read", "write"}}, []string{"bob:read", "bob:delete"}, ErrNotAuthorized{}},
+		{"stale-version", "alice", "msg", 1, map[string][]string{"alice": {"read", "write"}}, []string{"alice:read", "alice:write"}, ErrVersionConflict{}},
+		{"not-reader", "alice", "msg", 2, map[string][]string{"alice": {"write"}}, []string{"alice:read"}, ErrNotAuthorized{}},
+		{"not-reader-missing-message", "bob", "missing", 2, map[string][]string{}, []string{"bob:read"}, ErrNotAuthorized{}},
+		{"missing-message", "bob", "missing", 2, map[string][]string{"bob": {"read"}}, []string{"bob:read"}, ErrNotFound{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{msg: msg}
+			perms := &fakePermissions{granted: tt.granted}
+			s := NewService(repo, perms)
+
+			ctx := context.WithValue(context.Background(), authz.UserIdCtxKey, tt.userId)
+			err := s.DeleteMessage(ctx, "topic", tt.messageId, tt.version)
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+			case ErrNotAuthorized:
+				if !errors.As(err, &want) {
+					t.Fatalf("expected ErrNotAuthorized, got %v", err)
+				}
+			case ErrVersionConflict:
+				if !errors.As(err, &want) {
+					t.Fatalf("expected ErrVersionConflict, got %v", err)
+				}
+			case ErrNotFound:
+				if !errors.As(err, &want) {
+					t.Fatalf("expected ErrNotFound, got %v", err)
+				}
+			}
+
+			if !slices.Equal(perms.checked, tt.wantChecks) {
+				t.Errorf("permissions %v should be checked, got %v", tt.wantChecks, perms.checked)
+			}
+			if loaded := repo.gets != 0; loaded != slices.Contains(tt.granted[tt.userId], "read") {
+				t.Errorf("the message should be loaded only if the user can read the topic, loaded %d times", repo.gets)
+			}
+			if deleted := len(repo.deleted) == 1; deleted != (tt.wantErr == nil) {
+				t.Errorf("message should be deleted only without errors, deleted %v", repo.deleted)
+			}
+		})
+	}
+}
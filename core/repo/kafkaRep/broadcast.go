@@ -0,0 +1,187 @@
+package kafkarep
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	minPartitionsLookupBackoff = time.Second
+	maxPartitionsLookupBackoff = 30 * time.Second
+	partitionReadRetryDelay    = time.Second
+)
+
+// interface for [kafka.Reader] which watchers read.
+type MessageReader interface {
+	// see [kafka.Reader.ReadMessage]
+	ReadMessage(context.Context) (kafka.Message, error)
+
+	Close() error
+}
+
+// BroadcastReader reads all partitions of the topic from their last offsets,
+// by a [kafka.Reader] for each partition. It does not join a consumer group,
+// so every instance receives every message, and nothing is left in kafka
+// by instances which stop or restart with another id.
+//
+// Partitions are looked up when reading starts, so partitions
+// which are added later are not read until restarting.
+type BroadcastReader struct {
+	lookupPartitions func(context.Context) ([]int, error)
+	newReader        func(partition int) MessageReader
+
+	start  sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+	msgs   chan kafka.Message
+}
+
+// NewBroadcastReader returns a [BroadcastReader] of conf.Topic. conf.GroupID is not used.
+func NewBroadcastReader(conf *ReaderConf) *BroadcastReader {
+	lookup := func(ctx context.Context) ([]int, error) {
+		partitions, err := kafka.LookupPartitions(ctx, "tcp", conf.KafkaHost, conf.Topic)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]int, 0, len(partitions))
+		for _, p := range partitions {
+			ids = append(ids, p.ID)
+		}
+		return ids, nil
+	}
+
+	newReader := func(partition int) MessageReader {
+		kafkaConf := partitionReaderConfig(conf, partition)
+		if err := kafkaConf.Validate(); err != nil {
+			panic(err)
+		}
+
+		reader := kafka.NewReader(kafkaConf)
+		// readers without a group ignore StartOffset
+		if err := reader.SetOffset(kafka.LastOffset); err != nil {
+			panic(err)
+		}
+		return reader
+	}
+
+	return newBroadcastReader(lookup, newReader)
+}
+
+func newBroadcastReader(lookupPartitions func(context.Context) ([]int, error), newReader func(partition int) MessageReader) *BroadcastReader {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BroadcastReader{
+		lookupPartitions: lookupPartitions,
+		newReader:        newReader,
+		ctx:              ctx,
+		cancel:           cancel,
+		msgs:             make(chan kafka.Message),
+	}
+}
+
+func partitionReaderConfig(conf *ReaderConf, partition int) kafka.ReaderConfig {
+	return kafka.ReaderConfig{
+		Brokers:   []string{conf.KafkaHost},
+		Topic:     conf.Topic,
+		Partition: partition,
+		MaxBytes:  conf.MaxBytes,
+		MaxWait:   conf.MaxWait,
+		MinBytes:  1,
+	}
+}
+
+// ReadMessage returns the next message of any partition. Messages of each
+// partition are in order. It returns [io.EOF] after [BroadcastReader.Close].
+func (r *BroadcastReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	r.start.Do(func() { go r.run() })
+
+	select {
+	case msg := <-r.msgs:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	case <-r.ctx.Done():
+		return kafka.Message{}, io.EOF
+	}
+}
+
+// Close stops reading partitions.
+func (r *BroadcastReader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// looks up partitions, then reads each of them until closed.
+func (r *BroadcastReader) run() {
+	partitions, ok := r.lookup()
+	if !ok {
+		return
+	}
+
+	slog.Info("reading partitions of the topic", "partitions", len(partitions))
+	for _, p := range partitions {
+		go r.readPartition(p, r.newReader(p))
+	}
+}
+
+// returns partitions of the topic, retrying with backoff until it has any.
+// ok is false if the reader is closed meanwhile.
+func (r *BroadcastReader) lookup() (partitions []int, ok bool) {
+	backoff := minPartitionsLookupBackoff
+
+	for {
+		partitions, err := r.lookupPartitions(r.ctx)
+		if err == nil && len(partitions) != 0 {
+			return partitions, true
+		}
+		if r.ctx.Err() != nil {
+			return nil, false
+		}
+
+		slog.Warn("can not look up partitions of the topic", "retryAfter", backoff, "err", err)
+		select {
+		case <-r.ctx.Done():
+			return nil, false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxPartitionsLookupBackoff)
+	}
+}
+
+func (r *BroadcastReader) readPartition(partition int, reader MessageReader) {
+	defer func() {
+		if err := reader.Close(); err != nil {
+			slog.Error("can not close partition reader", "partition", partition, "err", err)
+		}
+	}()
+
+	for {
+		msg, err := reader.ReadMessage(r.ctx)
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("can not read partition", "partition", partition, "err", err)
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(partitionReadRetryDelay):
+			}
+			continue
+		}
+
+		select {
+		case r.msgs <- msg:
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+var _ MessageReader = &BroadcastReader{}
+var _ MessageReader = &kafka.Reader{}
@@ -0,0 +1,91 @@
+package kafkarep
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakePartitionReader returns msgs, then blocks until ctx is done.
+type fakePartitionReader struct {
+	msgs   chan kafka.Message
+	closed atomic.Bool
+}
+
+func (r *fakePartitionReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.msgs:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakePartitionReader) Close() error {
+	r.closed.Store(true)
+	return nil
+}
+
+func TestBroadcastReader(t *testing.T) {
+	readers := map[int]*fakePartitionReader{}
+	for p := range 3 {
+		readers[p] = &fakePartitionReader{msgs: make(chan kafka.Message, 1)}
+		readers[p].msgs <- kafka.Message{Partition: p}
+	}
+
+	lookups := 0
+	r := newBroadcastReader(func(context.Context) ([]int, error) {
+		lookups++
+		if lookups == 1 {
+			return nil, errors.New("topic is not created yet")
+		}
+		return []int{0, 1, 2}, nil
+	}, func(p int) MessageReader {
+		return readers[p]
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	seen := map[int]bool{}
+	for range 3 {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[msg.Partition] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("messages of all partitions should be read, got %v", seen)
+	}
+
+	r.Close()
+	if _, err := r.ReadMessage(ctx); err != io.EOF {
+		t.Errorf("closed reader should return io.EOF, got %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	for p, reader := range readers {
+		if !reader.closed.Load() {
+			t.Errorf("reader of partition %d should be closed", p)
+		}
+	}
+}
+
+func Test_partitionReaderConfig(t *testing.T) {
+	conf := &ReaderConf{KafkaHost: "kafka:9092", Topic: "topic", MaxBytes: 3000, GroupID: "watcher", MaxWait: time.Second}
+
+	c := partitionReaderConfig(conf, 2)
+	if c.GroupID != "" || c.Partition != 2 {
+		t.Errorf("partitions should be assigned directly without a group, got group %q and partition %d", c.GroupID, c.Partition)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("invalid config: %v", err)
+	}
+}
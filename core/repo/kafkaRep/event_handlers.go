@@ -2,7 +2,7 @@ package kafkarep
 
 import (
 	"chat-system/core/repo"
-	"errors"
+	"log/slog"
 
 	"github.com/kamva/mgm/v3"
 	"go.mongodb.org/mongo-driver/bson"
@@ -14,12 +14,16 @@ import (
 type mongoMessageHandler interface {
 	EventRecieved(MessageEvent)
 	Handle(mongo.SessionContext) error
+
+	// Applied returns events of the last Handle call as they are stored.
+	Applied() []MessageEvent
 }
 
 // A transaction handler [mongoMessageHandler] for event type [EvTypeMessageInserted].
 type mesgInsertedHandler struct {
-	events []MessageInserted
-	coll   mgm.Collection
+	events  []MessageInserted
+	applied []MessageEvent
+	coll    mgm.Collection
 }
 
 // EventRecieved implements messageHandler.
@@ -29,25 +33,36 @@ func (m *mesgInsertedHandler) EventRecieved(me MessageEvent) {
 }
 
 // Handle implements messageHandler.
+//
+// Events of a topic have the same kafka key, so they are in one partition
+// and only this sink writes them. It assigns seq to the messages in the
+// order of the partition, continuing the last bucket of the topic.
 func (m *mesgInsertedHandler) Handle(sc mongo.SessionContext) error {
+	m.applied = m.applied[:0]
+
 	for topicId, events := range groupByTopicId(m.events) {
 
 		msgList := m.extractMessageFromEvents(events)
 
+		last, err := m.lastBucket(sc, topicId)
+		if err != nil {
+			return err
+		}
+
+		for i := range msgList {
+			msgList[i].Seq = last.MaxSeq + uint64(i) + 1
+		}
+
 		agrr := mongoAggr{
 			Topic:    topicId,
 			MinId:    msgList[0].ID,
 			MaxId:    msgList[len(msgList)-1].ID,
+			MaxSeq:   msgList[len(msgList)-1].Seq,
 			Len:      len(msgList),
 			Messages: msgList,
 		}
 
-		merged, err := m.mergeToLastMessage(sc, &agrr)
-		if err != nil {
-			return err
-		}
-
-		if merged {
+		if mergeToBucket(&last, &agrr) {
 			_, err = m.coll.ReplaceOne(sc, bson.M{"_id": agrr.ID}, agrr)
 		} else {
 			_, err = m.coll.InsertOne(sc, agrr)
@@ -56,11 +71,23 @@ func (m *mesgInsertedHandler) Handle(sc mongo.SessionContext) error {
 		if err != nil {
 			return err
 		}
+
+		for i := range events {
+			inserted := events[i]
+			inserted.Msg = msgList[i]
+			m.applied = append(m.applied, &inserted)
+		}
 	}
 
 	return nil
 }
 
+// Applied implements mongoMessageHandler.
+// Messages of the returned events have their seq.
+func (m *mesgInsertedHandler) Applied() []MessageEvent {
+	return m.applied
+}
+
 func (*mesgInsertedHandler) extractMessageFromEvents(events []MessageInserted) []repo.Message {
 	res := make([]repo.Message, 0, len(events))
 
@@ -70,33 +97,38 @@ func (*mesgInsertedHandler) extractMessageFromEvents(events []MessageInserted) [
 	return res
 }
 
-// find last item, if len was low append appends old messages to input *agrr*
-func (m *mesgInsertedHandler) mergeToLastMessage(sc mongo.SessionContext, agrr *mongoAggr) (merged bool, err error) {
-	getAgrr := mongoAggr{}
-	err = m.coll.FirstWithCtx(sc, bson.M{"topicID": agrr.Topic}, &getAgrr, &options.FindOneOptions{
-		Sort: bson.M{"id": 1},
+// returns the bucket which has the last seq of the topic.
+// the returned bucket is empty if the topic has no messages.
+func (m *mesgInsertedHandler) lastBucket(sc mongo.SessionContext, topicId string) (mongoAggr, error) {
+	last := mongoAggr{}
+	err := m.coll.FirstWithCtx(sc, bson.M{"topicID": topicId}, &last, &options.FindOneOptions{
+		Sort: bson.D{{Key: "maxSeq", Value: -1}, {Key: "_id", Value: -1}},
 	})
-	if err != nil {
-		if err != mongo.ErrNoDocuments {
-			return false, err
-		}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return mongoAggr{}, err
 	}
+	return last, nil
+}
 
-	if getAgrr.Len >= 20 || getAgrr.Len == 0 {
-		return false, nil
+// if the last bucket's len was low, appends agrr's messages to it
+// and reuses its ID.
+func mergeToBucket(last, agrr *mongoAggr) (merged bool) {
+	if last.Len >= 20 || last.Len == 0 {
+		return false
 	}
 
-	agrr.Messages = append(getAgrr.Messages, agrr.Messages...)
+	agrr.Messages = append(last.Messages, agrr.Messages...)
 	agrr.Len = len(agrr.Messages)
-	agrr.MinId = getAgrr.MinId
-	agrr.ID = getAgrr.ID
+	agrr.MinId = last.MinId
+	agrr.ID = last.ID
 
-	return true, nil
+	return true
 }
 
 type mesgDeletedHandler struct {
-	events []MessageDeleted
-	coll   mgm.Collection
+	events  []MessageDeleted
+	applied []MessageEvent
+	coll    mgm.Collection
 }
 
 // EventRecieved implements mongoMessageHandler.
@@ -105,16 +137,33 @@ func (m *mesgDeletedHandler) EventRecieved(me MessageEvent) {
 	m.events = append(m.events, *ev)
 }
 
+// Applied implements mongoMessageHandler.
+// Stale events are not returned.
+func (m *mesgDeletedHandler) Applied() []MessageEvent {
+	return m.applied
+}
+
 // Handle implements mongoMessageHandler.
+//
+// If the event has MessageVersion, the message will be deleted
+// only if the stored version equals it. Stale events are skipped.
 func (m *mesgDeletedHandler) Handle(sc mongo.SessionContext) error {
+	m.applied = m.applied[:0]
+
 	for i := range m.events {
 		event := m.events[i]
 		id, _ := primitive.ObjectIDFromHex(event.MessageId)
+
+		elemMatch := bson.M{"_id": id}
+		if event.MessageVersion != 0 {
+			elemMatch["v"] = event.MessageVersion
+		}
+
 		res, err := m.coll.UpdateOne(sc, bson.M{
-			"topicID":      event.TopicID(),
-			"minID":        bson.M{"$lte": id},
-			"maxID":        bson.M{"$gte": id},
-			"messages._id": id,
+			"topicID":  event.TopicID(),
+			"minID":    bson.M{"$lte": id},
+			"maxID":    bson.M{"$gte": id},
+			"messages": bson.M{"$elemMatch": elemMatch},
 		}, bson.M{
 			"$set": bson.M{
 				"messages.$.deleted":    true,
@@ -128,8 +177,74 @@ func (m *mesgDeletedHandler) Handle(sc mongo.SessionContext) error {
 		}
 
 		if res.ModifiedCount == 0 {
-			return errors.New("message not found")
+			slog.Warn("skipping stale message deletion",
+				slog.String("messageId", event.MessageId),
+				slog.Uint64("version", uint64(event.MessageVersion)))
+			continue
+		}
+		m.applied = append(m.applied, &m.events[i])
+	}
+	return nil
+}
+
+// A transaction handler [mongoMessageHandler] for event type [EvTypeTextEdited].
+type mesgTextEditedHandler struct {
+	events  []TextEdited
+	applied []MessageEvent
+	coll    mgm.Collection
+}
+
+// EventRecieved implements mongoMessageHandler.
+func (m *mesgTextEditedHandler) EventRecieved(me MessageEvent) {
+	ev := me.(*TextEdited)
+	m.events = append(m.events, *ev)
+}
+
+// Applied implements mongoMessageHandler.
+// Stale events are not returned.
+func (m *mesgTextEditedHandler) Applied() []MessageEvent {
+	return m.applied
+}
+
+// Handle implements mongoMessageHandler.
+//
+// It rewrites the text and increments the version of the message
+// only if the stored version equals the event's MessageVersion.
+// Stale events are skipped, so replaying a batch is harmless.
+func (m *mesgTextEditedHandler) Handle(sc mongo.SessionContext) error {
+	m.applied = m.applied[:0]
+
+	for i := range m.events {
+		event := m.events[i]
+		id, _ := primitive.ObjectIDFromHex(event.MessageId)
+		res, err := m.coll.UpdateOne(sc, bson.M{
+			"topicID": event.TopicID(),
+			"minID":   bson.M{"$lte": id},
+			"maxID":   bson.M{"$gte": id},
+			"messages": bson.M{"$elemMatch": bson.M{
+				"_id":     id,
+				"v":       event.MessageVersion,
+				"deleted": false,
+			}},
+		}, bson.M{
+			"$set": bson.M{
+				"messages.$.text":       event.NewText,
+				"messages.$.updated_at": event.EditedAt,
+			},
+			"$inc": bson.M{"messages.$.v": 1},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if res.ModifiedCount == 0 {
+			slog.Warn("skipping stale text edit",
+				slog.String("messageId", event.MessageId),
+				slog.Uint64("version", uint64(event.MessageVersion)))
+			continue
 		}
+		m.applied = append(m.applied, &m.events[i])
 	}
 	return nil
 }
@@ -146,3 +261,4 @@ func groupByTopicId[E MessageEvent](events []E) map[string][]E {
 
 var _ mongoMessageHandler = &mesgInsertedHandler{}
 var _ mongoMessageHandler = &mesgDeletedHandler{}
+var _ mongoMessageHandler = &mesgTextEditedHandler{}
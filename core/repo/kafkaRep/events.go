@@ -14,6 +14,7 @@ type EventType string
 const (
 	EvTypeMessageInserted EventType = "message.inserted.v1"
 	EvTypeMessageDeleted  EventType = "message.deleted.v1"
+	EvTypeTextEdited      EventType = "message.edited.v1"
 )
 
 func ValidateEventType(t []byte) (EventType, error) {
@@ -23,7 +24,7 @@ func ValidateEventType(t []byte) (EventType, error) {
 
 	s := EventType(t)
 	switch s {
-	case EvTypeMessageInserted, EvTypeMessageDeleted:
+	case EvTypeMessageInserted, EvTypeMessageDeleted, EvTypeTextEdited:
 		return s, nil
 	}
 
@@ -71,12 +72,22 @@ func (e MessageDeleted) TopicID() string {
 	return e.TopicId
 }
 
+// TextEdited replaces the text of the message if
+// its version is still MessageVersion.
 type TextEdited struct {
 	EventId        EventID   `json:"event_id,omitempty"`
 	EvType         EventType `json:"event_type,omitempty"`
+	TopicId        string    `json:"topic_id"`
 	MessageId      string    `json:"message_id,omitempty"`
+	SenderId       string    `json:"sender_id,omitempty"`
 	MessageVersion uint      `json:"message_version,omitempty"`
 	NewText        string    `json:"new_text,omitempty"`
+	EditedAt       time.Time `json:"edited_at"`
+}
+
+// TopicID implements MessageEvent.
+func (e TextEdited) TopicID() string {
+	return e.TopicId
 }
 
 func (e MessageInserted) EventID() EventID {
@@ -108,6 +119,9 @@ func UnmarshalEvent(t EventType, v []byte) (ev Event, err error) {
 	case EvTypeMessageDeleted:
 		ev = &MessageDeleted{}
 
+	case EvTypeTextEdited:
+		ev = &TextEdited{}
+
 	default:
 		return nil, fmt.Errorf("eventType %s not found", t)
 	}
@@ -118,6 +132,8 @@ func UnmarshalEvent(t EventType, v []byte) (ev Event, err error) {
 
 var _ Event = MessageInserted{}
 var _ Event = MessageDeleted{}
+var _ Event = TextEdited{}
 
 var _ MessageEvent = MessageInserted{}
 var _ MessageEvent = MessageDeleted{}
+var _ MessageEvent = TextEdited{}
@@ -90,8 +90,11 @@ func (k kafkaRepo) ListMessages(ctx context.Context, topicID string, pg messages
 
 	cur, err := k.coll.Aggregate(ctx, bson.A{
 		bson.M{
+			// buckets which have any message between the IDs,
+			// including the buckets of AfterId and BeforeID.
 			"$match": bson.M{
-				"minID":   bson.M{"$gt": p.AfterId, "$lt": p.BeforeID},
+				"maxID":   bson.M{"$gt": p.AfterId},
+				"minID":   bson.M{"$lt": p.BeforeID},
 				"topicID": topicID,
 			},
 		},
@@ -141,6 +144,7 @@ func (k kafkaRepo) ListMessages(ctx context.Context, topicID string, pg messages
 			SentAt:   m.CreatedAt,
 			Text:     m.Text,
 			Version:  m.Version,
+			Seq:      m.Seq,
 		})
 	}
 
@@ -177,26 +181,7 @@ func (k kafkaRepo) SendMsgToTopic(ctx context.Context, sender messages.Sender, t
 		Msg:     mongoMesg,
 	}
 
-	body, err := k.marshalEvent(&event)
-	if err != nil {
-		return messages.Message{}, err
-	}
-
-	kafkaMesg := kafka.Message{
-		Key:   []byte(topicID),
-		Value: body,
-		Headers: []kafka.Header{
-			protocol.Header{
-				Key:   "eventType",
-				Value: []byte(event.EvType),
-			},
-		},
-	}
-
-	err = k.writer.WriteMessage(ctx, kafkaMesg)
-	if err != nil {
-		slog.ErrorContext(ctx, "can not write the message to kafka", "kafkaTopic", k.messagesTopic, "err", err)
-	}
+	err := k.writeEvent(ctx, topicID, &event)
 
 	return *mongoMesg.ToApiMessage(), err
 }
@@ -220,20 +205,112 @@ func (k kafkaRepo) DeleteMessage(ctx context.Context, msg *messages.Message) err
 		DeletedAt:      time.Now(),
 	}
 
-	body, err := k.marshalEvent(&event)
+	return k.writeEvent(ctx, msg.TopicID, &event)
+}
+
+// GetMessage implements messages.Repository.
+func (k kafkaRepo) GetMessage(ctx context.Context, topicID string, messageID string) (messages.Message, error) {
+	id, err := primitive.ObjectIDFromHex(messageID)
 	if err != nil {
-		return err
+		return messages.Message{}, messages.ErrNotFound{Type: "message", ID: messageID}
 	}
 
-	kafkaMesg := kafka.Message{
-		Key:   []byte(msg.TopicID),
-		Value: body,
-		Headers: []kafka.Header{
-			protocol.Header{
-				Key:   "eventType",
-				Value: []byte(event.EvType),
+	cur, err := k.coll.Aggregate(ctx, bson.A{
+		bson.M{
+			"$match": bson.M{
+				"topicID": topicID,
+				"minID":   bson.M{"$lte": id},
+				"maxID":   bson.M{"$gte": id},
 			},
 		},
+		bson.M{
+			"$unwind": "$messages",
+		},
+		bson.M{
+			"$replaceRoot": bson.M{
+				"newRoot": "$messages",
+			},
+		},
+		bson.M{"$match": bson.M{
+			"_id":     id,
+			"deleted": false,
+		}},
+		bson.M{"$limit": 1},
+	})
+	if err != nil {
+		return messages.Message{}, err
+	}
+
+	defer cur.Close(context.Background())
+
+	if !cur.Next(ctx) {
+		if err := cur.Err(); err != nil {
+			return messages.Message{}, err
+		}
+		return messages.Message{}, messages.ErrNotFound{Type: "message", ID: messageID}
+	}
+
+	m := repo.Message{}
+	if err := cur.Decode(&m); err != nil {
+		return messages.Message{}, fmt.Errorf("cant decode cursor's element into Message{}, err:%w", err)
+	}
+
+	return messages.Message{
+		SenderId: m.SenderId,
+		ID:       m.ID.Hex(),
+		TopicID:  m.TopicID,
+		SentAt:   m.CreatedAt,
+		Text:     m.Text,
+		Version:  m.Version,
+		Seq:      m.Seq,
+	}, nil
+}
+
+// EditMessage implements messages.Repository.
+//
+// It sends a [TextEdited] event to Kafka. The edit will be applied
+// by the sink only if the stored version is still msg.Version.
+func (k kafkaRepo) EditMessage(ctx context.Context, msg *messages.Message, text string) (messages.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return messages.Message{}, err
+	}
+
+	if msg == nil {
+		return messages.Message{}, errors.New("message is nil")
+	}
+
+	if msg.ID == "" || msg.TopicID == "" || text == "" {
+		return messages.Message{}, ErrEmptyArgs
+	}
+
+	event := TextEdited{
+		EventId:        NewEventID(),
+		EvType:         EvTypeTextEdited,
+		TopicId:        msg.TopicID,
+		MessageId:      msg.ID,
+		SenderId:       msg.SenderId,
+		MessageVersion: msg.Version,
+		NewText:        text,
+		EditedAt:       time.Now(),
+	}
+
+	err := k.writeEvent(ctx, msg.TopicID, &event)
+	if err != nil {
+		return messages.Message{}, err
+	}
+
+	edited := *msg
+	edited.Text = text
+	edited.Version = msg.Version + 1
+
+	return edited, nil
+}
+
+// writeEvent marshals the event and writes it to Kafka.
+func (k kafkaRepo) writeEvent(ctx context.Context, topicID string, ev Event) error {
+	kafkaMesg, err := newEventMessage(topicID, ev)
+	if err != nil {
+		return err
 	}
 
 	err = k.writer.WriteMessage(ctx, kafkaMesg)
@@ -252,29 +329,74 @@ func (kafkaRepo) marshalEvent(m Event) ([]byte, error) {
 	return bytes, nil
 }
 
+// returns a kafka message of the event with "eventType" header.
+// topicID is used as the key to keep the order of events per topic.
+func newEventMessage(topicID string, ev Event) (kafka.Message, error) {
+	body, err := kafkaRepo{}.marshalEvent(ev)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	return kafka.Message{
+		Key:   []byte(topicID),
+		Value: body,
+		Headers: []kafka.Header{
+			protocol.Header{
+				Key:   "eventType",
+				Value: []byte(ev.EventType()),
+			},
+		},
+	}, nil
+}
+
 type messageChannel struct {
-	kafkaReader *kafka.Reader
+	kafkaReader MessageReader
 	ctx         context.Context
+	watched     func(topicID string) bool // nil means all topics
 }
 
 // read mongo messages from kafka
-func NewMessageWatcher(kafkaReader *kafka.Reader) *messageChannel {
+//
+// kafkaReader must read the applied topic, which the sink publishes stored events to,
+// so watched messages have their seq, and stale edits and deletions are not watched.
+func NewMessageWatcher(kafkaReader MessageReader) *messageChannel {
 	return &messageChannel{kafkaReader: kafkaReader}
 }
 
+// SetTopicFilter skips events of topics which watched returns false for,
+// before unmarshalling them. It must be called before [messageChannel.WatchMessages].
+func (c *messageChannel) SetTopicFilter(watched func(topicID string) bool) {
+	c.watched = watched
+}
+
+// WatchMessages reads events until cancel is called, then closes the kafka reader,
+// so the consumer leaves its group immediately.
 func (c *messageChannel) WatchMessages() (stream <-chan *repo.ChangeStream, cancel func()) {
 	slog.Info("start watching mongodb messages")
 
 	channel := make(chan *repo.ChangeStream)
+	c.ctx, cancel = context.WithCancel(context.Background())
 	go c.watch(channel)
 
-	c.ctx, cancel = context.WithCancel(context.Background())
 	stream = channel
 	return
 }
 
 func (c *messageChannel) watch(channel chan *repo.ChangeStream) {
 	defer close(channel)
+	defer func() {
+		if err := c.kafkaReader.Close(); err != nil {
+			slog.Error("can not close kafka reader", "err", err)
+		}
+	}()
+
+	// sends the change unless watching is canceled.
+	send := func(change *repo.ChangeStream) {
+		select {
+		case channel <- change:
+		case <-c.ctx.Done():
+		}
+	}
 
 	for {
 		// read msg from kafka and commit it
@@ -284,10 +406,18 @@ func (c *messageChannel) watch(channel chan *repo.ChangeStream) {
 				slog.Error("kafka fetch EOF: reader closed")
 				return
 			}
+			if c.ctx.Err() != nil {
+				slog.Info("stop watching mongodb messages")
+				return
+			}
 			slog.Error("get error while reading meassageas", "err", err)
 			break
 		}
 
+		if c.watched != nil && !c.watched(string(kafkaMsg.Key)) { // key is topicID
+			continue
+		}
+
 		eventType, err := getEventType(&kafkaMsg)
 		if err != nil {
 			slog.Error("can not get EventType", "err", err)
@@ -300,16 +430,48 @@ func (c *messageChannel) watch(channel chan *repo.ChangeStream) {
 			continue
 		}
 
-		msg := event.(MessageInserted).Msg
 		carrier := otelkafkakonsumer.NewMessageCarrier(&kafkaMsg)
 
-		channel <- &repo.ChangeStream{
-			DocumentKey:   msg.ID.Hex(),
-			OperationType: "insert",
-			Msg:           msg.ToApiMessage(),
-			Carrier:       carrier,
+		switch ev := event.(type) {
+		case *MessageInserted:
+			send(&repo.ChangeStream{
+				DocumentKey:   ev.Msg.ID.Hex(),
+				OperationType: "insert",
+				Msg:           ev.Msg.ToApiMessage(),
+				Carrier:       carrier,
+			})
+
+		case *TextEdited:
+			send(&repo.ChangeStream{
+				DocumentKey:   ev.MessageId,
+				OperationType: "update",
+				Msg: &messages.Message{
+					SenderId: ev.SenderId,
+					ID:       ev.MessageId,
+					Version:  ev.MessageVersion + 1,
+					TopicID:  ev.TopicId,
+					Text:     ev.NewText,
+				},
+				Carrier: carrier,
+			})
+
+		case *MessageDeleted:
+			send(&repo.ChangeStream{
+				DocumentKey:   ev.MessageId,
+				OperationType: "delete",
+				Msg: &messages.Message{
+					ID:      ev.MessageId,
+					Version: ev.MessageVersion + 1,
+					TopicID: ev.TopicId,
+				},
+				Carrier: carrier,
+			})
+
+		default:
+			slog.Warn("unknown event type", "eventType", eventType)
 		}
 	}
 }
 
 var _ = ws.MessageWatcher(&messageChannel{})
+var _ = ws.TopicFilterSetter(&messageChannel{})
@@ -6,12 +6,14 @@ import (
 	"chat-system/core/repo"
 	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/kamva/mgm/v3"
 	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var _ messages.Repository = kafkaRepo{}
@@ -121,8 +123,20 @@ func Test_kafkaRepo_marshalEvent(t *testing.T) {
 		time.Now(),
 	}
 
+	edited := TextEdited{
+		EventId:        "event-id",
+		EvType:         EvTypeTextEdited,
+		TopicId:        "topic",
+		MessageId:      "message-Id",
+		SenderId:       "sender",
+		MessageVersion: 2,
+		NewText:        "new text",
+		EditedAt:       time.Now().UTC(),
+	}
+
 	insertedBytes, _ := json.Marshal(inserted)
 	deltedBytes, _ := json.Marshal(deleted)
+	editedBytes, _ := json.Marshal(edited)
 
 	tests := []struct {
 		name    string
@@ -132,6 +146,7 @@ func Test_kafkaRepo_marshalEvent(t *testing.T) {
 	}{
 		{"normal-inserted-ev", &inserted, insertedBytes, false},
 		{"normal-deleted-ev", &deleted, deltedBytes, false},
+		{"normal-edited-ev", &edited, editedBytes, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -156,3 +171,124 @@ func Test_kafkaRepo_marshalEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestEditMessage(t *testing.T) {
+	var gotEvent TextEdited
+
+	mockWriter := mockKafkaWriter{func(ctx context.Context, m kafka.Message) error {
+		ev, err := getEventType(&m)
+		if err != nil {
+			t.Errorf("getEventType returns err: %v", err)
+		}
+
+		if ev != EvTypeTextEdited {
+			t.Errorf("EditMessage sent EventType %v, expected %v", ev, EvTypeTextEdited)
+		}
+
+		if !bytes.Equal(m.Key, []byte("topic")) {
+			t.Errorf("EditMessage must set chat's topicID as key, got %s", m.Key)
+		}
+
+		return json.Unmarshal(m.Value, &gotEvent)
+	}}
+
+	repo := kafkaRepo{mockWriter, mgm.Collection{}, "mock-kafka-topic"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		msg     *messages.Message
+		text    string
+		wantErr bool
+	}{
+		{"normal", &messages.Message{ID: "msg", TopicID: "topic", SenderId: "user", Version: 2}, "new text", false},
+		{"nil-message", nil, "new text", true},
+		{"empty-text", &messages.Message{ID: "msg", TopicID: "topic", Version: 2}, "", true},
+		{"empty-topic", &messages.Message{ID: "msg", Version: 2}, "new text", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited, err := repo.EditMessage(ctx, tt.msg, tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("kafkaRepo.EditMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if edited.Version != tt.msg.Version+1 || edited.Text != tt.text {
+				t.Errorf("edited message must have next version and new text, got %+v", edited)
+			}
+
+			if gotEvent.MessageVersion != tt.msg.Version {
+				t.Errorf("event must contain the current version %d, got %d", tt.msg.Version, gotEvent.MessageVersion)
+			}
+		})
+	}
+}
+
+func TestListMessages_afterIdInBucket(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip mongodb test container")
+	}
+
+	ctx := context.Background()
+	mongoCli := StartMongo(t, ctx)
+	db := mongoCli.Database("chatting2")
+	kafkaRepo := NewKafkaRepo(&kafka.Writer{}, db)
+
+	// two buckets of 3 messages
+	msgList := make([]repo.Message, 6)
+	for i := range msgList {
+		msgList[i] = repo.Message{
+			DefaultModel: mgm.DefaultModel{IDField: mgm.IDField{ID: primitive.NewObjectID()}},
+			TopicID:      "topic",
+			Text:         fmt.Sprint(i),
+			Version:      1,
+			Seq:          uint64(i + 1),
+		}
+	}
+	for _, bucket := range [][]repo.Message{msgList[:3], msgList[3:]} {
+		_, err := kafkaRepo.coll.InsertOne(ctx, mongoAggr{
+			Topic:    "topic",
+			MinId:    bucket[0].ID,
+			MaxId:    bucket[len(bucket)-1].ID,
+			MaxSeq:   bucket[len(bucket)-1].Seq,
+			Len:      len(bucket),
+			Messages: bucket,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pg       messages.Pagination
+		wantSeqs []uint64
+	}{
+		{"all", messages.Pagination{Limit: 10}, []uint64{1, 2, 3, 4, 5, 6}},
+		{"after-middle-of-bucket", messages.Pagination{Limit: 10, AfterID: msgList[1].ID.Hex()}, []uint64{3, 4, 5, 6}},
+		{"after-last-of-bucket", messages.Pagination{Limit: 10, AfterID: msgList[2].ID.Hex()}, []uint64{4, 5, 6}},
+		{"after-last", messages.Pagination{Limit: 10, AfterID: msgList[5].ID.Hex()}, []uint64{}},
+		{"before-middle-of-bucket", messages.Pagination{Limit: 10, BeforeID: msgList[4].ID.Hex()}, []uint64{1, 2, 3, 4}},
+		{"between", messages.Pagination{Limit: 10, AfterID: msgList[1].ID.Hex(), BeforeID: msgList[4].ID.Hex()}, []uint64{3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kafkaRepo.ListMessages(ctx, "topic", tt.pg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			seqs := make([]uint64, 0, len(got))
+			for _, m := range got {
+				seqs = append(seqs, m.Seq)
+			}
+			if !cmp.Equal(seqs, tt.wantSeqs) {
+				t.Errorf("got seqs %v, expected %v", seqs, tt.wantSeqs)
+			}
+		})
+	}
+}
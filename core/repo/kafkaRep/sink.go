@@ -55,6 +55,7 @@ type mongoAggr struct {
 	Topic       string             `bson:"topicID"`
 	MinId       primitive.ObjectID `bson:"minID"`
 	MaxId       primitive.ObjectID `bson:"maxID"`
+	MaxSeq      uint64             `bson:"maxSeq"` // seq of the last message of the topic in this bucket
 	Len         int                `bson:"size"`
 	Messages    []repo.Message     `bson:"messages"`
 }
@@ -74,20 +75,38 @@ type KafkaReader interface {
 	Close() error
 }
 
+// interface for [kafka.Writer] which publishes applied events.
+type appliedWriter interface {
+	// see [kafka.Writer.WriteMessages]
+	WriteMessages(context.Context, ...kafka.Message) error
+}
+
 // MongoConnect is responsible for reading messages from Kafka
 // and write messages to MongoDB.
+//
+// Events which are stored are published to the applied topic after each transaction,
+// so watchers push messages with their seq.
 type MongoConnect struct {
 	reader   KafkaReader
+	applied  appliedWriter // nil does not publish applied events
 	mongoCli *mongo.Client
 	coll     mgm.Collection
 	ctx      context.Context
 	cancel   context.CancelFunc
 	msgChan  chan kafka.Message
 	tracer   trace.Tracer
-	handlers map[EventType]mongoMessageHandler
+	runs     []eventRun // of the batch which is being stored
 }
 
-func NewMongoConnect(ctx context.Context, mongoDB *mongo.Database, kafkaReader *kafka.Reader) *MongoConnect {
+// eventRun handles consecutive events of one type of a batch.
+type eventRun struct {
+	eventType EventType
+	handler   mongoMessageHandler
+}
+
+// NewMongoConnect stores events of kafkaReader in mongoDB, and publishes stored events
+// to appliedWriter if it's not nil.
+func NewMongoConnect(ctx context.Context, mongoDB *mongo.Database, kafkaReader *kafka.Reader, appliedWriter *kafka.Writer) *MongoConnect {
 	coll := mgm.NewCollection(mongoDB, mgm.CollName(&mongoAggr{}))
 	ctx, cancel := context.WithCancel(ctx)
 
@@ -112,7 +131,9 @@ func NewMongoConnect(ctx context.Context, mongoDB *mongo.Database, kafkaReader *
 		cancel:   cancel,
 		msgChan:  make(chan kafka.Message),
 		tracer:   otel.Tracer("golang-mongo-connect"),
-		handlers: make(map[EventType]mongoMessageHandler),
+	}
+	if appliedWriter != nil {
+		c.applied = appliedWriter
 	}
 
 	go c.run()
@@ -249,13 +270,15 @@ func (c *MongoConnect) run() {
 	}
 }
 
-// returns a mongodb transaction handler for specific [EventType].
+// returns a mongodb transaction handler for the event. Consecutive events of the same
+// [EventType] are handled together, and events are handled in the order of their offsets,
+// e.g. an edit which is produced between two deletions is applied between them.
 func (c *MongoConnect) getHandler(ev MessageEvent) mongoMessageHandler {
-	handler, ok := c.handlers[ev.EventType()]
-	if ok {
-		return handler
+	if n := len(c.runs); n != 0 && c.runs[n-1].eventType == ev.EventType() {
+		return c.runs[n-1].handler
 	}
 
+	var handler mongoMessageHandler
 	switch ev.EventType() {
 	case EvTypeMessageInserted:
 		handler = &mesgInsertedHandler{coll: c.coll}
@@ -263,24 +286,20 @@ func (c *MongoConnect) getHandler(ev MessageEvent) mongoMessageHandler {
 	case EvTypeMessageDeleted:
 		handler = &mesgDeletedHandler{coll: c.coll}
 
+	case EvTypeTextEdited:
+		handler = &mesgTextEditedHandler{coll: c.coll}
+
 	default:
 		slog.Error("handler not found", "eventType", ev.EventType())
 	}
 
-	c.handlers[ev.EventType()] = handler
+	c.runs = append(c.runs, eventRun{ev.EventType(), handler})
 	return handler
 }
 
 func (c *MongoConnect) handleMongoTransaction(sc mongo.SessionContext) (err error) {
-	order := [...]EventType{EvTypeMessageInserted, EvTypeMessageDeleted}
-
-	for _, eventType := range order {
-		h, ok := c.handlers[eventType]
-		if !ok {
-			continue
-		}
-
-		err = h.Handle(sc)
+	for _, run := range c.runs {
+		err = run.handler.Handle(sc)
 		if err != nil {
 			return err
 		}
@@ -293,6 +312,7 @@ func (c *MongoConnect) prepareAndDoTransaction(ctx context.Context, msgList []ka
 		return nil
 	}
 	propagator := propagation.NewCompositeTextMapPropagator()
+	headers := make(map[EventID][]kafka.Header, len(msgList))
 
 	slog.Info("preparing transaction", "batchLen", len(msgList), "startOffset", msgList[0].Offset)
 
@@ -320,6 +340,7 @@ func (c *MongoConnect) prepareAndDoTransaction(ctx context.Context, msgList []ka
 
 		msgEvent := event.(MessageEvent)
 		c.getHandler(msgEvent).EventRecieved(msgEvent)
+		headers[msgEvent.EventID()] = kafkaMsg.Headers
 
 		// Extract tracing info from message
 		msgCtx := propagator.Extract(context.Background(), otelkafkakonsumer.NewMessageCarrier(&kafkaMsg))
@@ -331,12 +352,13 @@ func (c *MongoConnect) prepareAndDoTransaction(ctx context.Context, msgList []ka
 	if err == nil {
 		span.SetStatus(codes.Ok, "OK")
 		slog.InfoContext(ctx, "transaction finished succesfuly")
+		c.publishApplied(ctx, headers)
 	} else {
 		span.SetStatus(codes.Error, "transaction failed")
 		span.RecordError(err)
 	}
 
-	clear(c.handlers)
+	c.runs = nil
 	return err
 }
 
@@ -372,6 +394,40 @@ func (c *MongoConnect) doTransaction(
 	return err
 }
 
+// publishes events which are stored by the last transaction, with headers of
+// their kafka messages to keep the eventType and tracing. Events of a topic
+// are published in the order of their offsets, like they are stored.
+//
+// Failures are only logged, since the events are stored and clients get them by replaying.
+func (c *MongoConnect) publishApplied(ctx context.Context, headers map[EventID][]kafka.Header) {
+	if c.applied == nil {
+		return
+	}
+
+	var msgList []kafka.Message
+	for _, run := range c.runs {
+		for _, ev := range run.handler.Applied() {
+			kafkaMsg, err := newEventMessage(ev.TopicID(), ev)
+			if err != nil {
+				slog.ErrorContext(ctx, "can not marshal applied event", "eventId", ev.EventID(), "err", err)
+				continue
+			}
+			if evHeaders, ok := headers[ev.EventID()]; ok {
+				kafkaMsg.Headers = evHeaders
+			}
+			msgList = append(msgList, kafkaMsg)
+		}
+	}
+
+	if len(msgList) == 0 {
+		return
+	}
+
+	if err := c.applied.WriteMessages(ctx, msgList...); err != nil {
+		slog.ErrorContext(ctx, "can not publish applied events", "len", len(msgList), "err", err)
+	}
+}
+
 func (c *MongoConnect) Close() {
 	c.cancel()
 }
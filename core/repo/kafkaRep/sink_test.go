@@ -2,10 +2,13 @@ package kafkarep
 
 import (
 	"chat-system/core/messages"
+	"chat-system/core/repo"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"testing"
 	"time"
 
@@ -168,7 +171,7 @@ func Test(t *testing.T) {
 	kafkaReader.ReadLag(ctx)
 
 	db := mongoCli.Database("chatting2")
-	NewMongoConnect(ctx, db, kafkaReader)
+	NewMongoConnect(ctx, db, kafkaReader, nil)
 
 	repo := NewKafkaRepo(kafkaWriter, db)
 
@@ -195,11 +198,72 @@ func Test(t *testing.T) {
 		t.Fatalf("messages' len is %d, expected 1", len(messsages))
 	}
 
+	sentMsg.Seq = 1 // assigned by the sink
 	if !cmp.Equal(messsages[0], sentMsg) {
 		t.Errorf("messages are not equal, %s", cmp.Diff(messsages[0], sentMsg))
 	}
 }
 
+func TestSequenceNumbers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip")
+	}
+
+	ctx := context.Background()
+	kafkaEndpoint := startKakfa(t, ctx)
+	mongoCli := StartMongo(t, ctx)
+
+	writerConf := &WriterConf{KafkaHost: kafkaEndpoint, MsgTopic: "topic", BatchTimeout: 50 * time.Millisecond}
+	readerConf := &ReaderConf{KafkaHost: kafkaEndpoint, Topic: "topic", MaxBytes: 3000, GroupID: "grp", MaxWait: 300 * time.Millisecond}
+
+	db := mongoCli.Database("chatting2")
+	NewMongoConnect(ctx, db, NewInsecureReader(readerConf), nil)
+	repo := NewKafkaRepo(NewInsecureWriter(writerConf), db)
+
+	// more than a bucket, sent in two batches
+	for batch := range 2 {
+		for range 15 {
+			if _, err := repo.SendMsgToTopic(ctx, messages.Sender{ID: "sender-id"}, "test-topic", "text"); err != nil {
+				t.Fatalf("can not send mesg: %v", err)
+			}
+		}
+
+		if _, err := repo.SendMsgToTopic(ctx, messages.Sender{ID: "sender-id"}, "other-topic", "text"); err != nil {
+			t.Fatalf("can not send mesg to other topic: %v", err)
+		}
+
+		if batch == 0 {
+			time.Sleep(5 * time.Second)
+		}
+	}
+
+	time.Sleep(9 * time.Second)
+
+	msgs, err := repo.ListMessages(ctx, "test-topic", messages.Pagination{Limit: 50})
+	if err != nil {
+		t.Fatalf("can not list messages: %v", err)
+	}
+
+	if len(msgs) != 30 {
+		t.Fatalf("messages' len is %d, expected 30", len(msgs))
+	}
+
+	for i, m := range msgs {
+		if m.Seq != uint64(i+1) {
+			t.Errorf("message %d has seq %d, expected %d", i, m.Seq, i+1)
+		}
+	}
+
+	other, err := repo.ListMessages(ctx, "other-topic", messages.Pagination{Limit: 50})
+	if err != nil {
+		t.Fatalf("can not list messages of other topic: %v", err)
+	}
+
+	if len(other) != 2 || other[0].Seq != 1 || other[1].Seq != 2 {
+		t.Errorf("other topic should have its own sequence, got %+v", other)
+	}
+}
+
 func TestDeletedMessageEvent(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skip mongodb test container")
@@ -209,7 +273,7 @@ func TestDeletedMessageEvent(t *testing.T) {
 	mongoCli := StartMongo(t, ctx)
 
 	db := mongoCli.Database("chatting2")
-	mConnect := NewMongoConnect(ctx, db, &kafka.Reader{})
+	mConnect := NewMongoConnect(ctx, db, &kafka.Reader{}, nil)
 	// pause kafka reader goroutin
 	mConnect.reader = mockKafkaFetch{func(ctx context.Context, m *kafka.Message) error {
 		time.Sleep(time.Hour)
@@ -270,6 +334,71 @@ func TestDeletedMessageEvent(t *testing.T) {
 	}
 }
 
+func TestTextEditedEvent(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip mongodb test container")
+	}
+
+	ctx := context.Background()
+	mongoCli := StartMongo(t, ctx)
+
+	db := mongoCli.Database("chatting2")
+	mConnect := NewMongoConnect(ctx, db, &kafka.Reader{}, nil)
+	// pause kafka reader goroutin
+	mConnect.reader = mockKafkaFetch{func(ctx context.Context, m *kafka.Message) error {
+		time.Sleep(time.Hour)
+		return nil
+	}}
+
+	applied := &recordingAppliedWriter{}
+	mConnect.applied = applied
+
+	kafkaRepo := NewKafkaRepo(&kafka.Writer{}, db)
+	// send kafkaRepo kafka.Message to MongoConnect
+	kafkaRepo.writer = mockKafkaWriter{func(_ context.Context, m kafka.Message) error {
+		mConnect.msgChan <- m
+		return nil
+	}}
+
+	sentMsg, err := kafkaRepo.SendMsgToTopic(ctx, messages.Sender{ID: "sender-id"}, "test-topic", "text")
+	if err != nil {
+		t.Fatalf("can not send mesg: %v", err)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+
+	edited, err := kafkaRepo.EditMessage(ctx, &sentMsg, "edited text")
+	if err != nil {
+		t.Fatalf("can not edit message: %v", err)
+	}
+
+	// stale version must be skipped
+	_, err = kafkaRepo.EditMessage(ctx, &sentMsg, "stale text")
+	if err != nil {
+		t.Fatalf("can not edit message: %v", err)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+
+	got, err := kafkaRepo.GetMessage(ctx, "test-topic", sentMsg.ID)
+	if err != nil {
+		t.Fatalf("can not get message: %v", err)
+	}
+
+	if got.Text != edited.Text || got.Version != edited.Version {
+		t.Errorf("message should be edited, got=%+v, expected=%+v", got, edited)
+	}
+
+	_, err = kafkaRepo.GetMessage(ctx, "other-topic", sentMsg.ID)
+	if !errors.As(err, &messages.ErrNotFound{}) {
+		t.Errorf("GetMessage should return ErrNotFound for other topics, err=%v", err)
+	}
+
+	if types := applied.eventTypes(t); !cmp.Equal(types, []EventType{EvTypeMessageInserted, EvTypeTextEdited}) {
+		t.Errorf("stale edits should not be published, got %v", types)
+	}
+}
+
 func Test_getEventType(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -315,3 +444,125 @@ func newDummyKafkaMessage(event MessageEvent) kafka.Message {
 		Value:   data,
 	}
 }
+
+type recordingAppliedWriter struct {
+	mu      sync.Mutex
+	msgList []kafka.Message
+}
+
+func (w *recordingAppliedWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.msgList = append(w.msgList, msgs...)
+	return nil
+}
+
+// returns event types of published messages.
+func (w *recordingAppliedWriter) eventTypes(t *testing.T) []EventType {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res := make([]EventType, 0, len(w.msgList))
+	for i := range w.msgList {
+		evType, err := getEventType(&w.msgList[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, evType)
+	}
+	return res
+}
+
+func TestMongoConnect_publishApplied(t *testing.T) {
+	inserted := &MessageInserted{"event-1", EvTypeMessageInserted, repo.Message{TopicID: "topic", Text: "hi", Seq: 7}}
+	traceHeader := kafka.Header{Key: "traceparent", Value: []byte("trace")}
+
+	w := &recordingAppliedWriter{}
+	c := MongoConnect{
+		applied: w,
+		runs: []eventRun{
+			{EvTypeMessageInserted, &mesgInsertedHandler{applied: []MessageEvent{inserted}}},
+		},
+	}
+
+	c.publishApplied(context.Background(), map[EventID][]kafka.Header{
+		"event-1": {{Key: "eventType", Value: []byte(EvTypeMessageInserted)}, traceHeader},
+	})
+
+	if len(w.msgList) != 1 {
+		t.Fatalf("applied event should be published, got %d messages", len(w.msgList))
+	}
+
+	m := w.msgList[0]
+	if string(m.Key) != "topic" {
+		t.Errorf("key should be the topic, got %s", m.Key)
+	}
+	if len(m.Headers) != 2 || m.Headers[1].Key != traceHeader.Key {
+		t.Errorf("headers of the event should be kept, got %v", m.Headers)
+	}
+
+	got := MessageInserted{}
+	if err := json.Unmarshal(m.Value, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Msg.Seq != 7 {
+		t.Errorf("published message should have its seq, got %d", got.Msg.Seq)
+	}
+}
+
+func TestMongoConnect_getHandler_offsetOrder(t *testing.T) {
+	events := []MessageEvent{
+		&MessageInserted{EventId: "1", EvType: EvTypeMessageInserted, Msg: repo.Message{TopicID: "topic"}},
+		&TextEdited{EventId: "2", EvType: EvTypeTextEdited, TopicId: "topic"},
+		&TextEdited{EventId: "3", EvType: EvTypeTextEdited, TopicId: "topic"},
+		&MessageDeleted{EventId: "4", EvType: EvTypeMessageDeleted, TopicId: "topic"},
+		&TextEdited{EventId: "5", EvType: EvTypeTextEdited, TopicId: "topic"},
+		&MessageInserted{EventId: "6", EvType: EvTypeMessageInserted, Msg: repo.Message{TopicID: "topic"}},
+	}
+
+	w := &recordingAppliedWriter{}
+	c := MongoConnect{applied: w}
+	for _, ev := range events {
+		c.getHandler(ev).EventRecieved(ev)
+	}
+
+	runTypes := []EventType{}
+	for _, run := range c.runs {
+		runTypes = append(runTypes, run.eventType)
+	}
+	wantRuns := []EventType{EvTypeMessageInserted, EvTypeTextEdited, EvTypeMessageDeleted, EvTypeTextEdited, EvTypeMessageInserted}
+	if !cmp.Equal(runTypes, wantRuns) {
+		t.Fatalf("consecutive events of a type should be handled together in offset order, got runs %v", runTypes)
+	}
+
+	// every event is applied
+	for _, run := range c.runs {
+		switch h := run.handler.(type) {
+		case *mesgInsertedHandler:
+			for i := range h.events {
+				h.applied = append(h.applied, &h.events[i])
+			}
+		case *mesgTextEditedHandler:
+			for i := range h.events {
+				h.applied = append(h.applied, &h.events[i])
+			}
+		case *mesgDeletedHandler:
+			for i := range h.events {
+				h.applied = append(h.applied, &h.events[i])
+			}
+		}
+	}
+	c.publishApplied(context.Background(), nil)
+
+	ids := []EventID{}
+	for i := range w.msgList {
+		got := TextEdited{} // all events have event_id
+		if err := json.Unmarshal(w.msgList[i].Value, &got); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, got.EventId)
+	}
+	if want := []EventID{"1", "2", "3", "4", "5", "6"}; !cmp.Equal(ids, want) {
+		t.Errorf("applied events should be published in offset order, got %v", ids)
+	}
+}
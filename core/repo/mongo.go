@@ -45,6 +45,7 @@ type Message struct {
 	mgm.DefaultModel `bson:",inline"`
 	TopicID          string              `bson:"topicID" json:"topicId"`
 	Version          uint                `bson:"v" json:"v"`
+	Seq              uint64              `bson:"seq" json:"seq"`
 	SenderId         string              `bson:"senderID" json:"senderId"`
 	Timestamp        primitive.Timestamp `bson:"ts" json:"-"`
 	Text             string              `bson:"text" json:"text"`
@@ -56,6 +57,7 @@ func (m *Message) ToApiMessage() *messages.Message {
 		SenderId: m.SenderId,
 		ID:       m.ID.Hex(),
 		Version:  m.Version,
+		Seq:      m.Seq,
 		TopicID:  m.TopicID,
 		SentAt:   m.CreatedAt.Truncate(time.Millisecond),
 		Text:     m.Text,
@@ -133,14 +135,20 @@ func (r Repo) ListMessages(ctx context.Context, topicID string, pg messages.Pagi
 }
 
 func (r Repo) SendMsgToTopic(ctx context.Context, sender messages.Sender, topicID string, message string) (messages.Message, error) {
+	seq, err := r.nextSeq(ctx, topicID)
+	if err != nil {
+		return messages.Message{}, fmt.Errorf("can't get next seq of topic: %w", err)
+	}
+
 	msg := &Message{
 		SenderId: sender.ID,
 		Text:     message,
 		TopicID:  topicID,
 		Version:  1,
+		Seq:      seq,
 	}
 
-	err := r.msgColl.CreateWithCtx(ctx, msg)
+	err = r.msgColl.CreateWithCtx(ctx, msg)
 	if err != nil {
 		return messages.Message{}, err
 	}
@@ -152,16 +160,18 @@ func (r Repo) SendMsgToTopic(ctx context.Context, sender messages.Sender, topicI
 		SentAt:   msg.CreatedAt.Truncate(time.Millisecond),
 		Text:     msg.Text,
 		Version:  1,
+		Seq:      seq,
 	}, err
 }
 
+// DeleteMessage soft deletes msg if its stored version is still msg.Version.
 func (r Repo) DeleteMessage(ctx context.Context, msg *messages.Message) error {
 	id, err := primitive.ObjectIDFromHex(msg.ID)
 	if err != nil {
 		return err
 	}
 
-	res, err := r.msgColl.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+	res, err := r.msgColl.UpdateOne(ctx, bson.M{"_id": id, "v": msg.Version}, bson.M{
 		"$set": bson.M{"deleted": true, "updated_at": time.Now()},
 		"$inc": bson.M{"v": 1},
 	})
@@ -177,7 +187,7 @@ func (r Repo) DeleteMessage(ctx context.Context, msg *messages.Message) error {
 		"topicID": msg.TopicID,
 		"min":     bson.M{"$lte": id},
 		"max":     bson.M{"$gte": id},
-		"msg._id": id,
+		"msg":     bson.M{"$elemMatch": bson.M{"_id": id, "v": msg.Version}},
 	}, bson.M{
 		"$set": bson.M{
 			"msg.$.deleted":    true,
@@ -196,6 +206,97 @@ func (r Repo) DeleteMessage(ctx context.Context, msg *messages.Message) error {
 	return nil
 }
 
+func (r Repo) GetMessage(ctx context.Context, topicID string, messageID string) (messages.Message, error) {
+	id, err := primitive.ObjectIDFromHex(messageID)
+	if err != nil {
+		return messages.Message{}, messages.ErrNotFound{Type: "message", ID: messageID}
+	}
+
+	m := Message{}
+	err = r.msgColl.FirstWithCtx(ctx, bson.M{"_id": id, "topicID": topicID, "deleted": false}, &m)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return messages.Message{}, err
+	}
+
+	if err == mongo.ErrNoDocuments {
+		// not found, retry on hist collection
+		cur, err := r.db.Collection("hist").Aggregate(ctx, bson.A{
+			bson.M{"$match": bson.M{
+				"topicID": topicID,
+				"min":     bson.M{"$lte": id},
+				"max":     bson.M{"$gte": id},
+			}},
+			bson.M{"$unwind": "$msg"},
+			bson.M{"$replaceRoot": bson.M{"newRoot": "$msg"}},
+			bson.M{"$match": bson.M{"_id": id, "deleted": false}},
+			bson.M{"$limit": 1},
+		})
+		if err != nil {
+			return messages.Message{}, err
+		}
+		defer cur.Close(context.Background())
+
+		if !cur.Next(ctx) {
+			return messages.Message{}, messages.ErrNotFound{Type: "message", ID: messageID}
+		}
+		if err := cur.Decode(&m); err != nil {
+			return messages.Message{}, fmt.Errorf("cant decode cursor's element into Message{}, err:%w", err)
+		}
+	}
+
+	return *m.ToApiMessage(), nil
+}
+
+// EditMessage replaces the text of msg if its stored version is still msg.Version.
+// Returns [messages.ErrVersionConflict] otherwise.
+func (r Repo) EditMessage(ctx context.Context, msg *messages.Message, text string) (messages.Message, error) {
+	id, err := primitive.ObjectIDFromHex(msg.ID)
+	if err != nil {
+		return messages.Message{}, err
+	}
+
+	now := time.Now()
+	res, err := r.msgColl.UpdateOne(ctx, bson.M{"_id": id, "v": msg.Version, "deleted": false}, bson.M{
+		"$set": bson.M{"text": text, "updated_at": now},
+		"$inc": bson.M{"v": 1},
+	})
+	if err != nil {
+		return messages.Message{}, err
+	}
+
+	if res.ModifiedCount != 1 {
+		// not found, retry on hist collection
+		res, err = r.db.Collection("hist").UpdateOne(ctx, bson.M{
+			"topicID": msg.TopicID,
+			"min":     bson.M{"$lte": id},
+			"max":     bson.M{"$gte": id},
+			"msg": bson.M{"$elemMatch": bson.M{
+				"_id":     id,
+				"v":       msg.Version,
+				"deleted": false,
+			}},
+		}, bson.M{
+			"$set": bson.M{
+				"msg.$.text":       text,
+				"msg.$.updated_at": now,
+			},
+			"$inc": bson.M{"msg.$.v": 1},
+		})
+		if err != nil {
+			return messages.Message{}, err
+		}
+	}
+
+	if res.ModifiedCount != 1 {
+		return messages.Message{}, messages.ErrVersionConflict{ID: msg.ID, Version: msg.Version}
+	}
+
+	edited := *msg
+	edited.Text = text
+	edited.Version = msg.Version + 1
+	return edited, nil
+}
+
 func (r Repo) createTopic(ctx context.Context, topicID string) error {
 	topic := bson.M{"_id": topicID}
 
@@ -208,6 +309,24 @@ func (r Repo) createTopic(ctx context.Context, topicID string) error {
 	return nil
 }
 
+// nextSeq increments and returns the message counter of the topic.
+func (r Repo) nextSeq(ctx context.Context, topicID string) (uint64, error) {
+	upsert := true
+	after := options.After
+
+	topic := struct {
+		Seq uint64 `bson:"seq"`
+	}{}
+
+	err := r.db.Collection("topicIds").FindOneAndUpdate(ctx,
+		bson.M{"_id": topicID},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		&options.FindOneAndUpdateOptions{Upsert: &upsert, ReturnDocument: &after},
+	).Decode(&topic)
+
+	return topic.Seq, err
+}
+
 func (r Repo) topicExist(ctx context.Context, topicID string) (bool, error) {
 	returnKey := true
 	err := r.db.Collection("topicIds").FindOne(ctx, bson.M{"_id": topicID},
@@ -390,6 +509,8 @@ func (r Repo) readFromBucket(ctx context.Context, topicID string, pg messages.Pa
 			TopicID:  m.TopicID,
 			SentAt:   m.CreatedAt,
 			Text:     m.Text,
+			Version:  m.Version,
+			Seq:      m.Seq,
 		})
 	}
 
@@ -398,13 +519,14 @@ func (r Repo) readFromBucket(ctx context.Context, topicID string, pg messages.Pa
 
 type ChangeStream struct {
 	DocumentKey   string
-	OperationType string                     // insert or delete
-	Msg           *messages.Message          // will be nil for "delete" operation type
+	OperationType string                     // insert, update or delete
+	Msg           *messages.Message          // will be nil if the document is removed. for deleted messages only ID, TopicID and Version are set
 	Carrier       propagation.TextMapCarrier // can be used for tracing
 }
 
 func (r Repo) watchMessagesChangeStream(ctx context.Context, msgChan chan<- *ChangeStream) {
-	stream, err := r.msgColl.Watch(context.TODO(), mongo.Pipeline{})
+	stream, err := r.msgColl.Watch(context.TODO(), mongo.Pipeline{},
+		options.ChangeStream().SetFullDocument(options.UpdateLookup))
 	if err != nil {
 		slog.Error("can't watch messages collection", "err", err)
 		return
@@ -428,7 +550,7 @@ func (r Repo) watchMessagesChangeStream(ctx context.Context, msgChan chan<- *Cha
 			OperationType: opType,
 		}
 
-		if opType == "insert" {
+		if opType == "insert" || opType == "update" {
 			doc, err := stream.Current.LookupErr("fullDocument")
 			if err != nil {
 				slog.Error("can't lookup 'fullDocument' from changeStream's document", "err", err)
@@ -442,6 +564,9 @@ func (r Repo) watchMessagesChangeStream(ctx context.Context, msgChan chan<- *Cha
 				continue
 			}
 
+			if msg.Deleted { // soft deletes are updates
+				changeStream.OperationType = "delete"
+			}
 			changeStream.Msg = msg.ToApiMessage()
 		}
 
@@ -7,16 +7,16 @@ const API_HOST = requireEnv('API_HOST')
  * 
  * @param {string} topic 
  * @param {string} message 
- * @param {string} cookies 
+ * @param {string} token 
  * @returns
  */
-export function createNewMessage(topic, message, cookies){
+export function createNewMessage(topic, message, token){
     const httpUrl = `http://${API_HOST}/topics/${topic}/messages`
 
     const hResp = http.post(httpUrl, `{"message":"${message}"}`, {
         headers: { 
             'Content-Type': 'application/json',
-            'Cookie': cookies
+            'Authorization': `Bearer ${token}`
         }
     })
 
@@ -26,10 +26,10 @@ export function createNewMessage(topic, message, cookies){
 /**
  * 
  * @param {string} topic 
- * @param {string} cookies 
+ * @param {string} token 
  * @returns 
  */
-export function getMessages(topic, cookies, {limit}={limit: 40}){
+export function getMessages(topic, token, {limit}={limit: 40}){
     const url = `http://${API_HOST}:8888/topics/${topic}/messages?limit=${limit}`
-    return http.get(url, {headers: {cookie: cookies}});
+    return http.get(url, {headers: {Authorization: `Bearer ${token}`}});
 }
\ No newline at end of file
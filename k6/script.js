@@ -20,7 +20,7 @@ export const options = {
 };
 
 
-const { userId, cookies } = randomUser()
+const { userId, token } = randomUser()
 const authorizedTopics = getUserTopics(userId)
 
 export function setup(){
@@ -31,7 +31,7 @@ export function setup(){
 export default function() {
   const topic = randomArrayItem(authorizedTopics)
 
-  const res = getMessages(topic, cookies.toString())
+  const res = getMessages(topic, token)
   check(res, { 'Get Messages': (r) => r && r.status === 200 });
   if (res.status !== 200){
     console.error('can not list messages', {body: res.body, status:res.status, url: res.request.url})
@@ -1,4 +1,6 @@
 import { SharedArray } from 'k6/data';
+import crypto from 'k6/crypto';
+import encoding from 'k6/encoding';
 
 export function requireEnv(name){
     const env = __ENV[name]
@@ -19,6 +21,22 @@ export function randomArrayItem(arr) {
 
 const usedIds = new Map()
 
+// signs a HS256 token of the user. JWT_SECRET is the "k" of the key in the servers' JWKS file.
+function userToken(userId){
+    const header = encoding.b64encode(JSON.stringify({alg: 'HS256', typ: 'JWT'}), 'rawurl')
+    const claims = encoding.b64encode(JSON.stringify({
+        sub: userId,
+        iss: requireEnv('JWT_ISSUER'),
+        aud: requireEnv('JWT_AUDIENCE'),
+        exp: Math.floor(Date.now() / 1000) + 3600,
+    }), 'rawurl')
+
+    const secret = encoding.b64decode(requireEnv('JWT_SECRET'), 'rawurl')
+    const sig = crypto.hmac('sha256', secret, `${header}.${claims}`, 'binary')
+
+    return `${header}.${claims}.${encoding.b64encode(sig, 'rawurl')}`
+}
+
 export function randomUser() {
     const id = randomIntBetween(1, 2000)
     let clientId = 1
@@ -28,14 +46,15 @@ export function randomUser() {
     
     usedIds.set(id, clientId)
 
+    const token = userToken(id+'')
     const cookies = {
-        userId: id+'',
+        access_token: token,
         toString: function(){
-            return `userId=${id}`
+            return `access_token=${token}`
         }
     }
     
-    return {userId: '' + id, clientId: ''+ clientId, cookies}    
+    return {userId: '' + id, clientId: ''+ clientId, token, cookies}    
 }
 
 const data = new SharedArray('authrizedTopics', function () {
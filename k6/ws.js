@@ -8,7 +8,7 @@ import { textSummary } from 'https://jslib.k6.io/k6-summary/0.0.4/index.js';
 const sessionDuration = randomIntBetween(20000, 20001); // user session between 50s and 55s
 
 const wsUrl = `ws://${requireEnv('WS_HOST')}/ws`;
-const { userId, clientId, cookies } = randomUser()
+const { userId, clientId, token, cookies } = randomUser()
 const authorizedTopics = getUserTopics(userId)
 
 export const options = {
@@ -33,7 +33,7 @@ export default function () {
 
           const topic = randomArrayItem(authorizedTopics)
           const newMsg = compair.start() // creates new unique str
-          const hResp = createNewMessage(topic, newMsg, cookies.toString())
+          const hResp = createNewMessage(topic, newMsg, token)
           
           if (hResp.status === 201) {
             compair.sent(newMsg)
@@ -56,7 +56,11 @@ export default function () {
 
     socket.on("message", function (e) {
       const obj = JSON.parse(e)
-      compair.end(obj.text)
+      if (obj.type === "message.created") {
+        compair.end(obj.payload.text)
+      } else if (obj.type === "heartbeat") {
+        socket.send(JSON.stringify({type: "pong"}))
+      }
     })
 
     socket.on('close', function (code) {
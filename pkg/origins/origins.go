@@ -0,0 +1,176 @@
+// Package origins matches Origin headers of browsers against allowed patterns,
+// so the websocket and REST servers share one origin configuration.
+//
+// A pattern is "[scheme://]host[:port]":
+//   - without scheme, any scheme is allowed,
+//   - a host like "*.example.com" allows any subdomain of example.com, but not example.com itself,
+//   - without port, only the default port of the scheme is allowed; port "*" allows any port,
+//   - "*" allows all origins.
+package origins
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Policy reports whether origins are allowed.
+type Policy struct {
+	patterns []pattern
+	any      bool // "*" is allowed
+}
+
+type pattern struct {
+	scheme string // empty matches any scheme
+	host   string // without "*." of subdomain wildcards
+	port   string // empty matches the default port, "*" matches any port
+
+	subdomains bool
+}
+
+// Parse returns a [Policy] which allows origins matching any of the patterns.
+// Spaces around patterns and empty patterns are ignored,
+// so a comma-separated list can be split and parsed.
+func Parse(patterns ...string) (*Policy, error) {
+	p := &Policy{}
+
+	for _, s := range patterns {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if s == "*" {
+			p.any = true
+			continue
+		}
+
+		pat, err := parsePattern(s)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, pat)
+	}
+
+	return p, nil
+}
+
+// MustParse is like [Parse] but panics if a pattern is invalid.
+func MustParse(patterns ...string) *Policy {
+	p, err := Parse(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func parsePattern(s string) (pattern, error) {
+	pat := pattern{}
+
+	rest := s
+	if scheme, after, found := strings.Cut(s, "://"); found {
+		if scheme == "" || strings.Contains(scheme, "*") {
+			return pat, fmt.Errorf("origins: invalid scheme in pattern %q", s)
+		}
+		pat.scheme, rest = scheme, after
+	}
+
+	host, port := splitHostPort(rest)
+	if port != "" && port != "*" && !isDigits(port) {
+		return pat, fmt.Errorf("origins: invalid port in pattern %q", s)
+	}
+	pat.port = port
+
+	if after, found := strings.CutPrefix(host, "*."); found {
+		pat.subdomains, host = true, after
+	}
+	if host == "" || strings.ContainsAny(host, "*/") {
+		return pat, fmt.Errorf("origins: invalid host in pattern %q", s)
+	}
+	pat.host = host
+
+	return pat, nil
+}
+
+// Empty reports whether the policy has no patterns.
+func (p *Policy) Empty() bool {
+	return !p.any && len(p.patterns) == 0
+}
+
+// AllowsAny reports whether the policy has the "*" pattern.
+func (p *Policy) AllowsAny() bool {
+	return p.any
+}
+
+// Allowed reports whether the origin matches a pattern of the policy.
+// The origin "null" of sandboxed pages is only allowed by "*" or "null" patterns.
+func (p *Policy) Allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	scheme, rest, found := strings.Cut(origin, "://")
+	if !found {
+		scheme, rest = "", origin
+	}
+	host, port := splitHostPort(rest)
+	if port == defaultPort(scheme) {
+		port = ""
+	}
+
+	for _, pat := range p.patterns {
+		if pat.matches(scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pat pattern) matches(scheme, host, port string) bool {
+	if pat.scheme != "" && pat.scheme != scheme {
+		return false
+	}
+
+	if pat.port != "*" && pat.port != port && pat.port != portOrDefault(port, scheme) {
+		return false
+	}
+
+	if pat.subdomains {
+		return strings.HasSuffix(host, "."+pat.host)
+	}
+	return host == pat.host
+}
+
+// splits "host:port" or "[ipv6]:port". Port is empty if s has no port.
+func splitHostPort(s string) (host, port string) {
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		return h, p
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"), ""
+}
+
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+func portOrDefault(port, scheme string) string {
+	if port == "" {
+		return defaultPort(scheme)
+	}
+	return port
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
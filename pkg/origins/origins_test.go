@@ -0,0 +1,67 @@
+package origins_test
+
+import (
+	"chat-system/pkg/origins"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Allowed(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		allowed bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "https://example.com", true},
+		{"example.com", "sub.example.com", false},
+		{"example.com", "https://example.com:8443", false},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://EXAMPLE.com:443", true},
+		{"https://example.com:443", "https://example.com", true},
+		{"https://*.example.com", "https://pr-12.preview.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://attacker-example.com", false},
+		{"https://*.example.com", "https://example.com.attacker.com", false},
+		{"http://localhost:*", "http://localhost:5173", true},
+		{"http://localhost:*", "http://localhost", true},
+		{"http://localhost:3000", "http://localhost:5173", false},
+		{"http://[::1]:3000", "http://[::1]:3000", true},
+		{"*", "https://attacker.com", true},
+		{"https://example.com", "null", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.origin, func(t *testing.T) {
+			p, err := origins.Parse(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := p.Allowed(tt.origin); got != tt.allowed {
+				t.Errorf("Allowed(%q) = %v, expected %v", tt.origin, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	p, err := origins.Parse(strings.Split(" https://example.com ,https://*.preview.example.com,", ",")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Allowed("https://example.com") || !p.Allowed("https://pr-1.preview.example.com") {
+		t.Error("origins of the list should be allowed")
+	}
+
+	if empty, _ := origins.Parse(""); !empty.Empty() {
+		t.Error("empty patterns should be ignored")
+	}
+
+	for _, invalid := range []string{"https://exa*mple.com", "*://example.com", "https://example.com:http", "https://"} {
+		if _, err := origins.Parse(invalid); err == nil {
+			t.Errorf("pattern %q should be invalid", invalid)
+		}
+	}
+}
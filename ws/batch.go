@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clients which connect with "batch=1" query parameter (like /ws?batch=1)
+// may receive message envelopes of busy rooms in a json array frame:
+//
+//	[{"type":"message.created","payload":{...}},{"type":"message.created","payload":{...}}]
+const batchQueryParam = "batch"
+
+const (
+	defaultBatchWindow  = 50 * time.Millisecond
+	defaultBatchMinRate = 20
+)
+
+// batchConf configures batching messages of rooms. Zero window disables it.
+type batchConf struct {
+	window  time.Duration // messages arriving within window are sent in one frame
+	minRate int           // messages per second which turns on batching of a room
+}
+
+// roomBatch gathers encoded message envelopes of a room while
+// the room receives at least minRate messages per second.
+type roomBatch struct {
+	conf  batchConf
+	flush func([]*encodedFrame) // called by the timer with gathered envelopes
+
+	mu        sync.Mutex
+	rateStart time.Time
+	rateCount int // messages since rateStart
+	prevCount int // messages in the second before rateStart
+	pending   []*encodedFrame
+	timer     *time.Timer
+}
+
+func newRoomBatch(conf batchConf, flush func([]*encodedFrame)) *roomBatch {
+	return &roomBatch{conf: conf, flush: flush, rateStart: time.Now()}
+}
+
+// counts the message and reports whether the room is busy. mu must be held.
+func (b *roomBatch) busy(now time.Time) bool {
+	if elapsed := now.Sub(b.rateStart); elapsed >= time.Second {
+		if elapsed >= 2*time.Second {
+			b.prevCount = 0
+		} else {
+			b.prevCount = b.rateCount
+		}
+		b.rateStart, b.rateCount = now, 0
+	}
+
+	b.rateCount++
+	return b.rateCount >= b.conf.minRate || b.prevCount >= b.conf.minRate
+}
+
+// add gathers the frame if the room is busy, or if a batch is already pending
+// so envelopes are not reordered. It reports whether the frame is gathered.
+func (b *roomBatch) add(f *encodedFrame) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.busy(time.Now()) && len(b.pending) == 0 {
+		return false
+	}
+
+	b.pending = append(b.pending, f)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.conf.window, b.flushPending)
+	}
+	return true
+}
+
+// flushPending sends gathered envelopes now.
+func (b *roomBatch) flushPending() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	if len(b.pending) == 0 {
+		return
+	}
+
+	// flushing under the lock, so the next batch can not overtake this one.
+	b.flush(b.pending)
+	b.pending = nil
+}
+
+// encodes envelopes as an array. Its msgpack value is the array of
+// envelopes' values, unless an envelope is only known as json.
+func encodeBatch(envelopes []*encodedFrame) *encodedFrame {
+	var buf bytes.Buffer
+	values := make([]any, 0, len(envelopes))
+
+	buf.WriteByte('[')
+	for i, e := range envelopes {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(e.json)
+		values = append(values, e.value)
+	}
+	buf.WriteByte(']')
+
+	if slices.Contains(values, nil) {
+		return &encodedFrame{json: buf.Bytes()}
+	}
+	return &encodedFrame{json: buf.Bytes(), value: values}
+}
+
+// enables batching of the room.
+func (r *room) enableBatching(conf batchConf) {
+	r.batch = newRoomBatch(conf, func(envelopes []*encodedFrame) {
+		clients, _ := r.onlinePersons.GetOnlineClients(context.Background())
+		var batchClients iter.Seq[Client] = func(yield func(Client) bool) {
+			for c := range clients {
+				if frameOptionsOf(c).batches && !yield(c) {
+					return
+				}
+			}
+		}
+
+		r.clientsFanOut(context.Background(), "", encodeBatch(envelopes), batchClients)
+	})
+}
+
+// gathers the message envelope for clients which accept batches, if the room is busy.
+// It returns clients which should receive the envelope now.
+func (r *room) gatherForBatching(clients iter.Seq[Client], e *Envelope, frame *encodedFrame) iter.Seq[Client] {
+	if e.msgId == "" || !r.batch.add(frame) {
+		return clients
+	}
+
+	return func(yield func(Client) bool) {
+		for c := range clients {
+			if !frameOptionsOf(c).batches && !yield(c) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// batchingConn is a recordingConn of a client which negotiated batches.
+type batchingConn struct {
+	recordingConn
+}
+
+func (*batchingConn) frameOptions() frameOptions {
+	return frameOptions{batches: true}
+}
+
+func TestRoom_batching(t *testing.T) {
+	batchConn, plainConn := &batchingConn{}, &recordingConn{}
+	batchCli := Client{"batch-client", "user1", batchConn}
+	plainCli := Client{"plain-client", "user2", plainConn}
+
+	room := newRoom("topic", []Client{batchCli, plainCli})
+	room.enableBatching(batchConf{window: 30 * time.Millisecond, minRate: 2})
+
+	for _, id := range []string{"1", "2", "3"} {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: id, TopicID: "topic"})
+		room.SendMessage(context.Background(), e)
+	}
+
+	presence := &Envelope{Type: PresenceOnline, Payload: json.RawMessage(`{}`)}
+	room.SendMessage(context.Background(), presence) // not batched
+
+	if got := plainConn.received(); len(got) != 4 {
+		t.Errorf("clients without batches should receive every envelope, got %v", got)
+	}
+
+	frames := waitForFrames(t, &batchConn.recordingConn, 3)
+
+	batch := []Envelope{}
+	if err := json.Unmarshal([]byte(frames[2]), &batch); err != nil {
+		t.Fatalf("the last frame should be a batch, got %s: %v", frames[2], err)
+	}
+	if len(batch) != 2 {
+		t.Errorf("messages of the busy room should be batched, got %s", frames[2])
+	}
+
+	first := Envelope{}
+	json.Unmarshal([]byte(frames[0]), &first)
+	if first.Type != MessageCreated {
+		t.Errorf("the first message should be sent before the room is busy, got %s", frames[0])
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	msg := &messages.Message{ID: "msg", TopicID: "topic", SentAt: time.Unix(1_700_000_000, 0).UTC()}
+	e, _ := newMessageEnvelope(MessageCreated, msg)
+	data, _ := json.Marshal(e)
+	frame := &encodedFrame{json: data, value: e.msgpackValue()}
+
+	batch := encodeBatch([]*encodedFrame{frame, frame})
+	if want := "[" + string(data) + "," + string(data) + "]"; string(batch.json) != want {
+		t.Errorf("expected json %s, got %s", want, batch.json)
+	}
+
+	one, _ := marshalMsgpack(e.msgpackValue())
+	want := append([]byte{0x92}, append(one, one...)...)
+	if got := batch.bytes(msgpackEncoding); string(got) != string(want) {
+		t.Errorf("envelopes of msgpack batches should be encoded like live frames:\n got %x\nwant %x", got, want)
+	}
+
+	if batch := encodeBatch([]*encodedFrame{frame, {json: []byte(`{"type":"x"}`)}}); batch.value != nil {
+		t.Error("batches of envelopes which are only known as json should be transcoded")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"chat-system/core/repo"
 
 	"context"
+	"log/slog"
 
 	"go.opentelemetry.io/otel"
 )
@@ -14,25 +15,60 @@ type MessageWatcher interface {
 	WatchMessages() (stream <-chan *repo.ChangeStream, cancel func())
 }
 
-// reads all [*repo.ChangeStream] from a channel
-// and calls [roomServer.SendMessageTo] if [repo.ChangeStream.OperationType] is "insert".
-func ReadChangeStream(r MessageWatcher, server *roomServer) {
+// TopicFilterSetter is implemented by [MessageWatcher]s which can skip
+// changes of topics cheaply, before decoding them.
+type TopicFilterSetter interface {
+	// watched reports whether changes of the topic should be watched.
+	SetTopicFilter(watched func(topicId string) bool)
+}
+
+// reads all [*repo.ChangeStream] from a channel, wraps them in an [Envelope]
+// and calls [roomServer.SendMessageTo], until ctx is done or the stream is closed.
+//
+// Envelopes are delivered through [topicLanes], so creating the room of a topic
+// does not block other topics, and envelopes of each topic keep their order.
+// It returns after pending envelopes are delivered.
+//
+// "insert", "update" and "delete" operations are sent as [MessageCreated],
+// [MessageEdited] and [MessageDeleted] envelopes. Changes without Msg
+// (e.g. moving documents to buckets) are ignored.
+func ReadChangeStream(ctx context.Context, r MessageWatcher, server *roomServer) {
 	stream, cancel := r.WatchMessages()
 	defer cancel()
 
-	traceProvicer := otel.Tracer("MessageCDC")
-	for chLog := range stream {
-		ctx := context.Background()
-		if chLog.Carrier != nil {
-			otel.GetTextMapPropagator().Extract(ctx, chLog.Carrier)
-		}
+	lanes := newTopicLanes(ctx, server, otel.Tracer("MessageCDC"))
+	defer lanes.wait()
 
-		ctx, span := traceProvicer.Start(ctx, "SendMessageTo")
+	for {
+		select {
+		case <-ctx.Done():
+			return
 
-		if chLog.OperationType == "insert" {
-			msg := chLog.Msg
-			server.SendMessageTo(ctx, msg.TopicID, msg)
+		case chLog, ok := <-stream:
+			if !ok {
+				return
+			}
+			sendChange(lanes, chLog)
 		}
-		span.End()
 	}
 }
+
+// wraps the change in an [Envelope] and sends it to the lane of its topic.
+func sendChange(lanes *topicLanes, chLog *repo.ChangeStream) {
+	envType, ok := envelopeTypeOf(chLog.OperationType)
+	if !ok || chLog.Msg == nil {
+		return
+	}
+
+	ctx := context.Background()
+	if chLog.Carrier != nil {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, chLog.Carrier)
+	}
+
+	envelope, err := newMessageEnvelope(envType, chLog.Msg)
+	if err != nil {
+		slog.ErrorContext(ctx, "can not create envelope", "documentKey", chLog.DocumentKey, "err", err)
+		return
+	}
+	lanes.send(ctx, chLog.Msg.TopicID, envelope)
+}
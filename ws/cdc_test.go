@@ -1,12 +1,41 @@
 package ws
 
-import "testing"
+import (
+	"chat-system/core/messages"
+	"chat-system/core/repo"
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type mockWatcher struct {
+	ch chan *repo.ChangeStream
+}
+
+func newMockWatcher() *mockWatcher {
+	return &mockWatcher{make(chan *repo.ChangeStream)}
+}
+
+// WatchMessages implements MessageWatcher.
+func (m *mockWatcher) WatchMessages() (stream <-chan *repo.ChangeStream, cancel func()) {
+	return m.ch, func() {}
+}
+
+func (m *mockWatcher) send(opType string, msg *messages.Message) {
+	m.ch <- &repo.ChangeStream{DocumentKey: msg.ID, OperationType: opType, Msg: msg}
+}
+
+func (m *mockWatcher) sendMockMessageTo(topic string) {
+	m.send("insert", &messages.Message{ID: "msg-id", TopicID: topic, Text: "text", Version: 1})
+}
+
+var _ MessageWatcher = &mockWatcher{}
 
 func TestReadChangeStream_withConnectedClients(t *testing.T) {
 	msgWatcher := newMockWatcher()
 	roomserver := NewRoomServer(mockDeviceGetter{}, NewMockTestAuthz())
 
-	go ReadChangeStream(msgWatcher, roomserver)
+	go ReadChangeStream(context.Background(), msgWatcher, roomserver)
 
 	conn := &mockConn{}
 	roomserver.getRoom("topic").addClient(Client{"cli-id", "user", conn})
@@ -17,3 +46,70 @@ func TestReadChangeStream_withConnectedClients(t *testing.T) {
 		t.Error("got empty message bytes")
 	}
 }
+
+func TestReadChangeStream_envelopeTypes(t *testing.T) {
+	msgWatcher := newMockWatcher()
+	roomserver := NewRoomServer(mockDeviceGetter{}, NewMockTestAuthz())
+
+	go ReadChangeStream(context.Background(), msgWatcher, roomserver)
+
+	conn := &mockConn{}
+	roomserver.getRoom("topic").addClient(Client{"cli-id", "user", conn})
+
+	tests := []struct {
+		opType   string
+		expected EnvelopeType
+	}{
+		{"insert", MessageCreated},
+		{"update", MessageEdited},
+		{"delete", MessageDeleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.opType, func(t *testing.T) {
+			msgWatcher.send(tt.opType, &messages.Message{ID: "msg-id", TopicID: "topic", Text: "text", Version: 2})
+
+			envelope := Envelope{}
+			if err := json.Unmarshal(conn.getReceived(), &envelope); err != nil {
+				t.Fatalf("can not unmarshal envelope: %v", err)
+			}
+
+			if envelope.Type != tt.expected {
+				t.Errorf("envelope type is %s, expected %s", envelope.Type, tt.expected)
+			}
+
+			payload := messages.Message{}
+			if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+				t.Fatalf("can not unmarshal payload: %v", err)
+			}
+
+			if payload.ID != "msg-id" || payload.Version != 2 || payload.TopicID != "topic" {
+				t.Errorf("payload must contain id, version and topicId, got %s", envelope.Payload)
+			}
+
+			if tt.expected == MessageDeleted && payload.Text != "" {
+				t.Errorf("deleted payload should not contain text, got %s", envelope.Payload)
+			}
+		})
+	}
+}
+
+func TestReadChangeStream_skipsRemovedDocuments(t *testing.T) {
+	msgWatcher := newMockWatcher()
+	roomserver := NewRoomServer(mockDeviceGetter{}, NewMockTestAuthz())
+
+	go ReadChangeStream(context.Background(), msgWatcher, roomserver)
+
+	conn := &mockConn{}
+	roomserver.getRoom("topic").addClient(Client{"cli-id", "user", conn})
+
+	msgWatcher.ch <- &repo.ChangeStream{DocumentKey: "doc", OperationType: "delete"}
+	msgWatcher.sendMockMessageTo("topic")
+
+	envelope := Envelope{}
+	json.Unmarshal(conn.getReceived(), &envelope)
+
+	if envelope.Type != MessageCreated {
+		t.Errorf("changes without Msg should be skipped, got %s", envelope.Type)
+	}
+}
@@ -0,0 +1,330 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commands sent by clients
+const (
+	CmdSend        EnvelopeType = "send"
+	CmdSubscribe   EnvelopeType = "subscribe"
+	CmdUnsubscribe EnvelopeType = "unsubscribe"
+	CmdPing        EnvelopeType = "ping"
+	CmdResume      EnvelopeType = "resume"
+)
+
+// replies to commands
+const (
+	ReplyAck   EnvelopeType = "ack"
+	ReplyError EnvelopeType = "error"
+)
+
+// codes of [ReplyError] envelopes.
+const (
+	ErrCodeBadRequest     = "bad_request"
+	ErrCodeUnknownCommand = "unknown_command"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeUnsupported    = "unsupported"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeInternal       = "internal"
+)
+
+// same as the REST API's limit.
+const maxMessageLen = 300
+
+type messageService interface {
+	SendMessage(ctx context.Context, topicID string, message string) (messages.Message, error)
+	messageLister
+}
+
+type sendPayload struct {
+	TopicID string `json:"topicId"`
+	Text    string `json:"text"`
+}
+
+type topicPayload struct {
+	TopicID string `json:"topicId"`
+}
+
+type subscribePayload struct {
+	TopicID string `json:"topicId"`
+	// optional ID of the last message which the client received,
+	// messages after it are replayed before live messages.
+	AfterID string `json:"afterId"`
+}
+
+type resumePayload struct {
+	Topics map[string]string `json:"topics"` // topicId -> ID of the last received message
+}
+
+// result of resuming a topic in the [CmdResume]'s reply.
+type resumeResult struct {
+	replayResult
+	Error string `json:"error,omitempty"` // code of the error
+}
+
+type errorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// commandHandler handles commands received from websocket clients
+// and returns a reply for every command.
+type commandHandler struct {
+	rooms  *roomServer
+	msgSvc messageService // can be nil, then "send" and replaying are unsupported
+	typing *typingIndicators
+	tracer trace.Tracer
+}
+
+func newCommandHandler(rooms *roomServer, msgSvc messageService) *commandHandler {
+	return &commandHandler{rooms, msgSvc, newTypingIndicators(rooms, typingTTL), otel.Tracer("ws-commands")}
+}
+
+// handle runs the command and returns [ReplyAck] or [ReplyError] envelope
+// with the same ID as the command.
+func (h *commandHandler) handle(ctx context.Context, c Client, cmd *Envelope) *Envelope {
+	if cmd.Type == CmdTypingStart || cmd.Type == CmdTypingStop {
+		// typing indicators are frequent and ephemeral, so they are not traced.
+		return h.handleTyping(ctx, c, cmd)
+	}
+
+	ctx, span := h.tracer.Start(ctx, "ws."+string(cmd.Type), trace.WithAttributes(
+		attribute.String("clientId", c.ClientId()),
+	))
+	defer span.End()
+
+	ctx = context.WithValue(ctx, authz.UserIdCtxKey, c.UserId())
+
+	var payload any
+	var err error
+
+	switch cmd.Type {
+	case CmdPing:
+
+	case CmdSend:
+		payload, err = h.send(ctx, cmd.Payload)
+
+	case CmdSubscribe:
+		var res *replayResult
+		if res, err = h.subscribe(ctx, c, cmd.Payload); res != nil {
+			payload = res
+		}
+
+	case CmdResume:
+		payload, err = h.resume(ctx, c, cmd.Payload)
+
+	case CmdUnsubscribe:
+		var topicId string
+		if topicId, err = decodeTopicId(cmd.Payload); err == nil {
+			h.rooms.unsubscribe(c, topicId)
+		}
+
+	default:
+		return newErrorReply(cmd.ID, ErrCodeUnknownCommand, "unknown command "+string(cmd.Type))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		return replyForErr(ctx, cmd.ID, err)
+	}
+
+	return newAckReply(cmd.ID, payload)
+}
+
+func (h *commandHandler) handleTyping(ctx context.Context, c Client, cmd *Envelope) *Envelope {
+	topicId, err := decodeTopicId(cmd.Payload)
+	switch {
+	case err != nil:
+	case c.anonymous():
+		err = errReadOnly(c, topicId)
+	case cmd.Type == CmdTypingStart:
+		err = h.typing.start(c, topicId)
+	default:
+		err = h.typing.stop(c, topicId)
+	}
+
+	if err != nil {
+		return replyForErr(ctx, cmd.ID, err)
+	}
+	return newAckReply(cmd.ID, nil)
+}
+
+func (h *commandHandler) send(ctx context.Context, raw json.RawMessage) (*messages.Message, error) {
+	if h.msgSvc == nil {
+		return nil, errUnsupported
+	}
+
+	var p sendPayload
+	if err := decodePayload(raw, &p); err != nil {
+		return nil, err
+	}
+
+	if p.TopicID == "" {
+		return nil, messages.ErrEmptyTopicId
+	}
+
+	if p.Text == "" || utf8.RuneCountInString(p.Text) > maxMessageLen {
+		return nil, badRequestErr("text length must be between 1 and 300")
+	}
+
+	msg, err := h.msgSvc.SendMessage(ctx, p.TopicID, p.Text)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// subscribe subscribes the client to the topic, and replays missed messages
+// if afterId is in the payload.
+func (h *commandHandler) subscribe(ctx context.Context, c Client, raw json.RawMessage) (*replayResult, error) {
+	var p subscribePayload
+	if err := decodePayload(raw, &p); err != nil {
+		return nil, err
+	}
+
+	if p.TopicID == "" {
+		return nil, messages.ErrEmptyTopicId
+	}
+
+	if p.AfterID == "" {
+		return nil, h.rooms.subscribe(c, p.TopicID)
+	}
+
+	if h.msgSvc == nil {
+		return nil, errUnsupported
+	}
+
+	res, err := h.rooms.subscribeAfter(ctx, c, p.TopicID, p.AfterID, h.msgSvc)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// resume subscribes the client to all topics in the payload and replays missed messages
+// for each of them. Failing a topic does not fail others, the error code is
+// in the topic's result instead.
+func (h *commandHandler) resume(ctx context.Context, c Client, raw json.RawMessage) (map[string]resumeResult, error) {
+	if h.msgSvc == nil {
+		return nil, errUnsupported
+	}
+
+	var p resumePayload
+	if err := decodePayload(raw, &p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Topics) == 0 {
+		return nil, badRequestErr("topics are required")
+	}
+
+	results := make(map[string]resumeResult, len(p.Topics))
+	for topicId, afterId := range p.Topics {
+		if topicId == "" || afterId == "" {
+			results[topicId] = resumeResult{Error: ErrCodeBadRequest}
+			continue
+		}
+
+		res, err := h.rooms.subscribeAfter(ctx, c, topicId, afterId, h.msgSvc)
+		if err != nil {
+			results[topicId] = resumeResult{Error: errorCodeOf(ctx, err)}
+			continue
+		}
+		results[topicId] = resumeResult{replayResult: res}
+	}
+
+	return results, nil
+}
+
+type badRequestErr string
+
+func (e badRequestErr) Error() string { return string(e) }
+
+var errUnsupported = errors.New("command is not supported by the server")
+
+// decodePayload decodes the command's payload into v.
+func decodePayload(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return badRequestErr("payload is required")
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return badRequestErr("invalid payload: " + err.Error())
+	}
+	return nil
+}
+
+func decodeTopicId(raw json.RawMessage) (string, error) {
+	var p topicPayload
+	if err := decodePayload(raw, &p); err != nil {
+		return "", err
+	}
+
+	if p.TopicID == "" {
+		return "", messages.ErrEmptyTopicId
+	}
+	return p.TopicID, nil
+}
+
+func replyForErr(ctx context.Context, id string, err error) *Envelope {
+	switch code := errorCodeOf(ctx, err); code {
+	case ErrCodeForbidden:
+		return newErrorReply(id, code, "not authorized")
+	case ErrCodeInternal:
+		return newErrorReply(id, code, "internal error")
+	default:
+		return newErrorReply(id, code, err.Error())
+	}
+}
+
+// returns the code of [ReplyError] for err, and logs internal errors.
+func errorCodeOf(ctx context.Context, err error) string {
+	var badReq badRequestErr
+
+	switch {
+	case errors.As(err, &badReq), errors.Is(err, messages.ErrEmptyTopicId):
+		return ErrCodeBadRequest
+
+	case errors.As(err, &messages.ErrNotAuthorized{}):
+		return ErrCodeForbidden
+
+	case errors.As(err, &messages.ErrNotFound{}):
+		return ErrCodeNotFound
+
+	case errors.Is(err, errUnsupported):
+		return ErrCodeUnsupported
+
+	case errors.Is(err, errRateLimited):
+		return ErrCodeRateLimited
+	}
+
+	slog.ErrorContext(ctx, "websocket command failed", "err", err)
+	return ErrCodeInternal
+}
+
+func newAckReply(id string, payload any) *Envelope {
+	e := &Envelope{ID: id, Type: ReplyAck}
+	if payload != nil {
+		e.Payload, _ = json.Marshal(payload)
+		e.payload = payload
+	}
+	return e
+}
+
+func newErrorReply(id, code, message string) *Envelope {
+	value := errorPayload{code, message}
+	payload, _ := json.Marshal(value)
+	return &Envelope{ID: id, Type: ReplyError, Payload: payload, payload: value}
+}
@@ -0,0 +1,139 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type mockMessageService struct {
+	err error
+}
+
+// SendMessage implements messageService.
+func (m mockMessageService) SendMessage(ctx context.Context, topicID string, message string) (messages.Message, error) {
+	if m.err != nil {
+		return messages.Message{}, m.err
+	}
+	return messages.Message{ID: "msg-id", TopicID: topicID, Text: message, SenderId: authz.UserIdFromCtx(ctx)}, nil
+}
+
+// ListMessages implements messageService.
+func (m mockMessageService) ListMessages(ctx context.Context, topicID string, p messages.Pagination) ([]messages.Message, error) {
+	return nil, m.err
+}
+
+var _ messageService = mockMessageService{}
+
+// returns the code of [ReplyError] envelope.
+func errorCode(t *testing.T, e *Envelope) string {
+	t.Helper()
+
+	p := errorPayload{}
+	if err := json.Unmarshal(e.Payload, &p); err != nil {
+		t.Fatalf("can not unmarshal error payload: %v", err)
+	}
+	return p.Code
+}
+
+func TestCommandHandler_send(t *testing.T) {
+	cli := Client{"client", "user", nil}
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+
+	tests := []struct {
+		name     string
+		sender   messageService
+		payload  string
+		wantCode string
+	}{
+		{"normal", mockMessageService{}, `{"topicId":"topic","text":"hello"}`, ""},
+		{"without-payload", mockMessageService{}, ``, ErrCodeBadRequest},
+		{"without-topic", mockMessageService{}, `{"text":"hello"}`, ErrCodeBadRequest},
+		{"empty-text", mockMessageService{}, `{"topicId":"topic","text":""}`, ErrCodeBadRequest},
+		{"not-authorized", mockMessageService{messages.ErrNotAuthorized{}}, `{"topicId":"topic","text":"hello"}`, ErrCodeForbidden},
+		{"internal-err", mockMessageService{fmt.Errorf("mock error")}, `{"topicId":"topic","text":"hello"}`, ErrCodeInternal},
+		{"without-message-service", nil, `{"topicId":"topic","text":"hello"}`, ErrCodeUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newCommandHandler(rooms, tt.sender)
+			reply := h.handle(context.Background(), cli, &Envelope{ID: "id-1", Type: CmdSend, Payload: json.RawMessage(tt.payload)})
+
+			if reply.ID != "id-1" {
+				t.Errorf("reply must have the command's id, got %s", reply.ID)
+			}
+
+			if tt.wantCode != "" {
+				if reply.Type != ReplyError || errorCode(t, reply) != tt.wantCode {
+					t.Errorf("expected error reply with code %s, got %s %s", tt.wantCode, reply.Type, reply.Payload)
+				}
+				return
+			}
+
+			msg := messages.Message{}
+			json.Unmarshal(reply.Payload, &msg)
+
+			if reply.Type != ReplyAck || msg.ID != "msg-id" {
+				t.Errorf("ack reply must contain the sent message, got %s %s", reply.Type, reply.Payload)
+			}
+
+			if msg.SenderId != cli.UserId() {
+				t.Errorf("message must be sent as the client's user, got %s", msg.SenderId)
+			}
+		})
+	}
+}
+
+func TestCommandHandler_subscribe(t *testing.T) {
+	cli := Client{"client", "user", &mockConn{}}
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	h := newCommandHandler(rooms, nil)
+	ctx := context.Background()
+
+	payload := json.RawMessage(`{"topicId":"topic"}`)
+
+	for range 2 { // subscribing twice must not duplicate the client
+		reply := h.handle(ctx, cli, &Envelope{ID: "1", Type: CmdSubscribe, Payload: payload})
+		if reply.Type != ReplyAck {
+			t.Fatalf("subscribe should be acked, got %s %s", reply.Type, reply.Payload)
+		}
+	}
+
+	room := rooms.getRoom("topic")
+	if n := len(room.onlinePersons.GetClientsForUserId(cli.UserId())); n != 1 {
+		t.Errorf("client should be joined to the room once, got %d", n)
+	}
+
+	reply := h.handle(ctx, cli, &Envelope{ID: "2", Type: CmdUnsubscribe, Payload: payload})
+	if reply.Type != ReplyAck {
+		t.Fatalf("unsubscribe should be acked, got %s %s", reply.Type, reply.Payload)
+	}
+
+	if roomContainsClient(room, cli) {
+		t.Error("client should leave the room")
+	}
+
+	if _, found := rooms.rooms["topic"]; found {
+		t.Error("empty room should be deleted")
+	}
+}
+
+func TestCommandHandler_subscribeNotAuthorized(t *testing.T) {
+	cli := Client{"client", "user", &mockConn{}}
+	rooms := NewRoomServer(mockDeviceGetter{}, &TestAuthz{}) // no authorized topics
+	h := newCommandHandler(rooms, nil)
+
+	reply := h.handle(context.Background(), cli, &Envelope{ID: "1", Type: CmdSubscribe, Payload: json.RawMessage(`{"topicId":"topic"}`)})
+
+	if reply.Type != ReplyError || errorCode(t, reply) != ErrCodeForbidden {
+		t.Errorf("expected forbidden error, got %s %s", reply.Type, reply.Payload)
+	}
+
+	if _, found := rooms.rooms["topic"]; found {
+		t.Error("room should not be created for unauthorized clients")
+	}
+}
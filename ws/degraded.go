@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	roomLookupAttempts    = 3                     // lookups of a new room's members before it is degraded
+	minRoomLookupBackoff  = 50 * time.Millisecond // doubled after each failed attempt
+	maxRoomRefreshBackoff = 30 * time.Second
+
+	// how often degraded rooms are checked for refreshing.
+	degradedRoomsInterval = time.Second
+)
+
+var degradedRoomsCounter, _ = otel.Meter("ws").Int64UpDownCounter("ws.rooms.degraded",
+	metric.WithDescription("number of rooms whose members are not loaded because the authorizer failed"))
+
+// degradedRoom is a room whose members could not be loaded.
+// Its membership is refreshed with exponential backoff until the authorizer succeeds.
+type degradedRoom struct {
+	room    *room
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// lookupWatchers returns users who can watch the topic,
+// retrying [roomLookupAttempts] times with backoff.
+func (r *roomServer) lookupWatchers(topicId string) (userIds []string, err error) {
+	backoff := minRoomLookupBackoff
+
+	for attempt := 1; ; attempt++ {
+		userIds, err = r.authz.WhoCanWatchTopic(topicId)
+		if err == nil || attempt == roomLookupAttempts {
+			return userIds, err
+		}
+
+		slog.Warn("can not get users who can watch the topic", slog.String("topicId", topicId), "attempt", attempt, "err", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// markDegraded schedules refreshing membership of the room. r must be locked.
+func (r *roomServer) markDegraded(room *room) {
+	room.degraded.Store(true)
+	r.degraded[room.ID] = &degradedRoom{room, minRoomLookupBackoff, time.Now().Add(minRoomLookupBackoff)}
+	degradedRoomsCounter.Add(context.Background(), 1)
+}
+
+// refreshDegradedRooms rebuilds membership of degraded rooms whose backoff is elapsed,
+// every interval until ctx is done.
+func (r *roomServer) refreshDegradedRooms(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			r.Lock()
+			due := make([]*degradedRoom, 0)
+			for id, d := range r.degraded {
+				switch {
+				case r.rooms[id] != d.room: // deleted
+					r.recovered(d)
+				case !now.Before(d.retryAt):
+					due = append(due, d)
+				}
+			}
+			r.Unlock()
+
+			for _, d := range due {
+				r.refreshDegraded(ctx, d)
+			}
+		}
+	}
+}
+
+// reloads members of the degraded room, or doubles its backoff.
+func (r *roomServer) refreshDegraded(ctx context.Context, d *degradedRoom) {
+	err := r.reconcileRoom(ctx, d.room.ID, "")
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.degraded[d.room.ID] != d {
+		return
+	}
+
+	if err != nil {
+		d.backoff = min(2*d.backoff, maxRoomRefreshBackoff)
+		d.retryAt = time.Now().Add(d.backoff)
+		slog.Warn("can not refresh degraded room", slog.String("topicId", d.room.ID), "retryAfter", d.backoff, "err", err)
+		return
+	}
+
+	r.recovered(d)
+	slog.Info("degraded room is refreshed", slog.String("topicId", d.room.ID))
+}
+
+// forgets the degraded room. r must be locked.
+func (r *roomServer) recovered(d *degradedRoom) {
+	d.room.degraded.Store(false)
+	delete(r.degraded, d.room.ID)
+	degradedRoomsCounter.Add(context.Background(), -1)
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"chat-system/ws/presence"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoomServer_createRoom_sharedLookup(t *testing.T) {
+	authz := &fakeAuthz{userIds: []string{"user"}, delay: 10 * time.Millisecond}
+	rooms := NewRoomServer(presence.NewMemService[Client](), authz)
+
+	var wg sync.WaitGroup
+	created := make([]*room, 10)
+	for i := range created {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			created[i] = rooms.getRoom("topic")
+		}()
+	}
+	wg.Wait()
+
+	if n := authz.calls.Load(); n != 1 {
+		t.Errorf("concurrent creators should share one lookup, got %d lookups", n)
+	}
+	for _, r := range created {
+		if r != created[0] {
+			t.Fatal("concurrent creators should get the same room")
+		}
+	}
+}
+
+func TestRoomServer_degradedRoom(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	cli := Client{"cli", "user", &recordingConn{}}
+	online.Connect(context.Background(), cli)
+
+	authz := &fakeAuthz{userIds: []string{"user"}}
+	authz.fails.Store(roomLookupAttempts + 1) // the first refresh fails too
+	rooms := NewRoomServer(online, authz)
+
+	room := rooms.getRoom("topic")
+	if n := authz.calls.Load(); n != roomLookupAttempts {
+		t.Errorf("the lookup should be retried, got %d lookups", n)
+	}
+	if !room.degraded.Load() || room.hasClient(cli) {
+		t.Fatal("the room should be degraded without members")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rooms.refreshDegradedRooms(ctx, 10*time.Millisecond)
+
+	for range 100 {
+		if !room.degraded.Load() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if room.degraded.Load() || !room.hasClient(cli) {
+		t.Error("members of the degraded room should be loaded when the authorizer recovers")
+	}
+	if rooms.getRoom("topic") != room {
+		t.Error("the refreshed room should be kept")
+	}
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// frameEncoding is the encoding of envelopes which is negotiated
+// by Sec-WebSocket-Protocol header. Values are names of subprotocols.
+type frameEncoding string
+
+const (
+	// json text frames, the default encoding.
+	jsonEncoding frameEncoding = "json"
+
+	// MessagePack binary frames which have the same structure as json frames.
+	msgpackEncoding frameEncoding = "msgpack"
+)
+
+// frameOptions are negotiated by a client at connect time.
+type frameOptions struct {
+	encoding    frameEncoding
+	subprotocol string // selected subprotocol, empty if the client requested none
+	batches     bool   // accepts json array frames of envelopes
+}
+
+// frameOptionsGetter is implemented by conns which negotiated [frameOptions].
+type frameOptionsGetter interface {
+	frameOptions() frameOptions
+}
+
+func frameOptionsOf(c Client) frameOptions {
+	if g, ok := c.Conn().(frameOptionsGetter); ok {
+		return g.frameOptions()
+	}
+	return frameOptions{encoding: jsonEncoding}
+}
+
+// returns frame options which are requested in the handshake request.
+//
+// The first supported subprotocol of Sec-WebSocket-Protocol header is selected,
+// and json is used if the client requested none of them.
+func negotiateFrameOptions(r *http.Request) frameOptions {
+	batches, _ := strconv.ParseBool(r.URL.Query().Get(batchQueryParam))
+	opts := frameOptions{encoding: jsonEncoding, batches: batches}
+
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			switch enc := frameEncoding(strings.TrimSpace(protocol)); enc {
+			case jsonEncoding, msgpackEncoding:
+				opts.encoding = enc
+				opts.subprotocol = string(enc)
+				return opts
+			}
+		}
+	}
+
+	return opts
+}
+
+// encodedFrame is a json frame and its encodings,
+// so a frame which is written to many clients is encoded once per format.
+type encodedFrame struct {
+	json    []byte
+	value   any // encoded to msgpack instead of json if not nil, see [Envelope.msgpackValue]
+	msgpack []byte
+}
+
+// returns the frame in the encoding, or nil if it can not be encoded.
+func (f *encodedFrame) bytes(enc frameEncoding) []byte {
+	if enc != msgpackEncoding {
+		return f.json
+	}
+
+	if f.msgpack == nil {
+		var err error
+		if f.value != nil {
+			f.msgpack, err = marshalMsgpack(f.value)
+		} else {
+			f.msgpack, err = jsonToMsgpack(f.json)
+		}
+		if err != nil {
+			slog.Error("can not encode frame to msgpack", "err", err)
+		}
+	}
+	return f.msgpack
+}
+
+// frameWriter is implemented by conns which write frames in their negotiated encoding.
+type frameWriter interface {
+	// writeFrame writes the frame. If key is not empty,
+	// pending frames with the same key may be replaced, see [Coalesce].
+	writeFrame(f *encodedFrame, key string)
+}
+
+// writes the frame to conn, in conn's encoding if it's a [frameWriter].
+func writeFrame(conn Conn, f *encodedFrame, key string) error {
+	if w, ok := conn.(frameWriter); ok {
+		w.writeFrame(f, key)
+		return nil
+	}
+	return conn.Write(f.json)
+}
@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFrameOptions(t *testing.T) {
+	tests := []struct {
+		url       string
+		protocols string
+		want      frameOptions
+	}{
+		{"/ws", "", frameOptions{encoding: jsonEncoding}},
+		{"/ws?batch=1", "", frameOptions{encoding: jsonEncoding, batches: true}},
+		{"/ws?batch=true", "", frameOptions{encoding: jsonEncoding, batches: true}},
+		{"/ws?batch=no", "", frameOptions{encoding: jsonEncoding}},
+		{"/ws", "msgpack", frameOptions{encoding: msgpackEncoding, subprotocol: "msgpack"}},
+		{"/ws", "chat.v2, json, msgpack", frameOptions{encoding: jsonEncoding, subprotocol: "json"}},
+		{"/ws", "chat.v2", frameOptions{encoding: jsonEncoding}},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		if tt.protocols != "" {
+			r.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+		}
+
+		if got := negotiateFrameOptions(r); got != tt.want {
+			t.Errorf("negotiateFrameOptions(%s, %q) = %+v, want %+v", tt.url, tt.protocols, got, tt.want)
+		}
+	}
+}
+
+// msgpackConn is a recordingConn of a client which negotiated msgpack.
+type msgpackConn struct {
+	recordingConn
+}
+
+func (*msgpackConn) frameOptions() frameOptions {
+	return frameOptions{encoding: msgpackEncoding}
+}
+
+func (c *msgpackConn) writeFrame(f *encodedFrame, key string) {
+	c.Write(f.bytes(msgpackEncoding))
+}
+
+func TestRoom_fanOutEncodings(t *testing.T) {
+	jsonConn, msgpackConn1, msgpackConn2 := &recordingConn{}, &msgpackConn{}, &msgpackConn{}
+	clients := []Client{{"c1", "u1", jsonConn}, {"c2", "u2", msgpackConn1}, {"c3", "u3", msgpackConn2}}
+	room := newRoom("topic", clients)
+
+	data := []byte(`{"type":"presence.online","payload":{"userId":"u"}}`)
+	room.clientsFanOut(context.Background(), "", &encodedFrame{json: data}, func(yield func(Client) bool) {
+		for _, c := range clients {
+			if !yield(c) {
+				return
+			}
+		}
+	})
+
+	if got := jsonConn.received(); len(got) != 1 || got[0] != string(data) {
+		t.Errorf("json client should receive json frame, got %v", got)
+	}
+
+	want, _ := jsonToMsgpack(data)
+	for _, conn := range []*msgpackConn{msgpackConn1, msgpackConn2} {
+		got := conn.received()
+		if len(got) != 1 || !bytes.Equal([]byte(got[0]), want) {
+			t.Errorf("msgpack client should receive msgpack frame, got %q", got)
+		}
+	}
+
+	if &msgpackConn1.frames[0][0] != &msgpackConn2.frames[0][0] {
+		t.Error("the frame should be encoded once for all msgpack clients")
+	}
+}
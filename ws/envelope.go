@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"encoding/json"
+)
+
+type EnvelopeType string
+
+const (
+	MessageCreated EnvelopeType = "message.created"
+	MessageEdited  EnvelopeType = "message.edited"
+	MessageDeleted EnvelopeType = "message.deleted"
+)
+
+// Envelope is the json frame which is exchanged with websocket clients.
+//
+//	{"type":"message.created","payload":{"id":"...","text":"..."}}
+//
+// Commands sent by clients have an ID, and the reply to
+// a command has the same ID.
+//
+//	{"id":"42","type":"send","payload":{"topicId":"...","text":"..."}}
+//	{"id":"42","type":"ack","payload":{...}}
+type Envelope struct {
+	ID      string          `json:"id,omitempty"`
+	Type    EnvelopeType    `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+
+	payload     any    // value of Payload, which is encoded straight to msgpack if not nil
+	msgId       string // ID of the message in the payload, used for deduplication
+	senderId    string // sender of the message in the payload, used for sampling
+	coalesceKey string // pending envelopes with the same key may be replaced, see [Coalesce]
+}
+
+// payload of [MessageDeleted] envelopes.
+type deletedMessage struct {
+	ID      string `json:"id"`
+	Version uint   `json:"v"`
+	TopicID string `json:"topicId"`
+}
+
+// newMessageEnvelope encodes msg as the payload of an envelope with type t.
+//
+// [MessageDeleted] envelopes only contain the id, version and topicId of msg.
+func newMessageEnvelope(t EnvelopeType, msg *messages.Message) (*Envelope, error) {
+	var value any = msg
+	if t == MessageDeleted {
+		value = deletedMessage{msg.ID, msg.Version, msg.TopicID}
+	}
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{Type: t, Payload: payload, payload: value, msgId: msg.ID, senderId: msg.SenderId}, nil
+}
+
+// returns [EnvelopeType] for the change stream's OperationType.
+func envelopeTypeOf(operationType string) (t EnvelopeType, ok bool) {
+	switch operationType {
+	case "insert":
+		return MessageCreated, true
+	case "update":
+		return MessageEdited, true
+	case "delete":
+		return MessageDeleted, true
+	}
+	return "", false
+}
@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+// expiryTimers closes clients when their tokens expire.
+type expiryTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer // clientId -> timer of the token's expiry
+}
+
+func newExpiryTimers() *expiryTimers {
+	return &expiryTimers{timers: make(map[string]*time.Timer)}
+}
+
+// add calls expire when the token of the client expires at expiresAt.
+// Zero expiresAt never expires.
+func (e *expiryTimers) add(c Client, expiresAt time.Time, expire func(Client)) {
+	if expiresAt.IsZero() {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.timers[c.ClientId()] = time.AfterFunc(time.Until(expiresAt), func() { expire(c) })
+}
+
+// remove stops the timer of the disconnected client.
+func (e *expiryTimers) remove(c Client) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if t, ok := e.timers[c.ClientId()]; ok {
+		t.Stop()
+		delete(e.timers, c.ClientId())
+	}
+}
@@ -2,15 +2,28 @@ package ws
 
 import (
 	"chat-system/authz"
-	"chat-system/ws/presence"
+	"chat-system/pkg/origins"
+	"compress/flate"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	nettyws "github.com/go-netty/go-netty-ws"
 )
 
+// frames smaller than this are not compressed by permessage-deflate.
+const compressThreshold = 512
+
+// clients reconnect within this range after [ServiceRestart], see [wsHandler.drain].
+const defaultReconnectJitter = 10 * time.Second
+
 type errorHandledConn struct {
 	conn  Conn
 	onErr func(error)
@@ -34,23 +47,57 @@ func (c *errorHandledConn) onError(f func(error)) {
 
 // wsHandler manages all online clients and upgrading http requests to websocket.
 type wsHandler struct {
-	onlineClients *presence.MemService[Client]
+	onlineClients clientsPresence
 	dispatcher    *roomDispatcher
-	websocket     *nettyws.Websocket
-	getUserId     func(http.Header) string // gets userId from [http.Header]
+	websocket     *nettyws.Websocket  // writes text frames
+	binary        *nettyws.Websocket  // writes binary frames, used by [msgpackEncoding]
+	authn         authz.Authenticator // can be nil, then only anonymous clients are accepted
+	anonymous     bool                // accepts clients without credentials as [authz.AnonymousUserId]
+	cookieOrigins *origins.Policy     // origins whose handshakes are authenticated by cookies, can be nil
+	commands      *commandHandler     // can be nil, then commands are unsupported
+	heartbeats    *heartbeats
+	sendQueue     sendQueueConf
+	draining      *atomic.Bool // upgrades are rejected while draining
+	reconnect     time.Duration
+	admission     *admission
+	expiries      *expiryTimers // closes clients with [UnAuthorized] code when their tokens expire
 }
 
-func newWsHandler(presence *presence.MemService[Client], dispatcher *roomDispatcher) wsHandler {
-	wsh := nettyws.NewWebsocket(
+type wsHandlerConf struct {
+	sendQueue     sendQueueConf
+	compress      bool          // enables permessage-deflate extension
+	reconnect     time.Duration // jitter of reconnect hints, see [wsHandler.drain]
+	limits        connLimits
+	authn         authz.Authenticator
+	anonymous     bool
+	cookieOrigins *origins.Policy
+}
+
+func newWsHandler(presence clientsPresence, dispatcher *roomDispatcher, commands *commandHandler, conf wsHandlerConf) wsHandler {
+	opts := []nettyws.Option{
 		// nettyws.WithAsyncWrite(10, true),
 		// nettyws.WithBufferSize(2048, 2048),
 		nettyws.WithNoDelay(true),
-	)
+	}
+	if conf.compress {
+		opts = append(opts, nettyws.WithCompress(true, flate.BestSpeed, compressThreshold))
+	}
+
 	s := wsHandler{
 		presence,
 		dispatcher,
-		wsh,
-		authz.UserIdFromCookieHeader,
+		nettyws.NewWebsocket(opts...),
+		nettyws.NewWebsocket(append(opts, nettyws.WithBinary())...),
+		conf.authn,
+		conf.anonymous,
+		conf.cookieOrigins,
+		commands,
+		newHeartbeats(),
+		conf.sendQueue,
+		new(atomic.Bool),
+		conf.reconnect,
+		newAdmission(conf.limits),
+		newExpiryTimers(),
 	}
 
 	s.setupWsHandler()
@@ -58,16 +105,48 @@ func newWsHandler(presence *presence.MemService[Client], dispatcher *roomDispatc
 	return s
 }
 
-func (s wsHandler) setupHttpMiddlewares() http.Handler {
-	handler := authz.NewHttpAuthMiddleware(s)
-
-	return handler
-}
-
 // implements [http.Handler] to upgrade requests to websocket.
+//
+// Clients can request [msgpackEncoding] by Sec-WebSocket-Protocol header,
+// then the binary websocket is used.
+// While draining, it responds 503 with a Retry-After header.
+// Connections over the global or per-IP limit get 503 or 429 responses.
+//
+// Clients are authenticated after upgrading, and closed with [UnAuthorized] code
+// if their credentials are missing or invalid, since browsers can not read handshake responses.
 func (s wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, err := s.websocket.UpgradeHTTP(w, r)
+	if s.draining.Load() {
+		retryAfter := reconnectAfter(s.reconnect)/time.Second + 1
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		http.Error(w, "server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+
+	opts := negotiateFrameOptions(r)
+	if opts.subprotocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", opts.subprotocol)
+	}
+
+	ip := remoteIP(r)
+	if exceeded, ok := s.admission.admitIP(ip); !ok {
+		status := http.StatusTooManyRequests
+		if exceeded == globalLimit {
+			status = http.StatusServiceUnavailable
+		}
+		retryAfter := reconnectAfter(s.reconnect)/time.Second + 1
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		http.Error(w, "too many connections", status)
+		return
+	}
+
+	websocket := s.websocket
+	if opts.encoding == msgpackEncoding {
+		websocket = s.binary
+	}
+
+	_, err := websocket.UpgradeHTTP(w, r)
 	if err != nil {
+		s.admission.releaseIP(ip)
 		if errors.Is(err, nettyws.ErrServerClosed) {
 			http.Error(w, "http: server shutdown", http.StatusNotAcceptable)
 		} else {
@@ -78,40 +157,129 @@ func (s wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *wsHandler) setupWsHandler() {
-	s.websocket.OnOpen = func(conn nettyws.Conn) {
-		userId := s.getUserId(conn.Header())
+	onOpen := func(conn nettyws.Conn) {
+		userId, expiresAt, authErr := s.authenticate(conn.Header())
 
 		errHConn := &errorHandledConn{conn, func(err error) {}}
-		client := Client{userId + randomClientIdSuffix(), userId, errHConn}
+		client := Client{userId + randomClientIdSuffix(), userId, nil}
+		client.conn = newSendQueue(errHConn, s.sendQueue, negotiateFrameOptions(conn.Request()), func() {
+			slog.Warn("send queue of the client is full", slog.String("clientId", client.ClientId()))
+			s.closeClient(client, TryAgainLater)
+		})
 
 		conn.SetUserdata(client)
 
+		if authErr != nil {
+			slog.Debug("websocket client is not authenticated", slog.String("remoteAddr", conn.RemoteAddr()), "err", authErr)
+			s.admission.releaseIP(remoteIP(conn.Request()))
+			closeSendQueue(client)
+			conn.WriteClose(int(UnAuthorized), UnAuthorized.GetCloseReason())
+			conn.Close()
+			return
+		}
+
+		if !s.admission.admitClient(client, remoteIP(conn.Request())) {
+			closeSendQueue(client)
+			conn.WriteClose(int(PolicyVoiolation), "too many connections of the user")
+			conn.Close()
+			return
+		}
+
 		errHConn.onError(func(_ error) {
+			if !s.forget(client) {
+				return // already disconnected
+			}
 			s.onlineClients.Disconnected(context.TODO(), client)
 			conn.WriteClose(1001, "going away")
 			conn.Close()
 			s.dispatcher.dispatch(clientEvent{clientDisconnected, client})
 		})
 
+		s.expiries.add(client, expiresAt, func(c Client) { s.closeClient(c, UnAuthorized) })
 		s.onConnect(conn)
 	}
 
-	s.websocket.OnClose = s.onClose
+	for _, websocket := range []*nettyws.Websocket{s.websocket, s.binary} {
+		websocket.OnOpen = onOpen
+		websocket.OnData = s.onData
+		websocket.OnClose = s.onClose
+	}
+}
+
+// authenticate returns the user of the handshake's token and when it expires.
+// Clients without a token are [authz.AnonymousUserId] if anonymous clients are accepted.
+//
+// The [authz.AccessTokenCookie] is accepted only from origins of s.cookieOrigins,
+// so other sites can not open sockets of their visitors.
+func (s *wsHandler) authenticate(h http.Header) (string, time.Time, error) {
+	token := authz.TokenFromHeader(h)
+	if origin := h.Get("Origin"); token == "" && origin != "" && s.cookieOrigins != nil && s.cookieOrigins.Allowed(origin) {
+		token = authz.TokenFromCookie(h)
+	}
+
+	switch {
+	case token == "" && s.anonymous:
+		return authz.AnonymousUserId, time.Time{}, nil
+	case token == "":
+		return "", time.Time{}, fmt.Errorf("%w: no credentials", authz.ErrUnauthenticated)
+	case s.authn == nil:
+		return "", time.Time{}, fmt.Errorf("%w: no authenticator", authz.ErrUnauthenticated)
+	}
+	return s.authn.Authenticate(token)
 }
 
 // adds conn's [Client] to s.onlineClients and dispatches an event.
 func (s *wsHandler) onConnect(conn nettyws.Conn) {
 	client := conn.Userdata().(Client)
+	s.heartbeats.add(client)
 
-	s.onlineClients.Connect(context.TODO(), client)
+	if err := s.onlineClients.Connect(context.TODO(), client); err != nil {
+		// the client is connected locally, but other servers may see it offline.
+		slog.Error("can not store presence of the client", slog.String("clientId", client.ClientId()), "err", err)
+	}
 	s.dispatcher.dispatch(clientEvent{clientConnected, client})
 }
 
-// removes conn's [Client] from s.onlineClients and dispatches an event.
+// decodes the received frame as a command [Envelope]
+// and writes the reply to the client's [Conn].
+func (s *wsHandler) onData(conn nettyws.Conn, data []byte) {
+	client := conn.Userdata().(Client)
+	s.heartbeats.touch(client)
+
+	var reply *Envelope
+	cmd := Envelope{}
+
+	var err error
+	if frameOptionsOf(client).encoding == msgpackEncoding {
+		data, err = msgpackToJSON(data)
+	}
+
+	if err != nil || json.Unmarshal(data, &cmd) != nil || cmd.Type == "" {
+		reply = newErrorReply(cmd.ID, ErrCodeBadRequest, "invalid envelope")
+	} else if cmd.Type == CmdPong {
+		return // only keeps the connection alive
+	} else if s.commands == nil {
+		reply = newErrorReply(cmd.ID, ErrCodeUnsupported, errUnsupported.Error())
+	} else {
+		reply = s.commands.handle(context.Background(), client, &cmd)
+	}
+
+	b, _ := json.Marshal(reply)
+	writeFrame(client.Conn(), &encodedFrame{json: b, value: reply.msgpackValue()}, "")
+}
+
+// removes conn's [Client] from s.onlineClients and dispatches an event,
+// unless the server already closed the connection.
 func (s *wsHandler) onClose(conn nettyws.Conn, err error) {
 	client := conn.Userdata().(Client)
 
-	s.onlineClients.Disconnected(context.TODO(), client)
+	if !s.forget(client) {
+		return // closed by the server
+	}
+
+	if err := s.onlineClients.Disconnected(context.TODO(), client); err != nil {
+		slog.Error("can not remove presence of the client", slog.String("clientId", client.ClientId()), "err", err)
+	}
 	s.dispatcher.dispatch(clientEvent{clientDisconnected, client})
 
 	slog.Debug("client closed the connection",
@@ -121,17 +289,26 @@ func (s *wsHandler) onClose(conn nettyws.Conn, err error) {
 }
 
 // closeClient disconnect the client from server
-// and writes websocket close frame with code and reason.
+// and writes websocket close frame with code and its reason.
 func (s *wsHandler) closeClient(client Client, code WsCode) {
-	conn, ok := client.Conn().(*errorHandledConn).conn.(nettyws.Conn)
+	s.closeClientWithReason(client, code, code.GetCloseReason())
+}
+
+// closeClientWithReason is like [wsHandler.closeClient] with a custom reason.
+func (s *wsHandler) closeClientWithReason(client Client, code WsCode, reason string) {
+	conn, ok := nettyConnOf(client)
 	if !ok {
-		slog.Error("can not cast client's conn top nettyws.Conn", "conn", conn)
+		slog.Error("can not cast client's conn top nettyws.Conn", "conn", client.Conn())
 		return
 	}
 
+	if !s.forget(client) {
+		return // already disconnected
+	}
+
 	s.onlineClients.Disconnected(context.TODO(), client)
 
-	err := conn.WriteClose(int(code), code.GetCloseReason())
+	err := conn.WriteClose(int(code), reason)
 	if err != nil {
 		slog.Warn("can not write close frame into ws connection", "err", err)
 	}
@@ -140,8 +317,82 @@ func (s *wsHandler) closeClient(client Client, code WsCode) {
 	s.dispatcher.dispatch(clientEvent{clientDisconnected, client})
 }
 
+// forget stops tracking the disconnected client, and reports false
+// if it is already forgotten, so a disconnected client is handled once.
+func (s *wsHandler) forget(client Client) bool {
+	if !s.heartbeats.remove(client) {
+		return false
+	}
+	closeSendQueue(client)
+	s.admission.release(client)
+	s.expiries.remove(client)
+	return true
+}
+
+// returns the websocket connection of the client.
+func nettyConnOf(client Client) (nettyws.Conn, bool) {
+	c := client.Conn()
+	if q, ok := c.(*sendQueue); ok {
+		c = q.conn
+	}
+
+	errHConn, ok := c.(*errorHandledConn)
+	if !ok {
+		return nil, false
+	}
+
+	conn, ok := errHConn.conn.(nettyws.Conn)
+	return conn, ok
+}
+
+// discards pending frames of the client and stops its writer goroutine.
+func closeSendQueue(client Client) {
+	if q, ok := client.Conn().(*sendQueue); ok {
+		q.close()
+	}
+}
+
+// runHeartbeats pings clients and closes connections which miss pongs
+// with [Timeout] code, until shutdown.
+func (s *wsHandler) runHeartbeats(interval, timeout time.Duration) {
+	s.heartbeats.run(interval, timeout, func(c Client) { s.closeClient(c, Timeout) })
+}
+
+// drain waits until pending frames of clients are written or ctx is done,
+// then closes them with [ServiceRestart] code. The close reason is a json hint
+// like {"reconnectAfterMs":1234}, randomized within s.reconnect, so clients
+// do not reconnect to other servers all at once.
+func (s *wsHandler) drain(ctx context.Context) {
+	clients := s.heartbeats.clients()
+	unflushed := 0
+	for _, c := range clients {
+		// queues are written concurrently, so waiting in order takes as long as the slowest.
+		if q, ok := c.Conn().(*sendQueue); ok && !q.flush(ctx) {
+			unflushed++
+		}
+	}
+	if unflushed != 0 {
+		slog.Warn("pending frames of clients are discarded", "clients", unflushed)
+	}
+
+	for _, c := range clients {
+		hint := fmt.Sprintf(`{"reconnectAfterMs":%d}`, reconnectAfter(s.reconnect).Milliseconds())
+		s.closeClientWithReason(c, ServiceRestart, hint)
+	}
+	slog.Info("websocket clients are drained", "clients", len(clients))
+}
+
+// returns a random delay less than jitter.
+func reconnectAfter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter)
+}
+
 func (s *wsHandler) shutdown() error {
-	return s.websocket.Close()
+	s.heartbeats.close()
+	return errors.Join(s.websocket.Close(), s.binary.Close())
 }
 
 type WsCode int
@@ -1,8 +1,11 @@
 package ws
 
 import (
+	"chat-system/authz"
+	"chat-system/pkg/origins"
 	"chat-system/ws/presence"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -21,73 +24,73 @@ type mockNettyConn struct {
 }
 
 // Request implements nettyws.Conn.
-func (m mockNettyConn) Request() *http.Request {
+func (m *mockNettyConn) Request() *http.Request {
 	panic("unimplemented")
 }
 
 // Close implements nettyws.Conn.
-func (m mockNettyConn) Close() error {
+func (m *mockNettyConn) Close() error {
 	args := m.Called()
 	return args.Error(0)
 }
 
 // Context implements nettyws.Conn.
-func (m mockNettyConn) Context() context.Context {
+func (m *mockNettyConn) Context() context.Context {
 	panic("unimplemented")
 }
 
 // Header implements nettyws.Conn.
-func (m mockNettyConn) Header() http.Header {
+func (m *mockNettyConn) Header() http.Header {
 	return nil
 }
 
 // LocalAddr implements nettyws.Conn.
-func (m mockNettyConn) LocalAddr() string {
+func (m *mockNettyConn) LocalAddr() string {
 	panic("unimplemented")
 }
 
 // RemoteAddr implements nettyws.Conn.
-func (m mockNettyConn) RemoteAddr() string {
+func (m *mockNettyConn) RemoteAddr() string {
 	return "remoteAddr"
 }
 
 // SetDeadline implements nettyws.Conn.
-func (m mockNettyConn) SetDeadline(t time.Time) error {
+func (m *mockNettyConn) SetDeadline(t time.Time) error {
 	panic("unimplemented")
 }
 
 // SetReadDeadline implements nettyws.Conn.
-func (m mockNettyConn) SetReadDeadline(t time.Time) error {
+func (m *mockNettyConn) SetReadDeadline(t time.Time) error {
 	panic("unimplemented")
 }
 
 // SetUserdata implements nettyws.Conn.
-func (m mockNettyConn) SetUserdata(userdata interface{}) {
+func (m *mockNettyConn) SetUserdata(userdata interface{}) {
 	m.userData = userdata
 }
 
 // SetWriteDeadline implements nettyws.Conn.
-func (m mockNettyConn) SetWriteDeadline(t time.Time) error {
+func (m *mockNettyConn) SetWriteDeadline(t time.Time) error {
 	panic("unimplemented")
 }
 
 // Userdata implements nettyws.Conn.
-func (m mockNettyConn) Userdata() interface{} {
+func (m *mockNettyConn) Userdata() interface{} {
 	return m.userData
 }
 
 // Write implements nettyws.Conn.
-func (m mockNettyConn) Write(message []byte) error {
+func (m *mockNettyConn) Write(message []byte) error {
 	panic("unimplemented")
 }
 
 // WriteClose implements nettyws.Conn.
-func (m mockNettyConn) WriteClose(code int, reason string) error {
+func (m *mockNettyConn) WriteClose(code int, reason string) error {
 	args := m.Called(code, reason)
 	return args.Error(0)
 }
 
-var _ nettyws.Conn = mockNettyConn{}
+var _ nettyws.Conn = &mockNettyConn{}
 
 func toWsSchema(url string) string {
 	return strings.Replace(url, "http", "ws", 1)
@@ -96,7 +99,7 @@ func toWsSchema(url string) string {
 func TestHttpServer_onConnect(t *testing.T) {
 	presence := presence.NewMemService[Client]()
 	dispatcher := NewRoomDispatcher()
-	wsHandler := newWsHandler(presence, dispatcher)
+	wsHandler := newWsHandler(presence, dispatcher, nil, wsHandlerConf{})
 
 	cli := Client{"clientId", "userId", &errorHandledConn{}}
 
@@ -109,7 +112,7 @@ func TestHttpServer_onConnect(t *testing.T) {
 		clientEvCalled <- true
 	})
 
-	wsHandler.onConnect(mockNettyConn{userData: cli})
+	wsHandler.onConnect(&mockNettyConn{userData: cli})
 
 	devices := presence.GetDevicesForUsers(cli.UserId())
 	assert.Len(t, devices, 1)
@@ -119,7 +122,7 @@ func TestHttpServer_onConnect(t *testing.T) {
 }
 
 func TestHttpServer_OnClose_called(t *testing.T) {
-	wsHandler := newWsHandler(presence.NewMemService[Client](), NewRoomDispatcher())
+	wsHandler := newWsHandler(presence.NewMemService[Client](), NewRoomDispatcher(), nil, wsHandlerConf{})
 
 	onClosedCalled := make(chan bool, 1)
 	wsHandler.websocket.OnClose = func(conn nettyws.Conn, err error) {
@@ -148,7 +151,7 @@ func TestHttpServer_OnClose_called(t *testing.T) {
 func TestHttpServer_OnClose(t *testing.T) {
 	presence := presence.NewMemService[Client]()
 	dispatcher := NewRoomDispatcher()
-	wsHandler := newWsHandler(presence, dispatcher)
+	wsHandler := newWsHandler(presence, dispatcher, nil, wsHandlerConf{})
 
 	cli := Client{"cId", "uId", &errorHandledConn{}}
 	conn := &mockNettyConn{userData: cli}
@@ -178,7 +181,7 @@ func TestHttpServer_OnClose(t *testing.T) {
 func TestHttpServer_closeClient(t *testing.T) {
 	presence := presence.NewMemService[Client]()
 	dispatcher := NewRoomDispatcher()
-	wsHandler := newWsHandler(presence, dispatcher)
+	wsHandler := newWsHandler(presence, dispatcher, nil, wsHandlerConf{})
 
 	cli := &Client{"cliId", "userId", nil}
 	conn := &mockNettyConn{userData: *cli}
@@ -217,4 +220,153 @@ func TestHttpServer_closeClient(t *testing.T) {
 	}
 }
 
+func TestHttpServer_drain(t *testing.T) {
+	presence := presence.NewMemService[Client]()
+	wsHandler := newWsHandler(presence, NewRoomDispatcher(), nil, wsHandlerConf{reconnect: time.Second})
+
+	conn := &mockNettyConn{}
+	conn.userData = Client{"cliId", "userId", &errorHandledConn{conn: conn}}
+
+	isHint := func(reason string) bool {
+		hint := struct{ ReconnectAfterMs *int64 }{}
+		err := json.Unmarshal([]byte(reason), &hint)
+		return err == nil && hint.ReconnectAfterMs != nil && *hint.ReconnectAfterMs < 1000
+	}
+	conn.On("WriteClose", int(ServiceRestart), mock.MatchedBy(isHint)).Return(nil).Once()
+	conn.On("Close").Return(nil)
+
+	wsHandler.onConnect(conn)
+	wsHandler.draining.Store(true)
+	wsHandler.drain(context.Background())
+
+	if !presence.IsEmpty() {
+		t.Error("drain should delete clients from presence service")
+	}
+	conn.AssertExpectations(t)
+
+	w := httptest.NewRecorder()
+	wsHandler.ServeHTTP(w, httptest.NewRequest("GET", "/ws", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("upgrades should be rejected while draining, got %d", w.Code)
+	}
+}
+
+func TestHttpServer_onData(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	wsHandler := newWsHandler(presence.NewMemService[Client](), NewRoomDispatcher(), newCommandHandler(rooms, nil), wsHandlerConf{})
+
+	tests := []struct {
+		name      string
+		frame     string
+		replyID   string
+		replyType EnvelopeType
+	}{
+		{"ping", `{"id":"1","type":"ping"}`, "1", ReplyAck},
+		{"invalid-json", `{"id":"2",`, "", ReplyError},
+		{"without-type", `{"id":"3"}`, "3", ReplyError},
+		{"unknown-command", `{"id":"4","type":"dance"}`, "4", ReplyError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &mockConn{}
+			cli := Client{"cliId", "userId", conn}
+
+			wsHandler.onData(&mockNettyConn{userData: cli}, []byte(tt.frame))
+
+			reply := Envelope{}
+			err := json.Unmarshal(conn.getReceived(), &reply)
+			assert.NoError(t, err, "reply must be a json envelope")
+
+			assert.Equal(t, tt.replyID, reply.ID, "reply must have the command's id")
+			assert.Equal(t, tt.replyType, reply.Type)
+		})
+	}
+}
+
 var _ http.Handler = wsHandler{}
+
+func TestHttpServer_authenticate(t *testing.T) {
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+	cookie := func(origin string) http.Header {
+		h := http.Header{"Cookie": []string{authz.AccessTokenCookie + "=bob"}}
+		if origin != "" {
+			h.Set("Origin", origin)
+		}
+		return h
+	}
+	allowed := origins.MustParse("https://chat.example.com")
+
+	tests := []struct {
+		name          string
+		header        http.Header
+		anonymous     bool
+		cookieOrigins *origins.Policy
+		userId        string
+		ok            bool
+	}{
+		{"bearer", bearer("alice"), false, nil, "alice", true},
+		{"cookie", cookie("https://chat.example.com"), false, allowed, "bob", true},
+		{"cookie-cross-origin", cookie("https://attacker.example"), false, allowed, "", false},
+		{"cookie-without-origin", cookie(""), false, allowed, "", false},
+		{"cookie-without-allowed-origins", cookie("https://attacker.example"), false, nil, "", false},
+		{"cookie-cross-origin-anonymous", cookie("https://attacker.example"), true, allowed, authz.AnonymousUserId, true},
+		{"invalid", bearer("invalid"), true, nil, "", false},
+		{"missing", http.Header{}, false, nil, "", false},
+		{"anonymous", http.Header{}, true, nil, authz.AnonymousUserId, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := wsHandlerConf{authn: rejectingTokens{"invalid"}, anonymous: tt.anonymous, cookieOrigins: tt.cookieOrigins}
+			wsHandler := newWsHandler(presence.NewMemService[Client](), NewRoomDispatcher(), nil, conf)
+
+			userId, _, err := wsHandler.authenticate(tt.header)
+			if tt.ok {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.userId, userId)
+			} else {
+				assert.ErrorIs(t, err, authz.ErrUnauthenticated, "clients should be closed with UnAuthorized code")
+			}
+		})
+	}
+}
+
+// rejectingTokens authenticates tokens which are userIds, except the invalid token.
+type rejectingTokens struct{ invalid string }
+
+func (r rejectingTokens) Authenticate(token string) (string, time.Time, error) {
+	if token == r.invalid {
+		return "", time.Time{}, fmt.Errorf("%w: invalid token", authz.ErrUnauthenticated)
+	}
+	return token, time.Time{}, nil
+}
+
+func TestHttpServer_tokenExpiry(t *testing.T) {
+	presence := presence.NewMemService[Client]()
+	wsHandler := newWsHandler(presence, NewRoomDispatcher(), nil, wsHandlerConf{})
+
+	conn := &mockNettyConn{}
+	cli := Client{"cliId", "userId", &errorHandledConn{conn: conn}}
+	conn.userData = cli
+
+	closed := make(chan struct{})
+	conn.On("WriteClose", int(UnAuthorized), UnAuthorized.GetCloseReason()).Return(nil).Once()
+	conn.On("Close").Return(nil).Run(func(mock.Arguments) { close(closed) })
+
+	wsHandler.onConnect(conn)
+	wsHandler.expiries.add(cli, time.Now().Add(10*time.Millisecond), func(c Client) { wsHandler.closeClient(c, UnAuthorized) })
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("client should be closed when its token expires")
+	}
+
+	if !presence.IsEmpty() {
+		t.Error("expired client should be removed from presence service")
+	}
+	conn.AssertExpectations(t)
+}
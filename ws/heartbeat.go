@@ -0,0 +1,144 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Heartbeat is sent by the server to check that clients are alive,
+// if heartbeats are enabled by [WithHeartbeat].
+const Heartbeat EnvelopeType = "heartbeat"
+
+// CmdPong is sent by clients in reply to [Heartbeat] envelopes of the server.
+// It is not replied.
+const CmdPong EnvelopeType = "pong"
+
+var reapedConnections, _ = otel.Meter("ws").Int64Counter("ws.connections.reaped",
+	metric.WithDescription("number of connections closed because of missed pongs"))
+
+// heartbeats tracks connected clients and the last time each one sent a frame.
+//
+// go-netty-ws does not expose websocket control frames, so the server pings
+// clients with [Heartbeat] envelopes, and any received frame counts as a pong.
+type heartbeats struct {
+	mu       sync.Mutex
+	lastSeen map[string]*clientLastSeen // clientId -> last received frame
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type clientLastSeen struct {
+	client   Client
+	at       time.Time // last received frame
+	pingedAt time.Time // last sent heartbeat, or when the client is added
+}
+
+func newHeartbeats() *heartbeats {
+	return &heartbeats{
+		lastSeen: make(map[string]*clientLastSeen),
+		stop:     make(chan struct{}),
+	}
+}
+
+func (h *heartbeats) add(c Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.lastSeen[c.ClientId()] = &clientLastSeen{c, now, now}
+}
+
+// remove forgets the client and reports whether it was tracked,
+// so a disconnected client is handled once.
+func (h *heartbeats) remove(c Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, found := h.lastSeen[c.ClientId()]
+	delete(h.lastSeen, c.ClientId())
+	return found
+}
+
+// returns tracked clients.
+func (h *heartbeats) clients() []Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	clients := make([]Client, 0, len(h.lastSeen))
+	for _, s := range h.lastSeen {
+		clients = append(clients, s.client)
+	}
+	return clients
+}
+
+// touch records a received frame of the client.
+func (h *heartbeats) touch(c Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if s, found := h.lastSeen[c.ClientId()]; found {
+		s.at = time.Now()
+	}
+}
+
+// due returns clients which sent nothing within timeout after their last heartbeat,
+// and clients which were pinged interval ago and replied. The latter are marked
+// as pinged at now. Clients are not pinged again while their reply is awaited,
+// so their deadline is not moved.
+func (h *heartbeats) due(now time.Time, interval, timeout time.Duration) (dead []Client, ping []Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.lastSeen {
+		awaited := s.at.Before(s.pingedAt)
+		switch {
+		case awaited && now.Sub(s.pingedAt) >= timeout:
+			dead = append(dead, s.client)
+		case !awaited && now.Sub(s.pingedAt) >= interval:
+			s.pingedAt = now
+			ping = append(ping, s.client)
+		}
+	}
+	return
+}
+
+// run pings each client every interval since it connected, and calls reap for
+// clients which send nothing within timeout after a ping. Clients are checked
+// at a fraction of the shorter duration, so each one gets its own deadline.
+// It returns after close.
+func (h *heartbeats) run(interval, timeout time.Duration, reap func(Client)) {
+	ticker := time.NewTicker(max(min(interval, timeout)/4, time.Millisecond))
+	defer ticker.Stop()
+
+	ping, _ := json.Marshal(&Envelope{Type: Heartbeat})
+
+	for {
+		select {
+		case <-h.stop:
+			return
+
+		case now := <-ticker.C:
+			dead, alive := h.due(now, interval, timeout)
+
+			for _, c := range dead {
+				slog.Debug("reaping dead connection", slog.String("clientId", c.ClientId()))
+				reapedConnections.Add(context.Background(), 1)
+				reap(c)
+			}
+
+			for _, c := range alive {
+				c.Conn().Write(ping)
+			}
+		}
+	}
+}
+
+func (h *heartbeats) close() {
+	h.stopOnce.Do(func() { close(h.stop) })
+}
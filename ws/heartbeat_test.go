@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeartbeats(t *testing.T) {
+	h := newHeartbeats()
+
+	aliveConn, deadConn := &recordingConn{}, &recordingConn{}
+	alive := Client{"alive", "user", aliveConn}
+	dead := Client{"dead", "user", deadConn}
+
+	h.add(alive)
+	h.add(dead)
+
+	var mu sync.Mutex
+	reaped := []string{}
+
+	done := make(chan struct{})
+	go func() {
+		h.run(20*time.Millisecond, 50*time.Millisecond, func(c Client) {
+			mu.Lock()
+			defer mu.Unlock()
+			reaped = append(reaped, c.ClientId())
+			h.remove(c)
+		})
+		close(done)
+	}()
+
+	for range 30 {
+		time.Sleep(5 * time.Millisecond)
+		h.touch(alive) // pongs
+	}
+
+	h.close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !slices.Equal(reaped, []string{"dead"}) {
+		t.Errorf("only the silent client should be reaped, got %v", reaped)
+	}
+
+	if types := aliveConn.envelopeTypes(t); len(types) == 0 || types[0] != Heartbeat {
+		t.Errorf("alive client should be pinged, got %v", types)
+	}
+
+	if h.remove(dead) {
+		t.Error("reaped client should not be tracked")
+	}
+}
+
+func TestHeartbeats_remove(t *testing.T) {
+	h := newHeartbeats()
+	c := Client{"client", "user", nil}
+
+	h.add(c)
+
+	if !h.remove(c) {
+		t.Error("first remove should report the client was tracked")
+	}
+	if h.remove(c) {
+		t.Error("second remove should report the client was not tracked")
+	}
+}
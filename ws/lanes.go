@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maximum pending envelopes of all topics. Reading the change stream is paused
+// while it is reached, so envelopes are never dropped. A slow topic does not
+// pause other topics until it has all of them, and its room creation is bounded
+// by retries (see [roomServer.lookupWatchers]).
+const maxPendingEnvelopes = 64 * 1024
+
+// topicLanes delivers envelopes of each topic in order, in the topic's own goroutine,
+// so a slow room creation (e.g. authorizer lookups of a new topic) only delays its topic.
+// Envelopes of a room which is being created wait in its lane.
+//
+// A lane's goroutine exits when its lane is empty.
+type topicLanes struct {
+	server     *roomServer
+	tracer     trace.Tracer
+	done       <-chan struct{} // stops waiting for space
+	maxPending int
+
+	mu      sync.Mutex
+	pending int                   // envelopes of all lanes
+	space   chan struct{}         // closed when pending is below maxPending, nil if nobody waits
+	lanes   map[string]*topicLane // topicId -> lane with pending envelopes
+	wg      sync.WaitGroup
+}
+
+type topicLane struct {
+	pending []laneEnvelope
+}
+
+type laneEnvelope struct {
+	ctx      context.Context // carries the trace of the change
+	envelope *Envelope
+}
+
+// newTopicLanes returns lanes which stop waiting for space when ctx is done.
+func newTopicLanes(ctx context.Context, server *roomServer, tracer trace.Tracer) *topicLanes {
+	return &topicLanes{
+		server: server, tracer: tracer, done: ctx.Done(), maxPending: maxPendingEnvelopes,
+		lanes: make(map[string]*topicLane),
+	}
+}
+
+// send appends the envelope to the lane of topicId. It blocks while lanes have
+// maxPending envelopes, and the envelope is dropped if the lanes are stopped meanwhile.
+func (l *topicLanes) send(ctx context.Context, topicId string, e *Envelope) {
+	l.mu.Lock()
+	for l.pending >= l.maxPending {
+		if l.space == nil {
+			l.space = make(chan struct{})
+		}
+		space := l.space
+		l.mu.Unlock()
+
+		select {
+		case <-space:
+		case <-l.done:
+			return
+		}
+		l.mu.Lock()
+	}
+	defer l.mu.Unlock()
+
+	l.pending++
+	lane, found := l.lanes[topicId]
+	if !found {
+		lane = &topicLane{pending: []laneEnvelope{{ctx, e}}}
+		l.lanes[topicId] = lane
+
+		l.wg.Add(1)
+		go l.run(topicId, lane)
+		return
+	}
+
+	lane.pending = append(lane.pending, laneEnvelope{ctx, e})
+}
+
+// delivers envelopes of the lane until it is empty. Envelopes are removed
+// after delivering, so they count as pending while their room is created.
+func (l *topicLanes) run(topicId string, lane *topicLane) {
+	defer l.wg.Done()
+
+	l.mu.Lock()
+	for len(lane.pending) != 0 {
+		next := lane.pending[0]
+		l.mu.Unlock()
+
+		ctx, span := l.tracer.Start(next.ctx, "SendMessageTo")
+		l.server.SendMessageTo(ctx, topicId, next.envelope)
+		span.End()
+
+		l.mu.Lock()
+		lane.pending[0] = laneEnvelope{}
+		lane.pending = lane.pending[1:]
+		l.pending--
+		if l.space != nil && l.pending < l.maxPending {
+			close(l.space)
+			l.space = nil
+		}
+	}
+
+	delete(l.lanes, topicId)
+	l.mu.Unlock()
+}
+
+// wait waits until all lanes are delivered.
+func (l *topicLanes) wait() {
+	l.wg.Wait()
+}
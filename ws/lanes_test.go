@@ -0,0 +1,94 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"chat-system/ws/presence"
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestTopicLanes(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	conn := &recordingConn{}
+	online.Connect(context.Background(), Client{"cli", "user", conn})
+
+	authz := &fakeAuthz{userIds: []string{"user"}, blocked: "slow", unblock: make(chan struct{})}
+	lanes := newTopicLanes(context.Background(), NewRoomServer(online, authz), otel.Tracer("test"))
+
+	send := func(topicId, msgId string) {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: msgId, TopicID: topicId})
+		lanes.send(context.Background(), topicId, e)
+	}
+
+	send("slow", "1")
+	send("slow", "2")
+	send("fast", "3")
+
+	waitForFrames(t, conn, 1)
+	if ids := conn.messageIds(t, MessageCreated); !slices.Equal(ids, []string{"3"}) {
+		t.Errorf("other topics should not wait for creating the slow room, got %v", ids)
+	}
+
+	send("slow", "4")
+	close(authz.unblock)
+	lanes.wait()
+
+	if ids := conn.messageIds(t, MessageCreated); !slices.Equal(ids, []string{"3", "1", "2", "4"}) {
+		t.Errorf("envelopes of the slow room should be buffered in order, got %v", ids)
+	}
+
+	lanes.mu.Lock()
+	defer lanes.mu.Unlock()
+	if len(lanes.lanes) != 0 {
+		t.Errorf("delivered lanes should be removed, got %d", len(lanes.lanes))
+	}
+}
+
+func TestTopicLanes_full(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	conn := &recordingConn{}
+	online.Connect(context.Background(), Client{"cli", "user", conn})
+
+	authz := &fakeAuthz{userIds: []string{"user"}, blocked: "slow", unblock: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	lanes := newTopicLanes(ctx, NewRoomServer(online, authz), otel.Tracer("test"))
+	lanes.maxPending = 2
+
+	send := func(topicId, msgId string) {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: msgId, TopicID: topicId})
+		lanes.send(context.Background(), topicId, e)
+	}
+
+	send("slow", "1")
+	send("slow", "2")
+
+	sent := make(chan struct{})
+	go func() {
+		send("slow", "3")
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("sending should wait while lanes are full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("sending should stop waiting when ctx is done")
+	}
+
+	close(authz.unblock)
+	lanes.wait()
+
+	if ids := conn.messageIds(t, MessageCreated); !slices.Equal(ids, []string{"1", "2"}) {
+		t.Errorf("pending envelopes should be delivered, got %v", ids)
+	}
+}
@@ -0,0 +1,137 @@
+package ws
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// connLimits caps connections of each user, each IP and the whole server.
+// Zero means unlimited.
+type connLimits struct {
+	perUser int
+	perIP   int
+	global  int
+}
+
+// limits exceeded by rejected connections, used as "limit" attribute of metrics.
+const (
+	userLimit   = "user"
+	ipLimit     = "ip"
+	globalLimit = "global"
+)
+
+var rejectedConnections, _ = otel.Meter("ws").Int64Counter("ws.connections.rejected",
+	metric.WithDescription("number of connections rejected because of connection limits"))
+
+// admission counts connections against [connLimits].
+//
+// The global and per-IP limits are checked before upgrading, so flooding costs
+// no websocket and no authorization lookups. The per-user limit is checked after
+// upgrading, since browsers can not read handshake responses, and a buggy client
+// should know why it is closed.
+type admission struct {
+	limits connLimits
+
+	mu        sync.Mutex
+	total     int
+	perIP     map[string]int
+	perUser   map[string]int
+	clientIPs map[string]string // clientId -> IP of admitted clients
+}
+
+func newAdmission(limits connLimits) *admission {
+	return &admission{
+		limits:    limits,
+		perIP:     make(map[string]int),
+		perUser:   make(map[string]int),
+		clientIPs: make(map[string]string),
+	}
+}
+
+// admitIP reserves a connection of the IP, or returns the exceeded limit.
+// The reservation is kept by [admission.admitClient] or released by [admission.releaseIP].
+func (a *admission) admitIP(ip string) (exceeded string, ok bool) {
+	a.mu.Lock()
+	switch {
+	case a.limits.global > 0 && a.total >= a.limits.global:
+		exceeded = globalLimit
+	case a.limits.perIP > 0 && a.perIP[ip] >= a.limits.perIP:
+		exceeded = ipLimit
+	default:
+		a.total++
+		a.perIP[ip]++
+	}
+	a.mu.Unlock()
+
+	if exceeded != "" {
+		countRejection(exceeded)
+		return exceeded, false
+	}
+	return "", true
+}
+
+// releaseIP releases a reservation of [admission.admitIP].
+func (a *admission) releaseIP(ip string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total--
+	if a.perIP[ip]--; a.perIP[ip] <= 0 {
+		delete(a.perIP, ip)
+	}
+}
+
+// admitClient counts the client of an admitted IP against the per-user limit.
+// If the user has too many connections, the IP reservation is released and false is returned.
+// Anonymous clients are not one user, so they are only limited per IP.
+func (a *admission) admitClient(c Client, ip string) bool {
+	a.mu.Lock()
+	if a.limits.perUser > 0 && !c.anonymous() && a.perUser[c.UserId()] >= a.limits.perUser {
+		a.mu.Unlock()
+		a.releaseIP(ip)
+		countRejection(userLimit)
+		return false
+	}
+
+	a.perUser[c.UserId()]++
+	a.clientIPs[c.ClientId()] = ip
+	a.mu.Unlock()
+	return true
+}
+
+// release forgets a client admitted by [admission.admitClient].
+func (a *admission) release(c Client) {
+	a.mu.Lock()
+	ip, found := a.clientIPs[c.ClientId()]
+	if !found {
+		a.mu.Unlock()
+		return
+	}
+
+	delete(a.clientIPs, c.ClientId())
+	if a.perUser[c.UserId()]--; a.perUser[c.UserId()] <= 0 {
+		delete(a.perUser, c.UserId())
+	}
+	a.mu.Unlock()
+
+	a.releaseIP(ip)
+}
+
+func countRejection(limit string) {
+	rejectedConnections.Add(context.Background(), 1, metric.WithAttributes(attribute.String("limit", limit)))
+}
+
+// returns the IP of the request's peer, without port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdmission(t *testing.T) {
+	a := newAdmission(connLimits{perUser: 1, perIP: 2, global: 3})
+
+	c1 := Client{"c1", "user1", nil}
+	c2 := Client{"c2", "user1", nil}
+
+	if _, ok := a.admitIP("ip1"); !ok || !a.admitClient(c1, "ip1") {
+		t.Fatal("the first connection should be admitted")
+	}
+
+	if _, ok := a.admitIP("ip1"); !ok {
+		t.Fatal("the second connection of ip1 should be admitted")
+	}
+	if a.admitClient(c2, "ip1") {
+		t.Error("the second connection of user1 should be rejected")
+	}
+
+	a.admitIP("ip1")
+	if exceeded, ok := a.admitIP("ip1"); ok || exceeded != ipLimit {
+		t.Errorf("the third connection of ip1 should exceed ip limit, got %q", exceeded)
+	}
+
+	a.admitIP("ip2")
+	if exceeded, ok := a.admitIP("ip3"); ok || exceeded != globalLimit {
+		t.Errorf("the fourth connection should exceed global limit, got %q", exceeded)
+	}
+
+	a.release(c1)
+	a.release(c1) // released once
+	if _, ok := a.admitIP("ip3"); !ok || !a.admitClient(c2, "ip3") {
+		t.Error("connections should be admitted after release")
+	}
+	if a.total != 3 || a.perIP["ip1"] != 1 {
+		t.Errorf("unexpected counts: total %d, ip1 %d", a.total, a.perIP["ip1"])
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.RemoteAddr = "[::1]:4321"
+
+	if ip := remoteIP(r); ip != "::1" {
+		t.Errorf("port should be removed, got %q", ip)
+	}
+}
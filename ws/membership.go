@@ -0,0 +1,197 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// TopicAccessRevoked is sent to clients which are removed from a room,
+// because their user can not watch the topic anymore.
+const TopicAccessRevoked EnvelopeType = "topic.access_revoked"
+
+// payload of [TopicAccessRevoked] envelopes.
+type accessRevokedPayload struct {
+	TopicID string `json:"topicId"`
+}
+
+const (
+	minPermissionWatchBackoff = time.Second
+	maxPermissionWatchBackoff = time.Minute
+
+	// all rooms are reconciled every interval, since changes of subjects
+	// (e.g. members of groups) are not watched.
+	permissionReconcileInterval = 5 * time.Minute
+)
+
+// topicChangesWatcher is implemented by authorizers which stream permission changes of topics.
+type topicChangesWatcher interface {
+	// WatchTopicChanges calls changed with ids of topics whose permissions may be changed,
+	// and the position of the changes, until ctx is done or watching fails.
+	// cursor is the position to resume watching from, and it is updated while watching.
+	// Empty cursor watches changes from now.
+	// The error wraps [authz.ErrCursorExpired] if watching can not be resumed from cursor.
+	WatchTopicChanges(ctx context.Context, cursor *string, changed func(topicId, token string)) error
+}
+
+// whoCanWatchTopicAsFreshAs is implemented by authorizers which can look up watchers
+// of topics at least as fresh as a position of [topicChangesWatcher]. Otherwise
+// watchers may be looked up from a replica which has not seen the change yet.
+type whoCanWatchTopicAsFreshAs interface {
+	WhoCanWatchTopicAsFreshAs(topicId, token string) ([]string, error)
+}
+
+// watchPermissions reconciles rooms of topics whose permissions change, until ctx is done.
+// Watching is resumed from the last position with exponential backoff in case of errors.
+// If the position is expired, changes since then are lost, so all rooms are reconciled
+// and watching is restarted from now.
+func (r *roomServer) watchPermissions(ctx context.Context, w topicChangesWatcher) {
+	cursor := ""
+	backoff := minPermissionWatchBackoff
+
+	for {
+		started := time.Now()
+		err := w.WatchTopicChanges(ctx, &cursor, func(topicId, token string) {
+			if err := r.reconcileRoom(ctx, topicId, token); err != nil {
+				slog.Error("can not reconcile room", slog.String("topicId", topicId), "err", err)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		if errors.Is(err, authz.ErrCursorExpired) {
+			slog.Warn("permissions watch cursor is expired, reconciling all rooms", "err", err)
+			cursor = ""
+			r.reconcileRooms(ctx)
+			continue
+		}
+
+		if time.Since(started) > maxPermissionWatchBackoff {
+			backoff = minPermissionWatchBackoff // it was watching for a while
+		}
+		slog.Error("watching topic permissions failed", "retryAfter", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxPermissionWatchBackoff)
+	}
+}
+
+// reconcileRoomsEvery reconciles all rooms every interval until ctx is done.
+func (r *roomServer) reconcileRoomsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reconcileRooms(ctx)
+		}
+	}
+}
+
+// reconcileRooms reconciles all existing rooms.
+func (r *roomServer) reconcileRooms(ctx context.Context) {
+	r.RLock()
+	topicIds := make([]string, 0, len(r.rooms))
+	for topicId := range r.rooms {
+		topicIds = append(topicIds, topicId)
+	}
+	r.RUnlock()
+
+	for _, topicId := range topicIds {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := r.reconcileRoom(ctx, topicId, ""); err != nil {
+			slog.Error("can not reconcile room", slog.String("topicId", topicId), "err", err)
+		}
+	}
+}
+
+// reconcileRoom removes clients from the room of the topic if their users can not watch it
+// anymore, and sends them [TopicAccessRevoked]. In [AutoSubscription] mode online clients
+// of newly authorized users are added to the room.
+//
+// Everyone can watch public topics, so no client is removed from their rooms,
+// and their watchers are not looked up.
+// When a topic becomes private, its anonymous clients are removed.
+//
+// Rooms which do not exist are not created, since they load permissions on creation.
+func (r *roomServer) reconcileRoom(ctx context.Context, topicId, token string) error {
+	room := r.existingRoom(topicId)
+	if room == nil {
+		return nil
+	}
+
+	public, err := r.isTopicPublic(topicId)
+	if err != nil {
+		return err
+	}
+
+	room.public.Store(public)
+	if public {
+		return nil // nobody is revoked, and watchers of public topics are not looked up
+	}
+
+	userIds, err := r.whoCanWatchTopicAsFreshAs(topicId, token)
+	if err != nil {
+		return fmt.Errorf("can not get users who can watch the topic: %w", err)
+	}
+
+	authorized := make(map[string]struct{}, len(userIds))
+	for _, id := range userIds {
+		authorized[id] = struct{}{}
+	}
+
+	revoked := []Client{}
+	clients, _ := room.onlinePersons.GetOnlineClients(ctx)
+	for c := range clients {
+		if _, ok := authorized[c.UserId()]; !ok {
+			revoked = append(revoked, c)
+		}
+	}
+
+	payload, _ := json.Marshal(accessRevokedPayload{topicId})
+	notice, _ := json.Marshal(Envelope{Type: TopicAccessRevoked, Payload: payload})
+	for _, c := range revoked {
+		r.leaveClientFromRoom(c, room)
+		c.Conn().Write(notice)
+	}
+
+	added := 0
+	if !r.explicitMode() {
+		for _, c := range r.onlinePersons.GetDevicesForUsers(userIds...) {
+			if !room.hasClient(c) {
+				r.joinClientToRooms(c, room)
+				added++
+			}
+		}
+	}
+
+	if len(revoked) != 0 || added != 0 {
+		slog.Info("room is reconciled", slog.String("topicId", topicId), "revoked", len(revoked), "added", added)
+	}
+
+	r.deleteIfEmpty(room)
+	return nil
+}
+
+// whoCanWatchTopicAsFreshAs looks up watchers of the topic at least as fresh as token,
+// if the authorizer supports it.
+func (r *roomServer) whoCanWatchTopicAsFreshAs(topicId, token string) ([]string, error) {
+	if fresh, ok := r.authz.(whoCanWatchTopicAsFreshAs); ok && token != "" {
+		return fresh.WhoCanWatchTopicAsFreshAs(topicId, token)
+	}
+	return r.authz.WhoCanWatchTopic(topicId)
+}
@@ -0,0 +1,180 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"chat-system/ws/presence"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// mockTopicChanges reports changes of topics, and fails after each one.
+type mockTopicChanges struct {
+	changes chan string
+	cursors chan string
+}
+
+func (m *mockTopicChanges) WatchTopicChanges(ctx context.Context, cursor *string, changed func(topicId, token string)) error {
+	m.cursors <- *cursor
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case topicId := <-m.changes:
+		changed(topicId, "token-"+topicId)
+		*cursor = topicId
+		return errors.New("stream is broken")
+	}
+}
+
+func TestRoomServer_reconcileRoom(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	authz := &fakeAuthz{userIds: []string{"alice", "bob"}}
+	rooms := NewRoomServer(online, authz)
+
+	aliceConn, bobConn := &recordingConn{}, &recordingConn{}
+	alice := Client{"alice-1", "alice", aliceConn}
+	bob := Client{"bob-1", "bob", bobConn}
+	carol := Client{"carol-1", "carol", &recordingConn{}}
+	for _, c := range []Client{alice, bob, carol} {
+		online.Connect(context.Background(), c)
+	}
+
+	room := rooms.getRoom("topic")
+	if !room.hasClient(alice) || !room.hasClient(bob) || room.hasClient(carol) {
+		t.Fatal("authorized users should join the room")
+	}
+
+	authz.userIds = []string{"alice", "carol"}
+	rooms.reconcileRoom(context.Background(), "topic", "")
+
+	if !room.hasClient(alice) || room.hasClient(bob) || !room.hasClient(carol) {
+		t.Error("clients of the room should follow permissions")
+	}
+
+	if types := bobConn.envelopeTypes(t); len(types) != 1 || types[0] != TopicAccessRevoked {
+		t.Errorf("removed client should be noticed, got %v", types)
+	}
+	if frames := aliceConn.received(); len(frames) != 0 {
+		t.Errorf("authorized clients should receive nothing, got %v", frames)
+	}
+
+	e := Envelope{}
+	json.Unmarshal([]byte(bobConn.received()[0]), &e)
+	if string(e.Payload) != `{"topicId":"topic"}` {
+		t.Errorf("unexpected payload %s", e.Payload)
+	}
+
+	authz.userIds = nil
+	rooms.reconcileRoom(context.Background(), "topic", "")
+	if rooms.hasRoom("topic") {
+		t.Error("the room should be deleted when all clients are removed")
+	}
+}
+
+func TestRoomServer_watchPermissions(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	perms := &fakeAuthz{}
+	rooms := NewRoomServer(online, perms)
+
+	conn := &recordingConn{}
+	cli := Client{"cli", "user", conn}
+	rooms.subscribe(cli, "topic") // mockAuthorizedTopics allows it
+
+	w := &mockTopicChanges{make(chan string), make(chan string, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go rooms.watchPermissions(ctx, w)
+
+	if cursor := <-w.cursors; cursor != "" {
+		t.Errorf("watching should start without cursor, got %q", cursor)
+	}
+	w.changes <- "topic"
+
+	select {
+	case cursor := <-w.cursors:
+		if cursor != "topic" {
+			t.Errorf("watching should be resumed from the last cursor, got %q", cursor)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("watching should be retried")
+	}
+
+	if types := conn.envelopeTypes(t); len(types) != 1 || types[0] != TopicAccessRevoked {
+		t.Errorf("the client should be removed from the room, got %v", types)
+	}
+	if tokens := perms.freshTokens(); !slices.Equal(tokens, []string{"token-topic"}) {
+		t.Errorf("watchers should be looked up as fresh as the change, got tokens %v", tokens)
+	}
+}
+
+// expiringTopicChanges moves the cursor, then fails the first watch since it is expired.
+type expiringTopicChanges struct {
+	cursors chan string
+	expired bool
+}
+
+func (m *expiringTopicChanges) WatchTopicChanges(ctx context.Context, cursor *string, changed func(topicId, token string)) error {
+	m.cursors <- *cursor
+	if !m.expired {
+		m.expired = true
+		*cursor = "old"
+		return fmt.Errorf("%w: too old", authz.ErrCursorExpired)
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRoomServer_watchPermissions_expiredCursor(t *testing.T) {
+	perms := &fakeAuthz{userIds: []string{"user"}}
+	rooms := NewRoomServer(presence.NewMemService[Client](), perms)
+
+	conn := &recordingConn{}
+	rooms.subscribe(Client{"cli", "user", conn}, "topic")
+	perms.userIds = nil // the change is lost with the expired cursor
+
+	w := &expiringTopicChanges{cursors: make(chan string, 2)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rooms.watchPermissions(ctx, w)
+
+	for _, want := range []string{"", ""} {
+		select {
+		case cursor := <-w.cursors:
+			if cursor != want {
+				t.Errorf("expected watching from %q, got %q", want, cursor)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("watching should be restarted without backoff")
+		}
+	}
+
+	waitForFrames(t, conn, 1)
+	if types := conn.envelopeTypes(t); len(types) != 1 || types[0] != TopicAccessRevoked {
+		t.Errorf("rooms should be reconciled after the cursor expires, got %v", types)
+	}
+}
+
+func TestRoomServer_reconcileRoomsEvery(t *testing.T) {
+	perms := &fakeAuthz{userIds: []string{"user"}}
+	rooms := NewRoomServer(presence.NewMemService[Client](), perms)
+
+	conn := &recordingConn{}
+	rooms.subscribe(Client{"cli", "user", conn}, "topic")
+	perms.userIds = []string{"other"} // e.g. the user left a group
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rooms.reconcileRoomsEvery(ctx, 10*time.Millisecond)
+
+	waitForFrames(t, conn, 1)
+	if types := conn.envelopeTypes(t); len(types) != 1 || types[0] != TopicAccessRevoked {
+		t.Errorf("rooms should be reconciled periodically, got %v", types)
+	}
+}
@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// Frames of clients which negotiated [msgpackEncoding] are encoded by
+// github.com/vmihailenco/msgpack. Fields are named by their json tags, so
+// envelopes have the same structure in both encodings. Envelopes of messages
+// and replies are encoded straight from their values, whether they are live,
+// replayed or batched, so times of messages are always timestamps.
+
+var errInvalidMsgpack = errors.New("invalid msgpack")
+
+// msgpackEnvelope is an [Envelope] whose payload is encoded straight
+// to MessagePack, instead of being transcoded from json.
+type msgpackEnvelope struct {
+	ID      string       `json:"id,omitempty"`
+	Type    EnvelopeType `json:"type"`
+	Payload any          `json:"payload,omitempty"`
+}
+
+// returns the value which is encoded to MessagePack for the envelope,
+// or nil if its payload is only known as json.
+func (e *Envelope) msgpackValue() any {
+	if e.payload == nil {
+		return nil
+	}
+	return &msgpackEnvelope{e.ID, e.Type, e.payload}
+}
+
+// marshalMsgpack encodes v to MessagePack. Keys of maps are sorted.
+func marshalMsgpack(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonToMsgpack transcodes a json value to MessagePack.
+// Keys of objects are sorted.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	v, err := jsonNumbers(v)
+	if err != nil {
+		return nil, err
+	}
+	return marshalMsgpack(v)
+}
+
+// replaces json numbers of v by integers, or by floats if they are not integers.
+func jsonNumbers(v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+
+	case []any:
+		for i := range v {
+			var err error
+			if v[i], err = jsonNumbers(v[i]); err != nil {
+				return nil, err
+			}
+		}
+
+	case map[string]any:
+		for k := range v {
+			var err error
+			if v[k], err = jsonNumbers(v[k]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// msgpackToJSON transcodes a MessagePack value to json.
+// Keys of maps must be strings, and binary values are encoded like []byte.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+
+	v, err := decodeMsgpack(msgpack.NewDecoder(r), r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidMsgpack, err)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%w: trailing data", errInvalidMsgpack)
+	}
+	return json.Marshal(v)
+}
+
+// decodes the next value of r like [msgpack.Decoder.DecodeInterface], but lengths
+// of arrays and maps are checked before allocating them, since the decoder
+// allocates arrays of any length which is claimed by clients.
+func decodeMsgpack(d *msgpack.Decoder, r *bytes.Reader) (any, error) {
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		n, err := d.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		if n > r.Len() { // each element has at least one byte
+			return nil, errors.New("array is longer than data")
+		}
+
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = decodeMsgpack(d, r); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+
+	case msgpcode.IsFixedMap(c) || c == msgpcode.Map16 || c == msgpcode.Map32:
+		n, err := d.DecodeMapLen()
+		if err != nil {
+			return nil, err
+		}
+		if n > r.Len() {
+			return nil, errors.New("map is longer than data")
+		}
+
+		m := make(map[string]any, n)
+		for range n {
+			key, err := d.DecodeString()
+			if err != nil {
+				return nil, fmt.Errorf("map keys must be strings: %w", err)
+			}
+			if m[key], err = decodeMsgpack(d, r); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+
+	return d.DecodeInterface()
+}
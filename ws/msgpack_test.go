@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"bytes"
+	"chat-system/core/messages"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestJsonToMsgpack(t *testing.T) {
+	tests := []struct {
+		json string
+		want []byte
+	}{
+		{`null`, []byte{0xc0}},
+		{`true`, []byte{0xc3}},
+		{`1`, []byte{0x01}},
+		{`-1`, []byte{0xff}},
+		{`300`, []byte{0xcd, 0x01, 0x2c}},
+		{`-300`, []byte{0xd1, 0xfe, 0xd4}},
+		{`1.5`, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{`"ab"`, []byte{0xa2, 'a', 'b'}},
+		{`[1,2]`, []byte{0x92, 0x01, 0x02}},
+		{`{"b":1,"a":"x"}`, []byte{0x82, 0xa1, 'a', 0xa1, 'x', 0xa1, 'b', 0x01}},
+	}
+
+	for _, tt := range tests {
+		got, err := jsonToMsgpack([]byte(tt.json))
+		if err != nil {
+			t.Errorf("jsonToMsgpack(%s) returned error: %v", tt.json, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("jsonToMsgpack(%s) = %x, want %x", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 70000)
+	envelope := `{"id":"42","payload":{"big":12345678901,"float":-2.25,"list":[null,false,"` + long + `"],"neg":-200,"seq":7},"type":"send"}`
+
+	data, err := jsonToMsgpack([]byte(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := msgpackToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, have any
+	json.Unmarshal([]byte(envelope), &want)
+	json.Unmarshal(got, &have)
+
+	wantJson, _ := json.Marshal(want)
+	haveJson, _ := json.Marshal(have)
+	if !bytes.Equal(wantJson, haveJson) {
+		t.Errorf("round trip changed the value:\n got %.200s\nwant %.200s", haveJson, wantJson)
+	}
+}
+
+func TestEnvelope_msgpackValue(t *testing.T) {
+	msg := &messages.Message{ID: "msg", TopicID: "topic", SenderId: "alice", Seq: 7, Text: "hi", SentAt: time.Unix(1_700_000_000, 0).UTC()}
+	e, _ := newMessageEnvelope(MessageCreated, msg)
+
+	data := (&encodedFrame{json: []byte("{}"), value: e.msgpackValue()}).bytes(msgpackEncoding)
+
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+
+	var got struct {
+		Type    EnvelopeType     `json:"type"`
+		Payload messages.Message `json:"payload"`
+	}
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	got.Payload.SentAt = got.Payload.SentAt.UTC()
+	if got.Type != MessageCreated || got.Payload != *msg {
+		t.Errorf("the message should be encoded straight to msgpack, got %+v", got)
+	}
+}
+
+func TestMsgpackToJSON_invalid(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0xa5, 'a'},                    // short string
+		{0x81, 0x01, 0x01},             // integer key
+		{0xc1},                         // never used
+		{0x01, 0x02},                   // trailing data
+		{0xdd, 0xff, 0xff, 0xff, 0xff}, // huge array
+		{0xdf, 0xff, 0xff, 0xff, 0xff}, // huge map
+	} {
+		if _, err := msgpackToJSON(data); err == nil {
+			t.Errorf("msgpackToJSON(%x) should return error", data)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Record is a device of a user which is connected to an instance.
+type Record struct {
+	InstanceId string // id of the instance's boot, see [DistributedService]
+	UserId     string
+	ClientId   string
+}
+
+// Store keeps [Record]s of all instances.
+//
+// A record is online until its expiration, then stores must ignore it.
+// Expired records may be refreshed again by a late heartbeat, so stores
+// should delete them only after a long time.
+type Store interface {
+	// Add stores the record which is online until expiresAt.
+	Add(ctx context.Context, rec Record, expiresAt time.Time) error
+
+	// Remove deletes the record and sets last seen time of the user to at.
+	Remove(ctx context.Context, rec Record, at time.Time) error
+
+	// Refresh sets expiration of all records of the instance.
+	Refresh(ctx context.Context, instanceId string, expiresAt time.Time) error
+
+	// OnlineUsers returns at most limit users which have an online record.
+	// If userIds is not empty, only these users are returned.
+	OnlineUsers(ctx context.Context, userIds []string, limit int) ([]string, error)
+
+	// LastSeen returns the last time which users were online,
+	// by removed and expired records. Users which are never seen are not in the result.
+	LastSeen(ctx context.Context, userIds []string) (map[string]time.Time, error)
+
+	// SetViewers replaces numbers of viewers of topics on the instance,
+	// which are counted until expiresAt.
+	SetViewers(ctx context.Context, instanceId string, viewers map[string]int, expiresAt time.Time) error
+
+	// Viewers returns numbers of viewers of the topics on all instances.
+	// Topics without viewers are not in the result.
+	Viewers(ctx context.Context, topicIds []string) (map[string]int, error)
+}
+
+// DistributedService is a cluster-wide [Service].
+//
+// It keeps devices of this instance in a [MemService] and writes their records
+// to a [Store]. Records are refreshed by heartbeats every ttl/3, so devices of
+// a crashed instance become offline after ttl.
+//
+// Records are stored by instanceId with a random suffix of each boot, so heartbeats
+// of a restarted instance do not refresh records of its crashed boot.
+type DistributedService[T Device] struct {
+	*MemService[T]
+	store      Store
+	instanceId string // with the boot suffix
+	ttl        time.Duration
+	now        func() time.Time
+	ctx        context.Context
+	cancel     context.CancelFunc
+}
+
+// NewDistributedService starts heartbeats of the instance.
+// [DistributedService.Close] should be called to stop them.
+func NewDistributedService[T Device](store Store, instanceId string, ttl time.Duration) *DistributedService[T] {
+	s := newDistributedService[T](store, instanceId, ttl, time.Now)
+	go s.runHeartbeats()
+	return s
+}
+
+func newDistributedService[T Device](store Store, instanceId string, ttl time.Duration, now func() time.Time) *DistributedService[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DistributedService[T]{
+		MemService: NewMemService[T](),
+		store:      store,
+		instanceId: fmt.Sprintf("%s/%08x", instanceId, rand.Uint32()),
+		ttl:        ttl,
+		now:        now,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+func (s *DistributedService[T]) record(dev T) Record {
+	return Record{s.instanceId, dev.UserId(), dev.ClientId()}
+}
+
+// Connect adds the device to local devices and stores its record.
+func (s *DistributedService[T]) Connect(ctx context.Context, dev T) error {
+	s.MemService.Connect(ctx, dev)
+	return s.store.Add(ctx, s.record(dev), s.now().Add(s.ttl))
+}
+
+// Disconnected removes the device from local devices and deletes its record.
+func (s *DistributedService[T]) Disconnected(ctx context.Context, dev T) error {
+	s.MemService.Disconnected(ctx, dev)
+	return s.store.Remove(ctx, s.record(dev), s.now())
+}
+
+// IsUserOnline reports whether the user has a device on any instance.
+func (s *DistributedService[T]) IsUserOnline(ctx context.Context, userId string) (bool, error) {
+	if online, _ := s.MemService.IsUserOnline(ctx, userId); online {
+		return true, nil
+	}
+
+	users, err := s.store.OnlineUsers(ctx, []string{userId}, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(users) != 0, nil
+}
+
+// GetOnlineUsers returns online users of all instances.
+func (s *DistributedService[T]) GetOnlineUsers(ctx context.Context, limit int) (iter.Seq[string], error) {
+	users, err := s.store.OnlineUsers(ctx, nil, limit)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Values(users), nil
+}
+
+// RoomViewers stores numbers of viewers of rooms on this instance, by topicId,
+// and returns numbers of their viewers on all instances. Numbers of an instance
+// are counted for ttl, so they should be stored more often.
+func (s *DistributedService[T]) RoomViewers(ctx context.Context, local map[string]int) (map[string]int, error) {
+	if err := s.store.SetViewers(ctx, s.instanceId, local, s.now().Add(s.ttl)); err != nil {
+		return nil, err
+	}
+	return s.store.Viewers(ctx, slices.Collect(maps.Keys(local)))
+}
+
+// refreshes records of the instance.
+func (s *DistributedService[T]) heartbeat(ctx context.Context) error {
+	return s.store.Refresh(ctx, s.instanceId, s.now().Add(s.ttl))
+}
+
+func (s *DistributedService[T]) runHeartbeats() {
+	ticker := time.NewTicker(s.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(s.ctx, s.ttl/3)
+			if err := s.heartbeat(ctx); err != nil {
+				slog.Error("presence heartbeat failed", slog.String("instanceId", s.instanceId), "err", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Close stops heartbeats and expires records and viewers of the instance.
+func (s *DistributedService[T]) Close(ctx context.Context) error {
+	s.cancel()
+	return errors.Join(
+		s.store.Refresh(ctx, s.instanceId, s.now()),
+		s.store.SetViewers(ctx, s.instanceId, nil, s.now()),
+	)
+}
+
+var _ Service[Device] = &DistributedService[Device]{}
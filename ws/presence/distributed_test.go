@@ -0,0 +1,157 @@
+package presence
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestDistributedService(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{time.Now()}
+	ttl := 30 * time.Second
+
+	store := NewMemStore()
+	store.now = clock.now
+
+	node1 := newDistributedService[mockDevice](store, "node1", ttl, clock.now)
+	node2 := newDistributedService[mockDevice](store, "node2", ttl, clock.now)
+
+	dev := mockDevice{"user", "cli"}
+	node1.Connect(ctx, dev)
+
+	isOnline := func(s *DistributedService[mockDevice]) bool {
+		online, err := s.IsUserOnline(ctx, "user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return online
+	}
+
+	if !isOnline(node2) {
+		t.Error("user should be online on other nodes")
+	}
+
+	if len(node2.GetClientsForUserId("user")) != 0 {
+		t.Error("devices of other nodes should not be local devices")
+	}
+
+	users, _ := node2.GetOnlineUsers(ctx, 10)
+	if got := slices.Collect(users); !slices.Equal(got, []string{"user"}) {
+		t.Errorf("expected online users [user], got %v", got)
+	}
+
+	clock.advance(ttl / 2)
+	node1.heartbeat(ctx)
+	clock.advance(ttl / 2)
+
+	if !isOnline(node2) {
+		t.Error("heartbeats should keep the user online")
+	}
+
+	clock.advance(ttl)
+	if isOnline(node2) {
+		t.Error("user should be offline without heartbeats")
+	}
+
+	node1.heartbeat(ctx) // late heartbeat
+	if !isOnline(node2) {
+		t.Error("late heartbeats should make the user online again")
+	}
+
+	clock.advance(time.Second)
+	node1.Disconnected(ctx, dev)
+	if isOnline(node2) || isOnline(node1) {
+		t.Error("user should be offline after disconnecting")
+	}
+
+	lastSeen, _ := store.LastSeen(ctx, []string{"user", "unknown"})
+	if !lastSeen["user"].Equal(clock.now()) {
+		t.Errorf("last seen should be the disconnection time, got %v", lastSeen)
+	}
+
+	if _, found := lastSeen["unknown"]; found {
+		t.Error("never seen users should not have last seen")
+	}
+
+	node1.Connect(ctx, dev)
+	clock.advance(time.Second)
+	node1.Close(ctx)
+	if isOnline(node2) {
+		t.Error("devices of closed nodes should be offline")
+	}
+
+	lastSeen, _ = store.LastSeen(ctx, []string{"user"})
+	if !lastSeen["user"].Equal(clock.now()) {
+		t.Errorf("last seen should be the closing time of the node, got %v", lastSeen)
+	}
+}
+
+func TestDistributedService_restart(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{time.Now()}
+	ttl := 30 * time.Second
+
+	store := NewMemStore()
+	store.now = clock.now
+
+	crashed := newDistributedService[mockDevice](store, "node1", ttl, clock.now)
+	crashed.Connect(ctx, mockDevice{"ghost", "cli-1"})
+
+	restarted := newDistributedService[mockDevice](store, "node1", ttl, clock.now)
+	restarted.Connect(ctx, mockDevice{"user", "cli-2"})
+
+	for range 4 {
+		clock.advance(ttl / 2)
+		restarted.heartbeat(ctx)
+	}
+
+	if online, _ := restarted.IsUserOnline(ctx, "ghost"); online {
+		t.Error("records of the crashed boot should not be refreshed by the restarted instance")
+	}
+	if online, _ := restarted.IsUserOnline(ctx, "user"); !online {
+		t.Error("records of the restarted instance should be refreshed")
+	}
+}
+
+func TestDistributedService_RoomViewers(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{time.Now()}
+	ttl := 30 * time.Second
+
+	store := NewMemStore()
+	store.now = clock.now
+
+	node1 := newDistributedService[mockDevice](store, "node1", ttl, clock.now)
+	node2 := newDistributedService[mockDevice](store, "node2", ttl, clock.now)
+
+	if _, err := node1.RoomViewers(ctx, map[string]int{"live": 3, "quiet": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	viewers, err := node2.RoomViewers(ctx, map[string]int{"live": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(viewers, map[string]int{"live": 5}) {
+		t.Errorf("viewers of rooms on all nodes should be counted, got %v", viewers)
+	}
+
+	clock.advance(ttl)
+	viewers, _ = node2.RoomViewers(ctx, map[string]int{"live": 2})
+	if !maps.Equal(viewers, map[string]int{"live": 2}) {
+		t.Errorf("viewers of nodes should expire after ttl, got %v", viewers)
+	}
+
+	node2.Close(ctx)
+	if viewers, _ := store.Viewers(ctx, []string{"live"}); len(viewers) != 0 {
+		t.Errorf("viewers of closed nodes should not be counted, got %v", viewers)
+	}
+}
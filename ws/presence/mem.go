@@ -62,15 +62,6 @@ func (l *deviceList[T]) deviceExists(dev T) bool {
 	return false
 }
 
-type Person interface {
-	UserId() string
-}
-
-type Device interface {
-	Person
-	ClientId() string // ClientId must be unique for all devices
-}
-
 type MemService[T Device] struct {
 	onlinePersons *sync.Map // map<string, []Device>
 	len           atomic.Int32
@@ -111,9 +102,25 @@ func (s *MemService[T]) Disconnected(_ context.Context, dev T) error {
 	return nil
 }
 
-// func (s MemService) IsUserOnline(_ context.Context, p Person) (bool, error)
+// reports whether the user has any connected device.
+func (s *MemService[T]) IsUserOnline(_ context.Context, userId string) (bool, error) {
+	_, ok := s.onlinePersons.Load(userId)
+	return ok, nil
+}
 
-// func (s MemService) GetOnlineUsers(_ context.Context, limit int) (iter.Seq[Person], error)
+// returns an iterator of at most limit online userIds and nil.
+func (s *MemService[T]) GetOnlineUsers(_ context.Context, limit int) (iter.Seq[string], error) {
+	return func(yield func(string) bool) {
+		n := 0
+		s.onlinePersons.Range(func(key, _ any) bool {
+			if n >= limit {
+				return false
+			}
+			n++
+			return yield(key.(string))
+		})
+	}, nil
+}
 
 // return an iterator of devices and nil.
 func (s *MemService[T]) GetOnlineClients(_ context.Context) (iter.Seq[T], error) {
@@ -165,3 +172,5 @@ func (s *MemService[T]) GetDevicesForUsers(userIds ...string) (res []T) {
 func (s *MemService[T]) Len() int {
 	return int(s.len.Load())
 }
+
+var _ Service[Device] = &MemService[Device]{}
@@ -0,0 +1,154 @@
+package presence
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemStore is an in-process [Store]. It can be shared by
+// [DistributedService]s in tests instead of a real store.
+type MemStore struct {
+	mu       sync.Mutex
+	records  map[Record]time.Time // record -> expiresAt
+	lastSeen map[string]time.Time // userId -> time
+	viewers  map[string]instanceViewers
+	now      func() time.Time
+}
+
+// numbers of viewers of topics on an instance.
+type instanceViewers struct {
+	viewers   map[string]int // topicId -> viewers
+	expiresAt time.Time
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		records:  make(map[Record]time.Time),
+		lastSeen: make(map[string]time.Time),
+		viewers:  make(map[string]instanceViewers),
+		now:      time.Now,
+	}
+}
+
+// Add implements Store.
+func (m *MemStore) Add(_ context.Context, rec Record, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[rec] = expiresAt
+	return nil
+}
+
+// Remove implements Store.
+func (m *MemStore) Remove(_ context.Context, rec Record, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, rec)
+	if at.After(m.lastSeen[rec.UserId]) {
+		m.lastSeen[rec.UserId] = at
+	}
+	return nil
+}
+
+// Refresh implements Store.
+func (m *MemStore) Refresh(_ context.Context, instanceId string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for rec := range m.records {
+		if rec.InstanceId == instanceId {
+			m.records[rec] = expiresAt
+		}
+	}
+	return nil
+}
+
+// OnlineUsers implements Store.
+func (m *MemStore) OnlineUsers(_ context.Context, userIds []string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	users := make([]string, 0)
+
+	for rec, expiresAt := range m.records {
+		if !expiresAt.After(now) {
+			continue
+		}
+
+		if len(userIds) != 0 && !slices.Contains(userIds, rec.UserId) {
+			continue
+		}
+
+		if !slices.Contains(users, rec.UserId) {
+			users = append(users, rec.UserId)
+		}
+	}
+
+	slices.Sort(users)
+	return users[:min(limit, len(users))], nil
+}
+
+// LastSeen implements Store.
+func (m *MemStore) LastSeen(_ context.Context, userIds []string) (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	res := make(map[string]time.Time)
+
+	for _, userId := range userIds {
+		if t, ok := m.lastSeen[userId]; ok {
+			res[userId] = t
+		}
+	}
+
+	for rec, expiresAt := range m.records {
+		if expiresAt.After(now) || !slices.Contains(userIds, rec.UserId) {
+			continue
+		}
+
+		if expiresAt.After(res[rec.UserId]) {
+			res[rec.UserId] = expiresAt
+		}
+	}
+
+	return res, nil
+}
+
+// SetViewers implements Store.
+func (m *MemStore) SetViewers(_ context.Context, instanceId string, viewers map[string]int, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.viewers[instanceId] = instanceViewers{maps.Clone(viewers), expiresAt}
+	return nil
+}
+
+// Viewers implements Store.
+func (m *MemStore) Viewers(_ context.Context, topicIds []string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	res := make(map[string]int)
+
+	for _, inst := range m.viewers {
+		if !inst.expiresAt.After(now) {
+			continue
+		}
+
+		for _, topicId := range topicIds {
+			if n := inst.viewers[topicId]; n != 0 {
+				res[topicId] += n
+			}
+		}
+	}
+	return res, nil
+}
+
+var _ Store = &MemStore{}
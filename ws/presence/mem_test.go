@@ -333,3 +333,31 @@ func BenchmarkGetOnlineClients(b *testing.B) {
 		}
 	})
 }
+
+func TestMemService_onlineUsers(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemService[mockDevice]()
+
+	s.Connect(ctx, mockDevice{"u1", "c1"})
+	s.Connect(ctx, mockDevice{"u1", "c2"})
+	s.Connect(ctx, mockDevice{"u2", "c3"})
+
+	if online, _ := s.IsUserOnline(ctx, "u1"); !online {
+		t.Error("u1 should be online")
+	}
+
+	if online, _ := s.IsUserOnline(ctx, "u3"); online {
+		t.Error("u3 should be offline")
+	}
+
+	users, _ := s.GetOnlineUsers(ctx, 10)
+	got := slices.Sorted(users)
+	if !slices.Equal(got, []string{"u1", "u2"}) {
+		t.Errorf("expected [u1 u2], got %v", got)
+	}
+
+	users, _ = s.GetOnlineUsers(ctx, 1)
+	if n := len(slices.Collect(users)); n != 1 {
+		t.Errorf("expected 1 user by limit, got %d", n)
+	}
+}
@@ -0,0 +1,228 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// expired records are deleted by mongodb after this time.
+const mongoRecordRetention = 24 * time.Hour
+
+type mongoRecord struct {
+	ID         string    `bson:"_id"`
+	InstanceId string    `bson:"instanceId"`
+	UserId     string    `bson:"userId"`
+	ExpiresAt  time.Time `bson:"expiresAt"`
+}
+
+// MongoStore is a [Store] which keeps records in a mongodb collection.
+type MongoStore struct {
+	coll     *mongo.Collection
+	lastSeen *mongo.Collection
+	viewers  *mongo.Collection
+}
+
+// numbers of viewers of topics on an instance.
+type mongoViewers struct {
+	InstanceId string              `bson:"_id"`
+	Viewers    []mongoTopicViewers `bson:"viewers"`
+	ExpiresAt  time.Time           `bson:"expiresAt"`
+}
+
+type mongoTopicViewers struct {
+	TopicId string `bson:"topicId"`
+	N       int    `bson:"n"`
+}
+
+// NewMongoStore creates indexes of the "presence" collection.
+// Last seen times are kept in the "last_seen" collection,
+// and viewers of topics in the "room_viewers" collection.
+func NewMongoStore(ctx context.Context, db *mongo.Database) (*MongoStore, error) {
+	coll := db.Collection("presence")
+	retention := int32(mongoRecordRetention.Seconds())
+
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "instanceId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "expiresAt", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: &options.IndexOptions{ExpireAfterSeconds: &retention},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create indexes of presence collection: %w", err)
+	}
+
+	viewers := db.Collection("room_viewers")
+	expireNow := int32(0)
+	_, err = viewers.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: &options.IndexOptions{ExpireAfterSeconds: &expireNow},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't create indexes of room_viewers collection: %w", err)
+	}
+
+	return &MongoStore{coll, db.Collection("last_seen"), viewers}, nil
+}
+
+func mongoRecordId(rec Record) string {
+	return rec.InstanceId + "/" + rec.ClientId
+}
+
+// Add implements Store.
+func (m *MongoStore) Add(ctx context.Context, rec Record, expiresAt time.Time) error {
+	upsert := true
+	doc := mongoRecord{mongoRecordId(rec), rec.InstanceId, rec.UserId, expiresAt}
+
+	_, err := m.coll.ReplaceOne(ctx, bson.M{"_id": doc.ID}, doc, &options.ReplaceOptions{Upsert: &upsert})
+	return err
+}
+
+// Remove implements Store.
+func (m *MongoStore) Remove(ctx context.Context, rec Record, at time.Time) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": mongoRecordId(rec)})
+	if err != nil {
+		return err
+	}
+
+	upsert := true
+	_, err = m.lastSeen.UpdateByID(ctx, rec.UserId, bson.M{"$max": bson.M{"at": at}},
+		&options.UpdateOptions{Upsert: &upsert})
+	return err
+}
+
+// Refresh implements Store.
+func (m *MongoStore) Refresh(ctx context.Context, instanceId string, expiresAt time.Time) error {
+	_, err := m.coll.UpdateMany(ctx, bson.M{"instanceId": instanceId},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt}})
+	return err
+}
+
+// OnlineUsers implements Store.
+func (m *MongoStore) OnlineUsers(ctx context.Context, userIds []string, limit int) ([]string, error) {
+	match := bson.M{"expiresAt": bson.M{"$gt": time.Now()}}
+	if len(userIds) != 0 {
+		match["userId"] = bson.M{"$in": userIds}
+	}
+
+	cur, err := m.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{"_id": "$userId"}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$limit": limit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	users := make([]string, 0)
+	for cur.Next(ctx) {
+		doc := struct {
+			UserId string `bson:"_id"`
+		}{}
+
+		if err := cur.Decode(&doc); err != nil {
+			slog.Error("can't decode online user", "err", err)
+			continue
+		}
+		users = append(users, doc.UserId)
+	}
+
+	return users, cur.Err()
+}
+
+// LastSeen implements Store.
+func (m *MongoStore) LastSeen(ctx context.Context, userIds []string) (map[string]time.Time, error) {
+	res := make(map[string]time.Time)
+	doc := struct {
+		UserId string    `bson:"_id"`
+		At     time.Time `bson:"at"`
+	}{}
+
+	cur, err := m.lastSeen.Find(ctx, bson.M{"_id": bson.M{"$in": userIds}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	for cur.Next(ctx) {
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		res[doc.UserId] = doc.At
+	}
+
+	// records of crashed instances
+	expired, err := m.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"userId": bson.M{"$in": userIds}, "expiresAt": bson.M{"$lte": time.Now()}}},
+		bson.M{"$group": bson.M{"_id": "$userId", "at": bson.M{"$max": "$expiresAt"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer expired.Close(context.Background())
+
+	for expired.Next(ctx) {
+		if err := expired.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc.At.After(res[doc.UserId]) {
+			res[doc.UserId] = doc.At
+		}
+	}
+
+	return res, nil
+}
+
+// SetViewers implements Store.
+func (m *MongoStore) SetViewers(ctx context.Context, instanceId string, viewers map[string]int, expiresAt time.Time) error {
+	doc := mongoViewers{instanceId, make([]mongoTopicViewers, 0, len(viewers)), expiresAt}
+	for topicId, n := range viewers {
+		doc.Viewers = append(doc.Viewers, mongoTopicViewers{topicId, n})
+	}
+
+	upsert := true
+	_, err := m.viewers.ReplaceOne(ctx, bson.M{"_id": instanceId}, doc, &options.ReplaceOptions{Upsert: &upsert})
+	return err
+}
+
+// Viewers implements Store.
+func (m *MongoStore) Viewers(ctx context.Context, topicIds []string) (map[string]int, error) {
+	cur, err := m.viewers.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"expiresAt": bson.M{"$gt": time.Now()}, "viewers.topicId": bson.M{"$in": topicIds}}},
+		bson.M{"$unwind": "$viewers"},
+		bson.M{"$match": bson.M{"viewers.topicId": bson.M{"$in": topicIds}}},
+		bson.M{"$group": bson.M{"_id": "$viewers.topicId", "n": bson.M{"$sum": "$viewers.n"}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(context.Background())
+
+	res := make(map[string]int)
+	for cur.Next(ctx) {
+		doc := struct {
+			TopicId string `bson:"_id"`
+			N       int    `bson:"n"`
+		}{}
+
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc.N != 0 {
+			res[doc.TopicId] = doc.N
+		}
+	}
+
+	return res, cur.Err()
+}
+
+var _ Store = &MongoStore{}
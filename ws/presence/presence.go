@@ -0,0 +1,33 @@
+package presence
+
+import (
+	"context"
+	"iter"
+)
+
+type Person interface {
+	UserId() string
+}
+
+type Device interface {
+	Person
+	ClientId() string // ClientId must be unique for all devices
+}
+
+// Service keeps track of online users and their devices.
+//
+// Devices are always local to the process, but online users may be
+// cluster-wide, see [DistributedService].
+type Service[T Device] interface {
+	Connect(ctx context.Context, dev T) error
+	Disconnected(ctx context.Context, dev T) error
+
+	// returns devices of the user which are connected to this process.
+	GetClientsForUserId(user string) []T
+
+	// reports whether the user has any connected device.
+	IsUserOnline(ctx context.Context, userId string) (bool, error)
+
+	// returns an iterator of at most limit online userIds.
+	GetOnlineUsers(ctx context.Context, limit int) (iter.Seq[string], error)
+}
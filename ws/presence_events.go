@@ -0,0 +1,155 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	PresenceOnline  EnvelopeType = "presence.online"
+	PresenceOffline EnvelopeType = "presence.offline"
+)
+
+const defaultPresenceDebounce = 3 * time.Second
+
+// payload of [PresenceOnline] and [PresenceOffline] envelopes.
+type presencePayload struct {
+	UserId string    `json:"userId"`
+	At     time.Time `json:"at"` // when the user connected or disconnected
+}
+
+type userPresence struct {
+	timer     *time.Timer
+	gen       int                 // incremented by each change, stale timers are ignored
+	announced bool                // last broadcasted state is online
+	changedAt time.Time           // time of the last connect or disconnect
+	topics    map[string]struct{} // rooms of the user since the last broadcast
+}
+
+// presenceNotifier broadcasts [PresenceOnline] and [PresenceOffline] envelopes
+// to rooms of users.
+//
+// Changes are debounced, so reconnecting devices of a user within the debounce
+// duration does not broadcast anything.
+type presenceNotifier struct {
+	rooms    *roomServer
+	presence clientsPresence
+	debounce time.Duration
+
+	mu    sync.Mutex
+	users map[string]*userPresence
+}
+
+func newPresenceNotifier(rooms *roomServer, presence clientsPresence, debounce time.Duration) *presenceNotifier {
+	return &presenceNotifier{
+		rooms:    rooms,
+		presence: presence,
+		debounce: debounce,
+		users:    make(map[string]*userPresence),
+	}
+}
+
+// should be called after the client joined its rooms.
+func (n *presenceNotifier) onClientConnected(c Client) {
+	n.changed(c)
+}
+
+// should be called before the client leaves its rooms.
+func (n *presenceNotifier) onClientDisconnected(c Client) {
+	n.changed(c)
+}
+
+// remembers rooms of the client and resets the user's debounce timer.
+// Presence of anonymous clients is not announced.
+func (n *presenceNotifier) changed(c Client) {
+	if c.anonymous() {
+		return
+	}
+	userId := c.UserId()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	u, ok := n.users[userId]
+	if !ok {
+		u = &userPresence{topics: make(map[string]struct{})}
+		n.users[userId] = u
+	}
+
+	for _, room := range n.rooms.clientsRooms.cloneValues(c.ClientId()) {
+		u.topics[room.ID] = struct{}{}
+	}
+
+	u.changedAt = time.Now()
+	u.gen++
+	gen := u.gen
+
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	u.timer = time.AfterFunc(n.debounce, func() { n.notify(userId, gen) })
+}
+
+// broadcasts the user's state if it's changed since the last broadcast.
+func (n *presenceNotifier) notify(userId string, gen int) {
+	ctx := context.Background()
+
+	online := len(n.presence.GetClientsForUserId(userId)) != 0
+	if !online {
+		var err error
+		online, err = n.presence.IsUserOnline(ctx, userId) // maybe on other servers
+		if err != nil {
+			slog.Error("can not check user's presence", slog.String("userId", userId), "err", err)
+			return
+		}
+	}
+
+	n.mu.Lock()
+	u, ok := n.users[userId]
+	if !ok || u.gen != gen { // changed again while checking
+		n.mu.Unlock()
+		return
+	}
+
+	for _, c := range n.presence.GetClientsForUserId(userId) {
+		for _, room := range n.rooms.clientsRooms.cloneValues(c.ClientId()) {
+			u.topics[room.ID] = struct{}{}
+		}
+	}
+
+	changed := online != u.announced
+	topics := u.topics
+	at := u.changedAt
+
+	u.announced = online
+	u.topics = make(map[string]struct{})
+	if !online {
+		delete(n.users, userId)
+	}
+	n.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	envType := PresenceOffline
+	if online {
+		envType = PresenceOnline
+	}
+
+	payload, _ := json.Marshal(presencePayload{userId, at})
+	envelope := &Envelope{Type: envType, Payload: payload, coalesceKey: "presence/" + userId}
+
+	for topicId := range topics {
+		n.rooms.RLock()
+		room, found := n.rooms.rooms[topicId]
+		n.rooms.RUnlock()
+
+		if found {
+			room.SendMessage(ctx, envelope)
+		}
+	}
+}
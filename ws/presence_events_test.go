@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"chat-system/ws/presence"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// returns payloads of received presence envelopes.
+func (c *recordingConn) presenceEvents(t *testing.T) (res []EnvelopeType) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, frame := range c.frames {
+		e := Envelope{}
+		if err := json.Unmarshal(frame, &e); err != nil {
+			t.Fatalf("invalid frame %s: %v", frame, err)
+		}
+		if e.Type == PresenceOnline || e.Type == PresenceOffline {
+			res = append(res, e.Type)
+		}
+	}
+	return res
+}
+
+func TestPresenceNotifier(t *testing.T) {
+	ctx := context.Background()
+	debounce := 20 * time.Millisecond
+
+	online := presence.NewMemService[Client]()
+	rooms := NewRoomServer(online, mockAuthorizedTopics{})
+	n := newPresenceNotifier(rooms, online, debounce)
+
+	watcherConn := &recordingConn{}
+	watcher := Client{"watcher-cli", "watcher", watcherConn}
+	rooms.subscribe(watcher, "topic")
+
+	user := Client{"user-cli", "user", &recordingConn{}}
+	connect := func() {
+		online.Connect(ctx, user)
+		rooms.subscribe(user, "topic")
+		n.onClientConnected(user)
+	}
+	disconnect := func() {
+		online.Disconnected(ctx, user)
+		n.onClientDisconnected(user)
+		rooms.onClientDisconnected(user)
+	}
+
+	expectEvents := func(expected ...EnvelopeType) {
+		t.Helper()
+		time.Sleep(3 * debounce)
+
+		got := watcherConn.presenceEvents(t)
+		if len(got) != len(expected) {
+			t.Fatalf("expected events %v, got %v", expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("expected events %v, got %v", expected, got)
+			}
+		}
+	}
+
+	connect()
+	expectEvents(PresenceOnline)
+
+	// reconnecting a device
+	disconnect()
+	connect()
+	expectEvents(PresenceOnline)
+
+	disconnect()
+	expectEvents(PresenceOnline, PresenceOffline)
+
+	// connecting and disconnecting quickly
+	connect()
+	disconnect()
+	expectEvents(PresenceOnline, PresenceOffline)
+}
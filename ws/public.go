@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"chat-system/core/messages"
+	"fmt"
+	"log/slog"
+)
+
+// publicTopicChecker is implemented by authorizers which know public topics.
+//
+// Every user, including anonymous clients, can watch a public topic,
+// so rooms of public topics do not enumerate their watchers.
+type publicTopicChecker interface {
+	IsTopicPublic(topicId string) (bool, error)
+}
+
+// reports whether clients without an authenticated user are connected as [authz.AnonymousUserId].
+// Anonymous clients are read-only: they can only subscribe to public topics.
+func (c Client) anonymous() bool {
+	return c.userId == authz.AnonymousUserId
+}
+
+// isTopicPublic reports whether the topic is public.
+// Topics are not public if the authorizer does not know public topics.
+func (r *roomServer) isTopicPublic(topicId string) (bool, error) {
+	checker, ok := r.authz.(publicTopicChecker)
+	if !ok {
+		return false, nil
+	}
+
+	public, err := checker.IsTopicPublic(topicId)
+	if err != nil {
+		return false, fmt.Errorf("can not check whether the topic is public: %w", err)
+	}
+	return public, nil
+}
+
+// lookupPublic is like [roomServer.isTopicPublic], but errors are logged
+// and the topic is treated as private.
+func (r *roomServer) lookupPublic(topicId string) bool {
+	public, err := r.isTopicPublic(topicId)
+	if err != nil {
+		slog.Warn("topic is treated as private", slog.String("topicId", topicId), "err", err)
+	}
+	return public
+}
+
+// errReadOnly is returned for commands of anonymous clients which change topics.
+func errReadOnly(c Client, topicId string) error {
+	return messages.ErrNotAuthorized{Subject: c.UserId(), ResorceType: "topic", ResorceId: topicId}
+}
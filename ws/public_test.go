@@ -0,0 +1,87 @@
+package ws
+
+import (
+	"chat-system/authz"
+	"chat-system/core/messages"
+	"chat-system/ws/presence"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRoomServer_publicTopic(t *testing.T) {
+	online := presence.NewMemService[Client]()
+	alice := Client{"alice-1", "alice", &recordingConn{}}
+	online.Connect(context.Background(), alice)
+
+	perms := &fakeAuthz{userIds: []string{"alice", "bob"}}
+	perms.setPublic("live", true)
+	rooms := NewRoomServer(online, perms)
+
+	room := rooms.getRoom("live")
+	if !room.public.Load() || !room.IsEmpty() {
+		t.Fatal("room of a public topic should be created empty")
+	}
+
+	if err := rooms.subscribe(alice, "live"); err != nil || !room.hasClient(alice) {
+		t.Fatalf("users should subscribe to public topics, got %v", err)
+	}
+
+	bob := Client{"bob-1", "bob", &recordingConn{}}
+	online.Connect(context.Background(), bob)
+	if err := rooms.onClientConnected(bob); err != nil || !room.hasClient(bob) {
+		t.Fatalf("connected users should join rooms of public topics, got %v", err)
+	}
+
+	anonConn := &recordingConn{}
+	anon := Client{"anon-1", authz.AnonymousUserId, anonConn}
+	if err := rooms.onClientConnected(anon); err != nil || room.hasClient(anon) {
+		t.Fatalf("anonymous clients should not join rooms by connecting, got %v", err)
+	}
+
+	if err := rooms.subscribe(anon, "live"); err != nil {
+		t.Fatalf("anonymous clients should subscribe to public topics, got %v", err)
+	}
+	if err := rooms.subscribe(anon, "private"); !errors.As(err, &messages.ErrNotAuthorized{}) {
+		t.Errorf("anonymous clients should not subscribe to private topics, got %v", err)
+	}
+
+	if err := rooms.reconcileRoom(context.Background(), "live", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !room.hasClient(anon) {
+		t.Error("anonymous clients should be kept while the topic is public")
+	}
+	if n := perms.calls.Load(); n != 0 {
+		t.Errorf("watchers of public topics should not be looked up, got %d lookups", n)
+	}
+
+	perms.setPublic("live", false)
+	if err := rooms.reconcileRoom(context.Background(), "live", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if room.public.Load() || room.hasClient(anon) || !room.hasClient(alice) || !room.hasClient(bob) {
+		t.Error("anonymous clients should be removed when the topic becomes private")
+	}
+	if types := anonConn.envelopeTypes(t); len(types) != 1 || types[0] != TopicAccessRevoked {
+		t.Errorf("removed anonymous client should be noticed, got %v", types)
+	}
+}
+
+func TestCommandHandler_anonymousReadOnly(t *testing.T) {
+	perms := &fakeAuthz{}
+	perms.setPublic("live", true)
+	rooms := NewRoomServer(presence.NewMemService[Client](), perms)
+	h := newCommandHandler(rooms, nil)
+
+	anon := Client{"anon-1", authz.AnonymousUserId, &recordingConn{}}
+	if err := rooms.subscribe(anon, "live"); err != nil {
+		t.Fatal(err)
+	}
+
+	reply := h.handle(context.Background(), anon, &Envelope{ID: "1", Type: CmdTypingStart, Payload: []byte(`{"topicId":"live"}`)})
+	if reply.Type != ReplyError || errorCode(t, reply) != ErrCodeForbidden {
+		t.Errorf("anonymous clients should not type, got %s %s", reply.Type, reply.Payload)
+	}
+}
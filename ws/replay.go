@@ -0,0 +1,169 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"iter"
+	"log/slog"
+	"sync"
+)
+
+const (
+	replayPageSize    = 50
+	maxReplayMessages = 500
+)
+
+type messageLister interface {
+	ListMessages(ctx context.Context, topicID string, p messages.Pagination) ([]messages.Message, error)
+}
+
+type replayResult struct {
+	Replayed int `json:"replayed"`
+	// false if the client missed more than [maxReplayMessages] messages,
+	// then it should fetch the rest of them by REST API.
+	Complete bool `json:"complete"`
+}
+
+// replayBuffer holds live envelopes of a client
+// while its missed messages are being replayed.
+type replayBuffer struct {
+	mu        sync.Mutex
+	envelopes []*Envelope
+	frames    []*encodedFrame
+	done      bool
+}
+
+// buffer appends the envelope and returns true if the replay is not finished yet.
+func (b *replayBuffer) buffer(e *Envelope, frame *encodedFrame) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.done {
+		return false
+	}
+
+	b.envelopes = append(b.envelopes, e)
+	b.frames = append(b.frames, frame)
+	return true
+}
+
+// startReplay buffers next envelopes of the room for the [Client] c
+// until [room.finishReplay] is called.
+func (r *room) startReplay(c Client) *replayBuffer {
+	if r.batch != nil {
+		r.batch.flushPending() // batches are not buffered for replaying clients
+	}
+
+	b := &replayBuffer{}
+	r.replays.Store(c.ClientId(), b)
+	r.replaying.Add(1)
+	return b
+}
+
+// finishReplay writes buffered envelopes to the client, except created messages
+// which are already replayed, then it stops buffering.
+//
+// The buffer is locked while flushing, so live envelopes can not overtake buffered ones.
+func (r *room) finishReplay(c Client, b *replayBuffer, replayed map[string]struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, e := range b.envelopes {
+		if _, dup := replayed[e.msgId]; dup && e.Type == MessageCreated {
+			continue
+		}
+		writeToClient(c, b.frames[i])
+	}
+
+	b.done = true
+	b.envelopes, b.frames = nil, nil
+
+	r.replays.CompareAndDelete(c.ClientId(), b)
+	r.replaying.Add(-1)
+}
+
+// skips clients which are replaying missed messages and buffers the envelope for them.
+func (r *room) bufferForReplaying(clients iter.Seq[Client], e *Envelope, frame *encodedFrame) iter.Seq[Client] {
+	return func(yield func(Client) bool) {
+		for c := range clients {
+			if b, ok := r.replays.Load(c.ClientId()); ok && b.(*replayBuffer).buffer(e, frame) {
+				continue
+			}
+
+			if !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// subscribeAfter subscribes the [Client] c to the topic like [roomServer.subscribe],
+// and writes messages which are sent after the message afterId to the client
+// before live messages of the room.
+func (r *roomServer) subscribeAfter(ctx context.Context, c Client, topicId, afterId string, history messageLister) (replayResult, error) {
+	if err := r.checkCanWatch(c, topicId); err != nil {
+		return replayResult{}, err
+	}
+
+	var room *room
+	var buffer *replayBuffer
+	for {
+		room = r.getRoom(topicId)
+		buffer = room.startReplay(c)
+		if room.hasClient(c) || r.joinClientToRooms(c, room) {
+			break
+		}
+		room.finishReplay(c, buffer, nil) // the room is deleted meanwhile
+	}
+
+	replayed := make(map[string]struct{})
+	defer func() {
+		room.finishReplay(c, buffer, replayed)
+	}()
+
+	res := replayResult{}
+	for res.Replayed < maxReplayMessages {
+		page, err := history.ListMessages(ctx, topicId, messages.Pagination{AfterID: afterId, Limit: replayPageSize})
+		if err != nil {
+			return res, err
+		}
+
+		for i := range page {
+			envelope, err := newMessageEnvelope(MessageCreated, &page[i])
+			if err != nil {
+				slog.ErrorContext(ctx, "can not create envelope", "messageId", page[i].ID, "err", err)
+				continue
+			}
+
+			data, _ := json.Marshal(envelope)
+			writeToClient(c, &encodedFrame{json: data, value: envelope.msgpackValue()})
+			replayed[page[i].ID] = struct{}{}
+		}
+
+		res.Replayed += len(page)
+		if len(page) < replayPageSize {
+			res.Complete = true
+			break
+		}
+		afterId = page[len(page)-1].ID
+	}
+
+	return res, nil
+}
+
+// writes the frame to the client's conn, like live frames of rooms.
+func writeToClient(c Client, frame *encodedFrame) {
+	conn := c.Conn()
+	if conn == nil {
+		slog.Error("client's connection is nil", slog.String("clientId", c.ClientId()))
+		return
+	}
+
+	if err := writeFrame(conn, frame, ""); err != nil {
+		slog.Error("can not write to client's connection",
+			slog.String("userId", c.UserId()),
+			slog.String("clientId", c.ClientId()),
+			"err", err)
+	}
+}
@@ -0,0 +1,224 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingConn records all written frames.
+type recordingConn struct {
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (c *recordingConn) Write(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, b)
+	return nil
+}
+
+// returns message IDs of received envelopes with type t.
+func (c *recordingConn) messageIds(t *testing.T, typ EnvelopeType) []string {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := []string{}
+	for _, frame := range c.frames {
+		e := Envelope{}
+		if err := json.Unmarshal(frame, &e); err != nil {
+			t.Fatalf("invalid frame %s: %v", frame, err)
+		}
+		if e.Type != typ {
+			continue
+		}
+
+		msg := messages.Message{}
+		json.Unmarshal(e.Payload, &msg)
+		ids = append(ids, msg.ID)
+	}
+	return ids
+}
+
+// mockHistory lists messages of a topic. onList is called before returning the first page.
+type mockHistory struct {
+	msgs   []messages.Message
+	onList func()
+	once   sync.Once
+}
+
+func newMockHistory(topicId string, n int) *mockHistory {
+	h := &mockHistory{}
+	for i := range n {
+		h.msgs = append(h.msgs, messages.Message{ID: fmt.Sprintf("%04d", i), TopicID: topicId, Text: "text"})
+	}
+	return h
+}
+
+// ListMessages implements messageLister.
+func (h *mockHistory) ListMessages(ctx context.Context, topicID string, p messages.Pagination) ([]messages.Message, error) {
+	if h.onList != nil {
+		h.once.Do(h.onList)
+	}
+
+	i := slices.IndexFunc(h.msgs, func(m messages.Message) bool { return m.ID > p.AfterID })
+	if i < 0 {
+		return nil, nil
+	}
+	return h.msgs[i:min(i+p.Limit, len(h.msgs))], nil
+}
+
+var _ messageLister = &mockHistory{}
+
+func TestRoomServer_subscribeAfter(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	conn := &recordingConn{}
+	cli := Client{"client", "user", conn}
+
+	history := newMockHistory("topic", 3)
+	history.onList = func() { // live messages while replaying
+		room := rooms.getRoom("topic")
+		for _, id := range []string{"0002", "0003"} {
+			e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: id, TopicID: "topic"})
+			room.SendMessage(context.Background(), e)
+		}
+
+		if len(conn.frames) != 0 {
+			t.Error("live messages should be buffered while replaying")
+		}
+	}
+
+	res, err := rooms.subscribeAfter(context.Background(), cli, "topic", "0000", history)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Replayed != 2 || !res.Complete {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// "0002" is received once, and "0003" after replayed messages.
+	expected := []string{"0001", "0002", "0003"}
+	if ids := conn.messageIds(t, MessageCreated); !slices.Equal(ids, expected) {
+		t.Errorf("expected messages %v, got %v", expected, ids)
+	}
+
+	if !rooms.getRoom("topic").hasClient(cli) {
+		t.Error("client should be joined to the room")
+	}
+
+	e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: "0004", TopicID: "topic"})
+	rooms.SendMessageTo(context.Background(), "topic", e)
+
+	if ids := conn.messageIds(t, MessageCreated); ids[len(ids)-1] != "0004" {
+		t.Errorf("live messages should be sent after replaying, got %v", ids)
+	}
+}
+
+func TestRoomServer_subscribeAfter_paging(t *testing.T) {
+	tests := []struct {
+		name         string
+		n            int
+		wantReplayed int
+		wantComplete bool
+	}{
+		{"one-page", replayPageSize - 1, replayPageSize - 2, true},
+		{"many-pages", 2*replayPageSize + 10, 2*replayPageSize + 9, true},
+		{"more-than-max", maxReplayMessages + 10, maxReplayMessages, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+			conn := &recordingConn{}
+
+			res, err := rooms.subscribeAfter(context.Background(), Client{"client", "user", conn}, "topic", "0000", newMockHistory("topic", tt.n))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.Replayed != tt.wantReplayed || res.Complete != tt.wantComplete {
+				t.Errorf("expected %d messages and complete=%v, got %+v", tt.wantReplayed, tt.wantComplete, res)
+			}
+
+			if n := len(conn.messageIds(t, MessageCreated)); n != tt.wantReplayed {
+				t.Errorf("expected %d received messages, got %d", tt.wantReplayed, n)
+			}
+		})
+	}
+}
+
+func TestRoomServer_subscribeAfter_msgpack(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	conn := &msgpackConn{}
+	cli := Client{"client", "user", conn}
+
+	sentAt := time.Unix(1_700_000_000, 0).UTC()
+	history := newMockHistory("topic", 2)
+	for i := range history.msgs {
+		history.msgs[i].SentAt = sentAt
+	}
+
+	buffered := &messages.Message{ID: "0002", TopicID: "topic", Text: "text", SentAt: sentAt}
+	history.onList = func() {
+		e, _ := newMessageEnvelope(MessageCreated, buffered)
+		rooms.getRoom("topic").SendMessage(context.Background(), e)
+	}
+
+	if _, err := rooms.subscribeAfter(context.Background(), cli, "topic", "", history); err != nil {
+		t.Fatal(err)
+	}
+
+	live, _ := newMessageEnvelope(MessageCreated, &history.msgs[1])
+	rooms.SendMessageTo(context.Background(), "topic", live)
+
+	frames := conn.received()
+	if len(frames) != 4 {
+		t.Fatalf("expected 2 replayed, 1 buffered and 1 live frames, got %d", len(frames))
+	}
+
+	if replayed := frames[1]; replayed != frames[3] {
+		t.Errorf("replayed frame should be encoded like the live frame:\n got %x\nwant %x", replayed, frames[3])
+	}
+
+	e, _ := newMessageEnvelope(MessageCreated, buffered)
+	want, _ := marshalMsgpack(e.msgpackValue())
+	if frames[2] != string(want) {
+		t.Errorf("buffered frame should be encoded like live frames:\n got %x\nwant %x", frames[2], want)
+	}
+}
+
+func TestCommandHandler_resume(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	h := newCommandHandler(rooms, mockMessageService{})
+	cli := Client{"client", "user", &recordingConn{}}
+
+	payload := json.RawMessage(`{"topics":{"topic1":"msg-id","topic2":""}}`)
+	reply := h.handle(context.Background(), cli, &Envelope{ID: "1", Type: CmdResume, Payload: payload})
+
+	if reply.Type != ReplyAck {
+		t.Fatalf("resume should be acked, got %s %s", reply.Type, reply.Payload)
+	}
+
+	results := map[string]resumeResult{}
+	json.Unmarshal(reply.Payload, &results)
+
+	if r := results["topic1"]; r.Error != "" || !r.Complete {
+		t.Errorf("topic1 should be resumed, got %+v", r)
+	}
+
+	if r := results["topic2"]; r.Error != ErrCodeBadRequest {
+		t.Errorf("topic without afterId should fail, got %+v", r)
+	}
+
+	if !rooms.getRoom("topic1").hasClient(cli) {
+		t.Error("client should be joined to the resumed topic")
+	}
+}
@@ -10,14 +10,11 @@ import (
 	"log/slog"
 	"slices"
 	"sync"
+	"sync/atomic"
 
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
-// a sharded goroutine worker pool for writing to users' [Conn]
-// to reduce all generated goroutine size.
-// var workerIns shardedWriter
-
 type whoCanReadTopic interface {
 	WhoCanWatchTopic(topicId string) ([]string, error)
 
@@ -31,9 +28,25 @@ type devicesGetter[T presence.Device] interface {
 	GetDevicesForUsers(userIds ...string) []T
 }
 
-func init() {
-	// workerIns = newShardedWriter(min(2, uint32(runtime.NumCPU())))
-	// go workerIns.run()
+// SubscriptionMode specifies how clients join rooms.
+type SubscriptionMode string
+
+const (
+	// Clients join every authorized room when they connect,
+	// and new rooms include all online authorized users.
+	AutoSubscription SubscriptionMode = "auto"
+
+	// Clients join only rooms which they subscribed to by "subscribe" command.
+	ExplicitSubscription SubscriptionMode = "explicit"
+)
+
+// ParseSubscriptionMode returns [SubscriptionMode] for "auto" or "explicit".
+func ParseSubscriptionMode(s string) (SubscriptionMode, error) {
+	switch m := SubscriptionMode(s); m {
+	case AutoSubscription, ExplicitSubscription:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid subscription mode %q", s)
 }
 
 // roomServer manages all rooms
@@ -42,23 +55,48 @@ type roomServer struct {
 	authz         whoCanReadTopic
 	rooms         map[string]*room
 	clientsRooms  *mapList[string, *room] // clientId -> []*room. rooms which the user connected to.
+	mode          SubscriptionMode        // empty means [AutoSubscription]
+	batching      batchConf
+	sampling      samplingConf
+	creating      singleflight.Group       // topicId -> in-flight room creation
+	degraded      map[string]*degradedRoom // topicId -> room whose members are not loaded
 	sync.RWMutex
 }
 
 func NewRoomServer(b devicesGetter[Client], authz whoCanReadTopic) *roomServer {
 	server := roomServer{
 		b, authz, make(map[string]*room), &mapList[string, *room]{},
-		sync.RWMutex{},
+		AutoSubscription, batchConf{}, samplingConf{},
+		singleflight.Group{}, make(map[string]*degradedRoom), sync.RWMutex{},
 	}
 	return &server
 }
 
+func (r *roomServer) explicitMode() bool {
+	return r.mode == ExplicitSubscription
+}
+
 // Adds client to room and remembers rooms which the client connected to.
-func (r *roomServer) joinClientToRooms(c Client, rooms ...*room) {
+// Rooms which are deleted meanwhile are not joined, since nothing is sent to them.
+// It reports whether all rooms are joined.
+//
+// r is read locked while joining, so [roomServer.deleteIfEmpty] can not delete
+// a room which a client is joining.
+func (r *roomServer) joinClientToRooms(c Client, rooms ...*room) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	joined := true
 	for _, room := range rooms {
+		if r.rooms[room.ID] != room {
+			joined = false
+			continue
+		}
+
 		r.clientsRooms.insert(c.ClientId(), room)
 		room.addClient(c)
 	}
+	return joined
 }
 
 // Removes client from room and remove the room from user's websocket rooms.
@@ -72,11 +110,20 @@ func (r *roomServer) leaveClientFromRoom(c Client, rooms ...*room) {
 // onClientConnected gets authorzided topics
 // by calling [whoCanReadTopic]'s function and
 // adds the [Client] c to authorized rooms.
+//
+// In [ExplicitSubscription] mode, or for anonymous clients, it does nothing.
+// Anonymous clients join rooms of public topics only by subscribing.
 func (r *roomServer) onClientConnected(c Client) error {
+	if r.explicitMode() || c.anonymous() {
+		return nil
+	}
+
+	r.RLock()
 	serverTopics := make([]string, 0, len(r.rooms))
 	for roomId := range r.rooms {
 		serverTopics = append(serverTopics, roomId)
 	}
+	r.RUnlock()
 
 	topics, err := r.authz.TopicsWhichUserCanWatch(c.UserId(), serverTopics)
 	if err != nil {
@@ -85,7 +132,9 @@ func (r *roomServer) onClientConnected(c Client) error {
 
 	rooms := make([]*room, 0, 1)
 	for _, topic := range topics {
-		rooms = append(rooms, r.rooms[topic])
+		if room := r.existingRoom(topic); room != nil {
+			rooms = append(rooms, room)
+		}
 	}
 
 	r.joinClientToRooms(c, rooms...)
@@ -98,13 +147,8 @@ func (r *roomServer) onClientDisconnected(c Client) error {
 	rooms := r.clientsRooms.cloneValues(c.ClientId())
 	r.leaveClientFromRoom(c, rooms...)
 
-	r.Lock()
-	defer r.Unlock()
-
 	for _, room := range rooms {
-		if room.IsEmpty() {
-			delete(r.rooms, room.ID)
-		}
+		r.deleteIfEmpty(room)
 	}
 	return nil
 }
@@ -122,13 +166,39 @@ func (r *roomServer) getRoom(topicID string) *room {
 	return roomIns
 }
 
-// SendMessageTo sends [messages.Message] msg to the room with specified topicId.
+// reports whether the room of topicId exists.
+func (r *roomServer) hasRoom(topicId string) bool {
+	return r.existingRoom(topicId) != nil
+}
+
+// returns the room of topicId, or nil if it does not exist.
+func (r *roomServer) existingRoom(topicId string) *room {
+	r.RLock()
+	defer r.RUnlock()
+
+	return r.rooms[topicId]
+}
+
+// SendMessageTo sends [Envelope] e to the room with specified topicId.
 //
 // it gets existing [room] or creates new room, and calls room's SendMessage func.
-func (r *roomServer) SendMessageTo(ctx context.Context, topicId string, msg *messages.Message) {
+// In [ExplicitSubscription] mode, rooms are created only by subscribers,
+// so the message is dropped if nobody subscribed to the topic.
+func (r *roomServer) SendMessageTo(ctx context.Context, topicId string, e *Envelope) {
+	if r.explicitMode() {
+		r.RLock()
+		room, found := r.rooms[topicId]
+		r.RUnlock()
+
+		if found {
+			room.SendMessage(ctx, e)
+		}
+		return
+	}
+
 	room := r.getRoom(topicId)
 
-	room.SendMessage(ctx, msg)
+	room.SendMessage(ctx, e)
 }
 
 // createRoom creates new [room] with online authorzed users.
@@ -136,29 +206,159 @@ func (r *roomServer) SendMessageTo(ctx context.Context, topicId string, msg *mes
 //  1. it gets all authrized users by calling [whoCanReadTopic]'s WhoCanWatchTopic
 //  2. filter online users
 //  3. adds all online authrized users to the room.
+//
+// Concurrent creators of the same topic share one lookup, which is retried with backoff.
+// If it still fails, the room is created degraded and its members are loaded
+// later by [roomServer.refreshDegradedRooms].
+//
+// Watchers of public topics are not looked up, since everyone can watch them,
+// so their rooms are created empty. Users join them by connecting or subscribing,
+// and anonymous clients join them by subscribing.
+//
+// In [ExplicitSubscription] mode it creates an empty room.
 func (r *roomServer) createRoom(topicId string) *room {
-	r.Lock()
-	defer r.Unlock()
-
-	room, ok := r.rooms[topicId]
-	if ok {
+	if r.explicitMode() {
+		r.Lock()
+		defer r.Unlock()
+
+		room, ok := r.rooms[topicId]
+		if !ok {
+			room = newRoom(topicId, nil)
+			r.setupRoom(room)
+		}
 		return room
 	}
 
-	userIds, err := r.authz.WhoCanWatchTopic(topicId)
-	if err != nil {
-		slog.Error("error in calling WhoCanWatchTopic", slog.String("topicId", topicId), "err", err)
-	}
+	v, _, _ := r.creating.Do(topicId, func() (any, error) {
+		if room := r.existingRoom(topicId); room != nil {
+			return room, nil
+		}
+
+		public := r.lookupPublic(topicId)
 
+		var userIds []string
+		var err error
+		if !public {
+			userIds, err = r.lookupWatchers(topicId)
+			if err != nil {
+				slog.Error("error in calling WhoCanWatchTopic, the room is degraded", slog.String("topicId", topicId), "err", err)
+			}
+		}
+
+		r.Lock()
+		defer r.Unlock()
+
+		if room, ok := r.rooms[topicId]; ok {
+			return room, nil
+		}
+
+		room := r.newAuthorizedRoom(topicId, userIds)
+		room.public.Store(public)
+		if err != nil {
+			r.markDegraded(room)
+		}
+		return room, nil
+	})
+	return v.(*room)
+}
+
+// creates the room with online clients of users. r must be locked.
+func (r *roomServer) newAuthorizedRoom(topicId string, userIds []string) *room {
 	userConnections := r.onlinePersons.GetDevicesForUsers(userIds...)
 	slog.Debug("roomServer.createRoom", "topicId", topicId, "userConns", len(userConnections))
 
-	room = newRoom(topicId, userConnections)
-	r.rooms[topicId] = room
+	room := newRoom(topicId, userConnections)
+	r.setupRoom(room)
+
+	for _, c := range userConnections {
+		r.clientsRooms.insert(c.ClientId(), room)
+	}
 
 	return room
 }
 
+// adds the new room and enables its batching and sampling. r must be locked.
+func (r *roomServer) setupRoom(room *room) {
+	if r.batching.window > 0 {
+		room.enableBatching(r.batching)
+	}
+	if r.sampling.rate > 0 {
+		moderators, _ := r.authz.(whoCanModerateTopic)
+		room.enableSampling(r.sampling, moderators)
+	}
+	r.rooms[room.ID] = room
+}
+
+// subscribe joins the [Client] c to the room of topicId
+// if the user can watch the topic. It returns [messages.ErrNotAuthorized] otherwise.
+//
+// Subscribing to a joined room does nothing. If the room is deleted before
+// the client joins it, the client joins the next room of the topic.
+func (r *roomServer) subscribe(c Client, topicId string) error {
+	if err := r.checkCanWatch(c, topicId); err != nil {
+		return err
+	}
+
+	for {
+		room := r.getRoom(topicId)
+		if room.hasClient(c) || r.joinClientToRooms(c, room) {
+			return nil
+		}
+	}
+}
+
+// returns [messages.ErrNotAuthorized] if the user of [Client] c can not watch the topic.
+// Everyone can watch public topics, and anonymous clients can watch only them.
+func (r *roomServer) checkCanWatch(c Client, topicId string) error {
+	public, err := r.isTopicPublic(topicId)
+	if err != nil {
+		return err
+	}
+
+	if public {
+		return nil
+	}
+	if c.anonymous() {
+		return messages.ErrNotAuthorized{Subject: c.UserId(), ResorceType: "topic", ResorceId: topicId}
+	}
+
+	topics, err := r.authz.TopicsWhichUserCanWatch(c.UserId(), []string{topicId})
+	if err != nil {
+		return fmt.Errorf("can not check topics which the user can read: %w", err)
+	}
+
+	if !slices.Contains(topics, topicId) {
+		return messages.ErrNotAuthorized{Subject: c.UserId(), ResorceType: "topic", ResorceId: topicId}
+	}
+	return nil
+}
+
+// unsubscribe removes the [Client] c from the room of topicId
+// and deletes the room if it becomes empty.
+func (r *roomServer) unsubscribe(c Client, topicId string) {
+	r.RLock()
+	room, found := r.rooms[topicId]
+	r.RUnlock()
+
+	if !found || !room.hasClient(c) {
+		return
+	}
+
+	r.leaveClientFromRoom(c, room)
+	r.deleteIfEmpty(room)
+}
+
+// deletes the room if it has no clients. Clients join rooms while r is
+// read locked, so they do not join deleted rooms.
+func (r *roomServer) deleteIfEmpty(room *room) {
+	r.Lock()
+	defer r.Unlock()
+
+	if room.IsEmpty() && r.rooms[room.ID] == room {
+		delete(r.rooms, room.ID)
+	}
+}
+
 var _ presence.Device = Client{}
 
 // --------------
@@ -167,6 +367,14 @@ var _ presence.Device = Client{}
 type room struct {
 	ID            string
 	onlinePersons *presence.MemService[Client]
+	replays       sync.Map     // clientId -> *replayBuffer
+	replaying     atomic.Int32 // number of clients which are replaying missed messages
+	degraded      atomic.Bool  // members are not loaded since the authorizer failed
+	public        atomic.Bool  // the topic is public, see [publicTopicChecker]
+	batch         *roomBatch   // nil if batching is disabled
+	sampler       *roomSampler // nil if sampling is disabled
+	lastViewers   atomic.Int32 // last broadcasted number of viewers
+	allViewers    atomic.Int32 // viewers on all instances, zero if not counted, see [roomViewersCounter]
 }
 
 func newRoom(id string, connections []Client) *room {
@@ -178,7 +386,7 @@ func newRoom(id string, connections []Client) *room {
 		persons.Connect(ctx, c)
 	}
 
-	return &room{id, persons}
+	return &room{ID: id, onlinePersons: persons}
 }
 
 // adds the [Client] c to online users of the [room] r.
@@ -193,55 +401,63 @@ func (r *room) removeClient(c Client) {
 	r.onlinePersons.Disconnected(context.Background(), c)
 }
 
+// reports whether the [Client] c is an online user of the [room] r.
+func (r *room) hasClient(c Client) bool {
+	return slices.ContainsFunc(r.onlinePersons.GetClientsForUserId(c.UserId()), func(dev Client) bool {
+		return dev.ClientId() == c.ClientId()
+	})
+}
+
 func (r *room) IsEmpty() bool {
 	return r.onlinePersons.IsEmpty()
 }
 
-// Sends [*messages.Message] to online users of the [room] r.
+// Sends [*Envelope] to online users of the [room] r.
 //
-// SendMessage encodes the messages to json and send the encoded messages
+// SendMessage encodes the envelope to json and send the encoded envelope
 // to all client's [Conn].
-func (r *room) SendMessage(ctx context.Context, m *messages.Message) { // maybe message will be inconsistence with DB
-	data, _ := json.Marshal(m)
+func (r *room) SendMessage(ctx context.Context, e *Envelope) { // maybe message will be inconsistence with DB
+	data, _ := json.Marshal(e)
+	frame := &encodedFrame{json: data, value: e.msgpackValue()}
 	clients, _ := r.onlinePersons.GetOnlineClients(ctx)
 
-	err := r.clientsFanOut(ctx, data, clients)
-	if err != nil {
-		slog.ErrorContext(ctx, "can not fan out to clients", "err", "err")
+	sampledOut := r.sampler != nil && !r.sampler.deliverToAll(e, r.viewers())
+	if sampledOut {
+		clients = clientsOfUser(clients, e.senderId)
 	}
-}
-
-func (*room) clientsFanOut(ctx context.Context, data []byte, clients iter.Seq[Client]) error {
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(100)
 
-	for client := range clients {
-		client := client
-		g.Go(func() error {
-			conn := client.Conn()
-			if conn == nil {
-				slog.Error("client's connection is nil", slog.String("userId", client.UserId()),
-					slog.String("clientId", client.ClientId()))
-				return nil
-			}
+	if r.replaying.Load() > 0 {
+		if r.batch != nil {
+			r.batch.flushPending() // batches are not buffered for replaying clients
+		}
+		clients = r.bufferForReplaying(clients, e, frame)
+	} else if r.batch != nil && !sampledOut {
+		clients = r.gatherForBatching(clients, e, frame)
+	}
 
-			if err := conn.Write(data); err != nil {
-				// never here
-				slog.Error("can not write to client's connection",
-					slog.String("userId", client.UserId()),
-					slog.String("clientId", client.ClientId()),
-					"err", err)
-			}
+	r.clientsFanOut(ctx, e.coalesceKey, frame, clients)
+}
 
-			return nil
-		})
-	}
+// writes the frame to clients. Clients' conns are [sendQueue]s, so slow clients
+// do not block the fan-out. The frame is encoded once for each negotiated encoding.
+// If key is not empty, pending frames with the same key may be replaced.
+func (*room) clientsFanOut(ctx context.Context, key string, frame *encodedFrame, clients iter.Seq[Client]) {
+	for client := range clients {
+		conn := client.Conn()
+		if conn == nil {
+			slog.ErrorContext(ctx, "client's connection is nil", slog.String("userId", client.UserId()),
+				slog.String("clientId", client.ClientId()))
+			continue
+		}
 
-	if err := g.Wait(); err != nil {
-		return err
+		if err := writeFrame(conn, frame, key); err != nil {
+			// never here
+			slog.ErrorContext(ctx, "can not write to client's connection",
+				slog.String("userId", client.UserId()),
+				slog.String("clientId", client.ClientId()),
+				"err", err)
+		}
 	}
-
-	return nil
 }
 
 // simple safe listContainer
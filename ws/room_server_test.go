@@ -1,9 +1,12 @@
 package ws
 
 import (
+	"chat-system/core/messages"
 	"cmp"
+	"context"
 	"fmt"
 	"slices"
+	"sync"
 	"testing"
 )
 
@@ -185,3 +188,98 @@ func areRoomSlicesSame(t *testing.T, rooms1, rooms2 []*room) bool {
 	}
 	return true
 }
+
+func TestRoomServer_explicitSubscription(t *testing.T) {
+	cli := Client{"client", "user", &mockConn{}}
+	authz := &fakeAuthz{}
+	r := NewRoomServer(mockDeviceGetter{}, authz)
+	r.mode = ExplicitSubscription
+
+	existing := newRoom("room1", nil)
+	r.rooms[existing.ID] = existing
+
+	if err := r.onClientConnected(cli); err != nil {
+		t.Fatalf("onClientConnected returns error: %v", err)
+	}
+
+	if roomContainsClient(existing, cli) {
+		t.Error("client should not join rooms without subscribing")
+	}
+
+	envelope, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: "msg", TopicID: "not-subscribed"})
+	r.SendMessageTo(context.Background(), "not-subscribed", envelope)
+
+	if _, found := r.rooms["not-subscribed"]; found {
+		t.Error("rooms without subscribers should not be created")
+	}
+
+	if err := r.subscribe(cli, "topic"); err != nil {
+		t.Fatalf("subscribe returns error: %v", err)
+	}
+
+	if !roomContainsClient(r.rooms["topic"], cli) {
+		t.Error("subscribed client should join the room")
+	}
+
+	if n := authz.calls.Load(); n != 0 {
+		t.Errorf("explicit mode should not look up all watchers of topics, calls=%d", n)
+	}
+}
+
+func TestRoomServer_joinDeletedRoom(t *testing.T) {
+	r := NewRoomServer(mockDeviceGetter{}, &TestAuthz{})
+
+	room := r.getRoom("roomId")
+	r.deleteIfEmpty(room)
+	cli := Client{"cID", "uID", nil}
+
+	if r.joinClientToRooms(cli, room) {
+		t.Error("deleted rooms should not be joined")
+	}
+	if roomContainsClient(room, cli) || len(r.clientsRooms.cloneValues(cli.ClientId())) != 0 {
+		t.Error("client should not be added to the deleted room")
+	}
+}
+
+func TestRoomServer_subscribeWhileDeleting(t *testing.T) {
+	r := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	r.mode = ExplicitSubscription
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			cli := Client{fmt.Sprintf("client-%d", i), "user", &mockConn{}}
+			for range 200 {
+				if err := r.subscribe(cli, "topic"); err != nil {
+					t.Errorf("subscribe returns error: %v", err)
+					return
+				}
+				if room := r.existingRoom("topic"); room == nil || !room.hasClient(cli) {
+					t.Error("subscribed client should be in the room of the topic")
+					return
+				}
+				r.unsubscribe(cli, "topic")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if r.hasRoom("topic") {
+		t.Error("the room should be deleted after all clients unsubscribe")
+	}
+}
+
+func TestParseSubscriptionMode(t *testing.T) {
+	for _, s := range []string{"auto", "explicit"} {
+		if m, err := ParseSubscriptionMode(s); err != nil || string(m) != s {
+			t.Errorf("ParseSubscriptionMode(%s) = %s, %v", s, m, err)
+		}
+	}
+
+	if _, err := ParseSubscriptionMode("manual"); err == nil {
+		t.Error("it should return error for invalid modes")
+	}
+}
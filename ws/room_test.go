@@ -109,10 +109,11 @@ func Test_SendMessage_writingToConn(t *testing.T) {
 	cli := Client{"cli", "userid", &conn}
 	room := newRoom("room_id", []Client{cli})
 	msg := messages.Message{ID: "msgId"}
+	envelope, _ := newMessageEnvelope(MessageCreated, &msg)
 
-	room.SendMessage(context.Background(), &msg)
+	room.SendMessage(context.Background(), envelope)
 
-	expected, _ := json.Marshal(&msg)
+	expected, _ := json.Marshal(envelope)
 	gotBytes := conn.getReceived()
 
 	if !bytes.Equal(expected, gotBytes) {
@@ -125,8 +126,9 @@ func TestSendMessageWithError(t *testing.T) {
 	cli := Client{"cli", "userid", &conn}
 	room := newRoom("room_id", []Client{cli})
 	msg := messages.Message{ID: "msgId"}
+	envelope, _ := newMessageEnvelope(MessageCreated, &msg)
 
-	room.SendMessage(context.Background(), &msg)
+	room.SendMessage(context.Background(), envelope)
 }	
 
 func Benchmark(b *testing.B) {
@@ -0,0 +1,247 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RoomViewers envelopes are broadcasted periodically to rooms
+// whose number of viewers is changed.
+const RoomViewers EnvelopeType = "room.viewers"
+
+const (
+	defaultViewersInterval = 5 * time.Second
+	moderatorsTTL          = time.Minute // moderators of sampled rooms are reloaded after it
+)
+
+// payload of [RoomViewers] envelopes.
+type viewersPayload struct {
+	TopicID string `json:"topicId"`
+	Viewers int    `json:"viewers"` // see [room.viewers]
+}
+
+// roomViewersCounter is implemented by presence services which count
+// viewers of rooms on all instances, like [presence.DistributedService].
+type roomViewersCounter interface {
+	// RoomViewers stores numbers of viewers of rooms on this instance by topicId,
+	// and returns numbers of their viewers on all instances.
+	RoomViewers(ctx context.Context, local map[string]int) (map[string]int, error)
+}
+
+// whoCanModerateTopic is implemented by authorizers which know moderators of topics.
+// Messages of moderators are never sampled out.
+type whoCanModerateTopic interface {
+	WhoCanModerateTopic(topicId string) ([]string, error)
+}
+
+// samplingConf configures sampling messages of rooms. Zero rate disables it.
+type samplingConf struct {
+	rate       int // created messages per second delivered to each viewer
+	minViewers int // rooms with at least minViewers viewers are sampled, see [room.viewers]
+}
+
+// roomSampler delivers at most rate created messages per second to viewers of a room.
+//
+// The rate is shared fairly between senders of the previous second, so a few
+// chatty senders can not hide others. Senders always receive their own messages,
+// and messages of moderators and system messages (without sender) are never sampled out.
+// Moderators are loaded when the sampler is created, and messages are not sampled
+// until they are loaded.
+type roomSampler struct {
+	conf           samplingConf
+	loadModerators func() ([]string, error) // can be nil
+
+	mu          sync.Mutex
+	windowStart time.Time
+	delivered   int            // messages delivered to viewers since windowStart
+	perSender   map[string]int // senderId -> delivered messages since windowStart
+	prevSenders int            // senders of the previous second
+
+	moderators       map[string]struct{}
+	moderatorsLoaded time.Time // zero until moderators are loaded first
+	loadingMods      bool
+}
+
+func newRoomSampler(conf samplingConf, loadModerators func() ([]string, error)) *roomSampler {
+	s := &roomSampler{
+		conf:           conf,
+		loadModerators: loadModerators,
+		windowStart:    time.Now(),
+		perSender:      make(map[string]int),
+	}
+
+	if loadModerators != nil {
+		s.loadingMods = true
+		go s.reloadModerators()
+	}
+	return s
+}
+
+// allow reports whether the message of the sender can be delivered
+// to all viewers in the current second. mu must be held.
+func (s *roomSampler) allow(senderId string, now time.Time) bool {
+	if now.Sub(s.windowStart) >= time.Second {
+		s.prevSenders = len(s.perSender)
+		s.windowStart, s.delivered = now, 0
+		s.perSender = make(map[string]int)
+	}
+
+	sent, seen := s.perSender[senderId]
+	if !seen {
+		s.perSender[senderId] = 0 // counts the sender for the next second
+	}
+
+	share := max(1, s.conf.rate/max(1, s.prevSenders))
+	if s.delivered >= s.conf.rate || sent >= share {
+		return false
+	}
+
+	s.delivered++
+	s.perSender[senderId]++
+	return true
+}
+
+// reports whether the user is a moderator of the room, and reloads
+// moderators in background if they are older than [moderatorsTTL].
+// Everyone is a moderator until moderators are loaded first. mu must be held.
+func (s *roomSampler) isModerator(userId string, now time.Time) bool {
+	if s.moderatorsLoaded.IsZero() && s.loadingMods {
+		return true
+	}
+
+	if s.loadModerators != nil && !s.loadingMods && now.Sub(s.moderatorsLoaded) >= moderatorsTTL {
+		s.loadingMods = true
+		go s.reloadModerators()
+	}
+
+	_, found := s.moderators[userId]
+	return found
+}
+
+// loads moderators of the room. The previous moderators are kept in case of errors.
+func (s *roomSampler) reloadModerators() {
+	userIds, err := s.loadModerators()
+	if err != nil {
+		slog.Error("can not load moderators of the room", "err", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		s.moderators = make(map[string]struct{}, len(userIds))
+		for _, id := range userIds {
+			s.moderators[id] = struct{}{}
+		}
+	}
+	s.moderatorsLoaded = time.Now() // retried after ttl in case of errors
+	s.loadingMods = false
+}
+
+// deliverToAll reports whether the envelope should be delivered to all viewers
+// of a room with n viewers.
+func (s *roomSampler) deliverToAll(e *Envelope, n int) bool {
+	if e.Type != MessageCreated || e.senderId == "" || n < s.conf.minViewers {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	return s.isModerator(e.senderId, now) || s.allow(e.senderId, now)
+}
+
+// enables sampling of the room.
+func (r *room) enableSampling(conf samplingConf, moderators whoCanModerateTopic) {
+	var load func() ([]string, error)
+	if moderators != nil {
+		load = func() ([]string, error) { return moderators.WhoCanModerateTopic(r.ID) }
+	}
+	r.sampler = newRoomSampler(conf, load)
+}
+
+// returns clients of the user.
+func clientsOfUser(clients iter.Seq[Client], userId string) iter.Seq[Client] {
+	return func(yield func(Client) bool) {
+		for c := range clients {
+			if c.UserId() == userId && !yield(c) {
+				return
+			}
+		}
+	}
+}
+
+// broadcastViewers sends [RoomViewers] to rooms whose number of viewers is changed,
+// every interval until ctx is done. Viewers of all instances are counted before,
+// if the presence service is a [roomViewersCounter].
+func (r *roomServer) broadcastViewers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			r.RLock()
+			rooms := make([]*room, 0, len(r.rooms))
+			for _, room := range r.rooms {
+				rooms = append(rooms, room)
+			}
+			r.RUnlock()
+
+			r.countViewers(ctx, rooms)
+			for _, room := range rooms {
+				room.broadcastViewers(ctx)
+			}
+		}
+	}
+}
+
+// counts viewers of the rooms on all instances, if the presence service counts them.
+// Errors are logged, and the last counts are kept.
+func (r *roomServer) countViewers(ctx context.Context, rooms []*room) {
+	counter, ok := r.onlinePersons.(roomViewersCounter)
+	if !ok {
+		return
+	}
+
+	local := make(map[string]int, len(rooms))
+	for _, room := range rooms {
+		local[room.ID] = room.onlinePersons.Len()
+	}
+
+	all, err := counter.RoomViewers(ctx, local)
+	if err != nil {
+		slog.ErrorContext(ctx, "can not count viewers of rooms", "err", err)
+		return
+	}
+
+	for _, room := range rooms {
+		room.allViewers.Store(int32(all[room.ID]))
+	}
+}
+
+// returns the number of viewers of the room on all instances if they are counted,
+// see [roomServer.countViewers], otherwise clients of the room on this instance.
+// Local clients which joined after counting are not missed.
+func (r *room) viewers() int {
+	return max(r.onlinePersons.Len(), int(r.allViewers.Load()))
+}
+
+// sends [RoomViewers] to the room if its number of viewers is changed.
+func (r *room) broadcastViewers(ctx context.Context) {
+	n := r.viewers()
+	if int(r.lastViewers.Swap(int32(n))) == n {
+		return
+	}
+
+	payload, _ := json.Marshal(viewersPayload{r.ID, n})
+	r.SendMessage(ctx, &Envelope{Type: RoomViewers, Payload: payload, coalesceKey: "viewers/" + r.ID})
+}
@@ -0,0 +1,207 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"chat-system/ws/presence"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRoomSampler_allow(t *testing.T) {
+	s := newRoomSampler(samplingConf{rate: 4}, nil)
+	now := s.windowStart
+
+	count := func(senderId string, n int) (allowed int) {
+		for range n {
+			if s.allow(senderId, now) {
+				allowed++
+			}
+		}
+		return
+	}
+
+	if n := count("chatty", 6); n != 4 {
+		t.Errorf("at most rate messages should be allowed, got %d", n)
+	}
+	if n := count("quiet", 1); n != 0 {
+		t.Errorf("messages over the rate should not be allowed, got %d", n)
+	}
+
+	now = now.Add(time.Second) // the rate is shared between 2 senders
+
+	if n := count("chatty", 5); n != 2 {
+		t.Errorf("chatty sender should get its fair share, got %d", n)
+	}
+	if n := count("quiet", 2); n != 2 {
+		t.Errorf("quiet sender should get its fair share, got %d", n)
+	}
+}
+
+func TestRoom_sampling(t *testing.T) {
+	senderConn, viewerConn := &recordingConn{}, &recordingConn{}
+	sender := Client{"sender-1", "sender", senderConn}
+	viewer := Client{"viewer-1", "viewer", viewerConn}
+
+	room := newRoom("topic", []Client{sender, viewer})
+	room.enableSampling(samplingConf{rate: 1, minViewers: 2}, nil)
+	room.sampler.moderators = map[string]struct{}{"moderator": {}}
+	room.sampler.moderatorsLoaded = time.Now()
+
+	send := func(id, senderId string) {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: id, TopicID: "topic", SenderId: senderId})
+		room.SendMessage(context.Background(), e)
+	}
+
+	send("1", "sender")
+	send("2", "sender")
+	send("3", "sender")
+	send("4", "moderator")
+	send("5", "") // system message
+
+	if ids := viewerConn.messageIds(t, MessageCreated); len(ids) != 3 || ids[0] != "1" || ids[1] != "4" || ids[2] != "5" {
+		t.Errorf("viewer should receive sampled, moderator and system messages, got %v", ids)
+	}
+	if ids := senderConn.messageIds(t, MessageCreated); len(ids) != 5 {
+		t.Errorf("sender should receive all of its own messages, got %v", ids)
+	}
+}
+
+func TestRoomSampler_moderatorsLoading(t *testing.T) {
+	loading := make(chan struct{})
+	s := newRoomSampler(samplingConf{rate: 1}, func() ([]string, error) {
+		<-loading
+		return []string{"moderator"}, nil
+	})
+
+	chatty := &Envelope{Type: MessageCreated, senderId: "chatty"}
+	for range 3 {
+		if !s.deliverToAll(chatty, 1) {
+			t.Fatal("messages should not be sampled until moderators are loaded")
+		}
+	}
+
+	close(loading)
+	for !s.locked(func() bool { return !s.loadingMods }) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if !s.deliverToAll(&Envelope{Type: MessageCreated, senderId: "moderator"}, 1) {
+		t.Error("messages of moderators should not be sampled")
+	}
+	if s.deliverToAll(chatty, 1) && s.deliverToAll(chatty, 1) {
+		t.Error("messages should be sampled after moderators are loaded")
+	}
+}
+
+// calls f while mu is held.
+func (s *roomSampler) locked(f func() bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return f()
+}
+
+func TestRoom_broadcastViewers(t *testing.T) {
+	conn := &recordingConn{}
+	room := newRoom("topic", []Client{{"client", "user", conn}})
+
+	room.broadcastViewers(context.Background())
+	room.broadcastViewers(context.Background()) // not changed
+
+	frames := conn.received()
+	if len(frames) != 1 {
+		t.Fatalf("viewers should be broadcasted once, got %v", frames)
+	}
+
+	e := Envelope{}
+	json.Unmarshal([]byte(frames[0]), &e)
+
+	p := viewersPayload{}
+	json.Unmarshal(e.Payload, &p)
+
+	if e.Type != RoomViewers || p.Viewers != 1 || p.TopicID != "topic" {
+		t.Errorf("unexpected viewers envelope %s", frames[0])
+	}
+}
+
+// moderatorsAfter returns moderators after loaded is closed.
+type moderatorsAfter struct {
+	loaded chan struct{}
+}
+
+func (m moderatorsAfter) WhoCanModerateTopic(topicId string) ([]string, error) {
+	<-m.loaded
+	return []string{"moderator"}, nil
+}
+
+func TestRoom_sampling_moderatorsLoading(t *testing.T) {
+	viewerConn := &recordingConn{}
+	room := newRoom("topic", []Client{{"sender-1", "sender", &recordingConn{}}, {"viewer-1", "viewer", viewerConn}})
+
+	moderators := moderatorsAfter{make(chan struct{})}
+	room.enableSampling(samplingConf{rate: 1, minViewers: 2}, moderators)
+
+	send := func(id string) {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: id, TopicID: "topic", SenderId: "sender"})
+		room.SendMessage(context.Background(), e)
+	}
+
+	send("1")
+	send("2")
+	if ids := viewerConn.messageIds(t, MessageCreated); len(ids) != 2 {
+		t.Errorf("the room should not be sampled until moderators are loaded, got %v", ids)
+	}
+
+	close(moderators.loaded)
+	for !room.sampler.locked(func() bool { return !room.sampler.loadingMods }) {
+		time.Sleep(time.Millisecond)
+	}
+
+	send("3")
+	send("4")
+	if ids := viewerConn.messageIds(t, MessageCreated); len(ids) != 3 {
+		t.Errorf("the room should be sampled after moderators are loaded, got %v", ids)
+	}
+}
+
+func TestRoomServer_countViewers(t *testing.T) {
+	store := presence.NewMemStore()
+	local := presence.NewDistributedService[Client](store, "local", time.Minute)
+	other := presence.NewDistributedService[Client](store, "other", time.Minute)
+	defer local.Close(context.Background())
+	defer other.Close(context.Background())
+
+	rooms := NewRoomServer(local, mockAuthorizedTopics{})
+	rooms.sampling = samplingConf{rate: 1, minViewers: 10}
+
+	viewerConn := &recordingConn{}
+	rooms.subscribe(Client{"sender-1", "sender", &recordingConn{}}, "topic")
+	rooms.subscribe(Client{"viewer-1", "viewer", viewerConn}, "topic")
+	topic := rooms.getRoom("topic")
+
+	send := func(id string) {
+		e, _ := newMessageEnvelope(MessageCreated, &messages.Message{ID: id, TopicID: "topic", SenderId: "sender"})
+		topic.SendMessage(context.Background(), e)
+	}
+
+	send("1")
+	send("2")
+	if ids := viewerConn.messageIds(t, MessageCreated); len(ids) != 2 {
+		t.Fatalf("the room should not be sampled with local viewers, got %v", ids)
+	}
+
+	other.RoomViewers(context.Background(), map[string]int{"topic": 20})
+	rooms.countViewers(context.Background(), []*room{topic})
+
+	if n := topic.viewers(); n != 22 {
+		t.Errorf("viewers of all instances should be counted, got %d", n)
+	}
+
+	send("3")
+	send("4")
+	send("5")
+	if ids := viewerConn.messageIds(t, MessageCreated); len(ids) != 3 {
+		t.Errorf("the room should be sampled by viewers of all instances, got %v", ids)
+	}
+}
@@ -0,0 +1,283 @@
+package ws
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SlowConsumerPolicy specifies what happens when a client's send queue is full.
+type SlowConsumerPolicy string
+
+const (
+	// Drops the oldest pending frame of the client. The client is sent
+	// [FramesDropped] before the next frame, so it can resume its topics.
+	DropOldest SlowConsumerPolicy = "drop-oldest"
+
+	// Drops pending frames with the same key as the new frame, like older typing
+	// indicators or presence states of the same user. If the queue is still full,
+	// the oldest frame is dropped like [DropOldest].
+	Coalesce SlowConsumerPolicy = "coalesce"
+
+	// Closes the client's connection with [TryAgainLater] code.
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// ParseSlowConsumerPolicy returns [SlowConsumerPolicy] for "drop-oldest", "coalesce" or "disconnect".
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch p := SlowConsumerPolicy(s); p {
+	case DropOldest, Coalesce, Disconnect:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid slow consumer policy %q", s)
+}
+
+// FramesDropped is sent to clients after pending frames are dropped
+// by [DropOldest] or [Coalesce] policy.
+const FramesDropped EnvelopeType = "frames.dropped"
+
+// payload of [FramesDropped] envelopes.
+type framesDroppedPayload struct {
+	Count int `json:"count"` // dropped since the previous notice
+}
+
+// larger than [maxReplayMessages], so replaying does not overflow queues.
+const defaultSendQueueSize = 1024
+
+// how often [sendQueue.flush] checks pending frames.
+const flushPollInterval = 10 * time.Millisecond
+
+type sendQueueConf struct {
+	size   int
+	policy SlowConsumerPolicy
+}
+
+type queuedFrame struct {
+	key  string // empty for frames which can not be coalesced
+	data []byte
+}
+
+// sendQueue is a [Conn] which queues frames and writes them to the underlying
+// conn in its own goroutine, so a slow client does not block writers.
+type sendQueue struct {
+	conn       Conn
+	conf       sendQueueConf
+	opts       frameOptions
+	onOverflow func() // called once by [Disconnect] policy
+
+	mu         sync.Mutex
+	frames     []queuedFrame
+	dropped    int
+	unnotified int // dropped frames which the client is not told about yet
+	overflowed bool
+	writing    bool // a frame is taken by the writer goroutine but not written yet
+	closed     bool
+	wakeup     chan struct{}
+}
+
+// newSendQueue starts writing queued frames to conn until close.
+func newSendQueue(conn Conn, conf sendQueueConf, opts frameOptions, onOverflow func()) *sendQueue {
+	if conf.size <= 0 {
+		conf.size = defaultSendQueueSize
+	}
+
+	q := &sendQueue{
+		conn:       conn,
+		conf:       conf,
+		opts:       opts,
+		onOverflow: onOverflow,
+		wakeup:     make(chan struct{}, 1),
+	}
+	go q.run()
+	return q
+}
+
+// Write implements Conn. The json message is written in the client's encoding.
+// It never blocks.
+func (q *sendQueue) Write(message []byte) error {
+	q.writeFrame(&encodedFrame{json: message}, "")
+	return nil
+}
+
+// writeFrame implements frameWriter.
+func (q *sendQueue) writeFrame(f *encodedFrame, key string) {
+	if data := f.bytes(q.opts.encoding); data != nil {
+		q.enqueue(queuedFrame{key, data})
+	}
+}
+
+func (q *sendQueue) enqueue(f queuedFrame) {
+	q.mu.Lock()
+
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+
+	if q.conf.policy == Coalesce && f.key != "" {
+		q.frames = slices.DeleteFunc(q.frames, func(p queuedFrame) bool { return p.key == f.key })
+	}
+
+	if len(q.frames) >= q.conf.size {
+		q.dropped++
+
+		if q.conf.policy == Disconnect {
+			overflow := !q.overflowed
+			q.overflowed = true
+			q.mu.Unlock()
+
+			if overflow {
+				go q.onOverflow()
+			}
+			return
+		}
+
+		q.frames[0] = queuedFrame{}
+		q.frames = q.frames[1:]
+		q.unnotified++
+	}
+
+	q.frames = append(q.frames, f)
+	q.mu.Unlock()
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// returns the oldest pending frame, or false if the queue is closed.
+// [FramesDropped] is returned before frames which follow dropped frames.
+func (q *sendQueue) next() ([]byte, bool) {
+	for {
+		q.mu.Lock()
+		q.writing = false
+		if q.closed {
+			q.mu.Unlock()
+			return nil, false
+		}
+
+		if q.unnotified != 0 {
+			count := q.unnotified
+			q.unnotified = 0
+			q.writing = true
+			q.mu.Unlock()
+			return q.droppedNotice(count), true
+		}
+
+		if len(q.frames) != 0 {
+			f := q.frames[0]
+			q.frames[0] = queuedFrame{}
+			q.frames = q.frames[1:]
+			q.writing = true
+			q.mu.Unlock()
+			return f.data, true
+		}
+		q.mu.Unlock()
+
+		<-q.wakeup
+	}
+}
+
+// returns [FramesDropped] of count frames in the client's encoding.
+func (q *sendQueue) droppedNotice(count int) []byte {
+	payload, _ := json.Marshal(framesDroppedPayload{count})
+	notice, _ := json.Marshal(Envelope{Type: FramesDropped, Payload: payload})
+	return (&encodedFrame{json: notice}).bytes(q.opts.encoding)
+}
+
+func (q *sendQueue) run() {
+	for {
+		data, ok := q.next()
+		if !ok {
+			return
+		}
+
+		if err := q.conn.Write(data); err != nil {
+			slog.Error("can not write to client's connection", "err", err)
+		}
+	}
+}
+
+// frameOptions implements frameOptionsGetter.
+func (q *sendQueue) frameOptions() frameOptions {
+	return q.opts
+}
+
+// Len returns the number of pending frames.
+func (q *sendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.frames)
+}
+
+// flush waits until pending frames are written or the queue is closed.
+// It reports false if ctx is done before.
+func (q *sendQueue) flush(ctx context.Context) bool {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.mu.Lock()
+		flushed := q.closed || len(q.frames) == 0 && !q.writing
+		q.mu.Unlock()
+
+		if flushed {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dropped returns the number of frames dropped because the queue was full.
+func (q *sendQueue) Dropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// close discards pending frames and stops the writer goroutine.
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.frames = nil
+	q.mu.Unlock()
+
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// userIds are not listed, clients can be found in logs by clientId.
+type sendQueueStats struct {
+	ClientId string `json:"clientId"`
+	Depth    int    `json:"depth"`
+	Dropped  int    `json:"dropped"`
+}
+
+// serves send queue stats of connected clients, the deepest queue first.
+func (s *wsHandler) serveSendQueues(w http.ResponseWriter, r *http.Request) {
+	stats := make([]sendQueueStats, 0)
+	for _, c := range s.heartbeats.clients() {
+		if q, ok := c.Conn().(*sendQueue); ok {
+			stats = append(stats, sendQueueStats{c.ClientId(), q.Len(), q.Dropped()})
+		}
+	}
+
+	slices.SortFunc(stats, func(a, b sendQueueStats) int { return cmp.Compare(b.Depth, a.Depth) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
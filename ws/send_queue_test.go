@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingConn records written frames after unblock is closed.
+type blockingConn struct {
+	recordingConn
+	unblock chan struct{}
+}
+
+func (c *blockingConn) Write(b []byte) error {
+	<-c.unblock
+	return c.recordingConn.Write(b)
+}
+
+// returns written frames as strings.
+func (c *recordingConn) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frames := make([]string, 0, len(c.frames))
+	for _, f := range c.frames {
+		frames = append(frames, string(f))
+	}
+	return frames
+}
+
+// waits until conn receives n frames.
+func waitForFrames(t *testing.T, conn *recordingConn, n int) []string {
+	t.Helper()
+
+	for range 100 {
+		if frames := conn.received(); len(frames) >= n {
+			return frames
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d frames, got %v", n, conn.received())
+	return nil
+}
+
+func TestSendQueue_policies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy SlowConsumerPolicy
+		want   []string
+	}{
+		// "1" is taken by the writer before the queue is full
+		{"drop-oldest", DropOldest, []string{"1", `{"type":"frames.dropped","payload":{"count":1}}`, "t2", "t3", "2"}},
+		{"coalesce", Coalesce, []string{"1", "t3", "2"}},
+		{"disconnect", Disconnect, []string{"1", "t1", "t2", "t3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &blockingConn{unblock: make(chan struct{})}
+
+			var overflowed sync.WaitGroup
+			overflowed.Add(1)
+			onOverflow := func() { overflowed.Done() }
+			if tt.policy != Disconnect {
+				onOverflow = func() { t.Error("onOverflow should only be called by disconnect policy") }
+			}
+
+			q := newSendQueue(conn, sendQueueConf{3, tt.policy}, frameOptions{}, onOverflow)
+			defer q.close()
+
+			q.Write([]byte("1"))
+			for q.Len() != 0 { // the writer is blocked by "1"
+				time.Sleep(time.Millisecond)
+			}
+
+			q.writeFrame(&encodedFrame{json: []byte("t1")}, "typing")
+			q.writeFrame(&encodedFrame{json: []byte("t2")}, "typing")
+			q.writeFrame(&encodedFrame{json: []byte("t3")}, "typing")
+			q.Write([]byte("2"))
+
+			if tt.policy == Disconnect {
+				overflowed.Wait()
+			}
+
+			close(conn.unblock)
+
+			got := waitForFrames(t, &conn.recordingConn, len(tt.want))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got frames %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueue_close(t *testing.T) {
+	conn := &blockingConn{unblock: make(chan struct{})}
+	q := newSendQueue(conn, sendQueueConf{10, DropOldest}, frameOptions{}, nil)
+
+	q.Write([]byte("1"))
+	q.Write([]byte("2"))
+	q.close()
+	close(conn.unblock)
+
+	q.Write([]byte("3"))
+
+	if q.Len() != 0 {
+		t.Errorf("closed queue should be empty, got %d frames", q.Len())
+	}
+}
+
+func TestSendQueue_flush(t *testing.T) {
+	conn := &blockingConn{unblock: make(chan struct{})}
+	q := newSendQueue(conn, sendQueueConf{}, frameOptions{}, nil)
+	defer q.close()
+
+	q.Write([]byte("1"))
+	q.Write([]byte("2"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if q.flush(ctx) {
+		t.Error("flush should not succeed while the writer is blocked")
+	}
+
+	close(conn.unblock)
+	if !q.flush(context.Background()) {
+		t.Error("flush should succeed after frames are written")
+	}
+	if got := conn.received(); len(got) != 2 {
+		t.Errorf("all frames should be written after flush, got %v", got)
+	}
+}
@@ -1,25 +1,195 @@
 package ws
 
 import (
+	"chat-system/authz"
+	"chat-system/pkg/origins"
 	"chat-system/ws/presence"
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
-	"slices"
-	"strings"
+	"time"
 )
 
 type ServerOpt func(s *Server)
 
-func WithAllowedOrigin(origins ...string) ServerOpt {
+// WithAllowedOrigin allows websocket handshakes from origins matching the patterns,
+// like "https://example.com" or "https://*.preview.example.com". See [origins] for the syntax.
+// It panics if a pattern is invalid.
+func WithAllowedOrigin(patterns ...string) ServerOpt {
+	origins.MustParse(patterns...)
+
 	return func(s *Server) {
-		for _, orig := range origins {
-			if strings.HasSuffix(orig, "*") {
-				panic("ws.Server: wildcard origin not supported yet")
-			}
-		}
-		s.AllowedOrigins = append(s.AllowedOrigins, origins...)
+		s.AllowedOrigins = append(s.AllowedOrigins, patterns...)
+	}
+}
+
+// WithMessageService enables the "send" websocket command and replaying missed messages.
+// Messages are sent and listed with the same authorization as the REST API.
+func WithMessageService(svc messageService) ServerOpt {
+	return func(s *Server) {
+		s.msgSvc = svc
+	}
+}
+
+// clientsPresence tracks clients connected to this server,
+// and maybe online users of other servers.
+type clientsPresence interface {
+	presence.Service[Client]
+	devicesGetter[Client]
+	IsEmpty() bool
+	Len() int
+}
+
+// WithPresence replaces the default in-memory presence,
+// e.g. by a [presence.DistributedService].
+func WithPresence(p clientsPresence) ServerOpt {
+	return func(s *Server) {
+		s.onlineUsersPresence = p
+		s.roomServer.onlinePersons = p
+	}
+}
+
+// WithPresenceDebounce sets the delay of broadcasting [PresenceOnline] and [PresenceOffline]
+// envelopes. Reconnecting within the delay broadcasts nothing. Default is 3s.
+func WithPresenceDebounce(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.presenceDebounce = d
+	}
+}
+
+// WithHeartbeat enables sending [Heartbeat] envelopes to each client every interval,
+// and closing connections with [Timeout] code if clients do not reply [CmdPong]
+// (or any other frame) within timeout. Clients must support it, so it is disabled by default.
+func WithHeartbeat(interval, timeout time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.heartbeatInterval = interval
+		s.heartbeatTimeout = timeout
+	}
+}
+
+// WithSendQueue sets the size of each client's outbound queue, and what happens
+// when it is full. Default is 1024 frames with [Disconnect] policy,
+// so messages are not lost silently.
+func WithSendQueue(size int, policy SlowConsumerPolicy) ServerOpt {
+	return func(s *Server) {
+		s.sendQueue = sendQueueConf{size, policy}
+	}
+}
+
+// WithCompression enables permessage-deflate extension of websockets.
+func WithCompression() ServerOpt {
+	return func(s *Server) {
+		s.compress = true
+	}
+}
+
+// WithReconnectJitter sets the range of randomized reconnect hints which clients
+// receive when the server is shut down, see [Server.Shutdown]. Default is 10s.
+func WithReconnectJitter(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.reconnectJitter = d
+	}
+}
+
+// WithConnLimits caps connections of each user, each IP and the whole server.
+// Zero means unlimited, which is the default.
+//
+// Upgrades over the global or per-IP limit are rejected with 503 or 429 responses.
+// Connections over the per-user limit are closed with [PolicyVoiolation] code
+// before joining rooms. Rejections are counted by "ws.connections.rejected" metric.
+func WithConnLimits(perUser, perIP, global int) ServerOpt {
+	return func(s *Server) {
+		s.connLimits = connLimits{perUser, perIP, global}
+	}
+}
+
+// WithAuthenticator authenticates websocket clients by the bearer token of the
+// Authorization header or the [authz.AccessTokenCookie] cookie of handshakes.
+// The cookie is accepted only from origins of [WithAllowedOrigin], and not if
+// all origins are allowed, since browsers send it with handshakes of any site.
+// Clients with missing or invalid tokens are closed with [UnAuthorized] code,
+// and so are clients whose tokens expire while connected.
+func WithAuthenticator(authn authz.Authenticator) ServerOpt {
+	return func(s *Server) {
+		s.authn = authn
+	}
+}
+
+// WithAnonymousClients accepts clients without a token as [authz.AnonymousUserId].
+// They are read-only and can only subscribe to public topics, see [publicTopicChecker].
+func WithAnonymousClients() ServerOpt {
+	return func(s *Server) {
+		s.anonymous = true
+	}
+}
+
+// WithPermissionWatch enables or disables watching permission changes of topics,
+// if the authorizer supports it. Clients are added to or removed from rooms
+// when their permissions change, see [TopicAccessRevoked]. Rooms are also reconciled
+// every 5 minutes, for changes which are not watched. It is enabled by default.
+func WithPermissionWatch(enabled bool) ServerOpt {
+	return func(s *Server) {
+		s.watchPermissions = enabled
+	}
+}
+
+// WithTLS serves wss:// with the certificate and key files. The files are reloaded
+// when they change or [Server.ReloadCertificate] is called, without dropping connections.
+func WithTLS(certFile, keyFile string) ServerOpt {
+	return func(s *Server) {
+		s.certFile, s.keyFile = certFile, keyFile
+	}
+}
+
+// WithDebugEndpoints serves "/debug/send-queues" which lists queue depths of
+// connected clients. Debug endpoints are not authenticated, so they are served
+// on their own addr (like "localhost:7101") instead of the websocket listener.
+// Empty addr disables them.
+func WithDebugEndpoints(addr string) ServerOpt {
+	return func(s *Server) {
+		s.debugAddr = addr
+	}
+}
+
+// WithBatching turns on batching messages of rooms which receive at least minRate
+// messages per second. Messages arriving within window are sent in a single json array
+// frame to clients which negotiated batches. Zero window disables batching.
+// Defaults are 50ms and 20 messages per second.
+func WithBatching(window time.Duration, minRate int) ServerOpt {
+	return func(s *Server) {
+		s.roomServer.batching = batchConf{window, minRate}
+	}
+}
+
+// WithSampling delivers at most rate created messages per second to each viewer
+// of rooms with at least minViewers viewers. Sampling is disabled by default.
+//
+// Viewers of all instances are counted every viewers interval (see [WithViewersInterval])
+// if the presence is a [presence.DistributedService], otherwise only clients of this instance.
+// Messages of moderators are never sampled out, so a room is not sampled until its
+// moderators are loaded, which starts when the room is created.
+func WithSampling(rate, minViewers int) ServerOpt {
+	return func(s *Server) {
+		s.roomServer.sampling = samplingConf{rate, minViewers}
+	}
+}
+
+// WithViewersInterval sets how often viewers of rooms are counted and [RoomViewers]
+// envelopes are broadcasted. Zero disables them. Default is 5s.
+func WithViewersInterval(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.viewersInterval = d
+	}
+}
+
+// WithSubscriptionMode sets how clients join rooms. Default is [AutoSubscription].
+func WithSubscriptionMode(mode SubscriptionMode) ServerOpt {
+	return func(s *Server) {
+		s.roomServer.mode = mode
 	}
 }
 
@@ -34,12 +204,27 @@ func NewServer(watcher MessageWatcher, authz whoCanReadTopic, opts ...ServerOpt)
 		roomDispatcher:      NewRoomDispatcher(),
 		httpHandler:         http.NewServeMux(),
 		listenDone:          make(chan struct{}),
+		presenceDebounce:    defaultPresenceDebounce,
+		sendQueue:           sendQueueConf{defaultSendQueueSize, Disconnect},
+		viewersInterval:     defaultViewersInterval,
+		reconnectJitter:     defaultReconnectJitter,
+		watchPermissions:    true,
+		watcherDone:         make(chan struct{}),
 	}
 
+	s.roomServer.batching = batchConf{defaultBatchWindow, defaultBatchMinRate}
+
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	s.commands = newCommandHandler(s.roomServer, s.msgSvc)
+	s.presenceNotifier = newPresenceNotifier(s.roomServer, s.onlineUsersPresence, s.presenceDebounce)
+
+	if w, ok := watcher.(TopicFilterSetter); ok {
+		w.SetTopicFilter(s.isTopicWatched)
+	}
+
 	s.registerEventHandlers()
 	s.setupWsHandler()
 
@@ -51,28 +236,84 @@ type Server struct {
 	Authz          whoCanReadTopic
 	AllowedOrigins []string
 
-	onlineUsersPresence *presence.MemService[Client]
+	onlineUsersPresence clientsPresence
 	roomServer          *roomServer
 	roomDispatcher      *roomDispatcher
 	wsHandler           wsHandler
+	commands            *commandHandler
+	msgSvc              messageService
+	presenceNotifier    *presenceNotifier
+	presenceDebounce    time.Duration
+	heartbeatInterval   time.Duration
+	heartbeatTimeout    time.Duration
+	sendQueue           sendQueueConf
+	compress            bool
+	viewersInterval     time.Duration
+	reconnectJitter     time.Duration
+	connLimits          connLimits
+	authn               authz.Authenticator
+	anonymous           bool
+	watchPermissions    bool
+	certFile            string // TLS is enabled if it's not empty
+	keyFile             string
+	certs               *certReloader
+	debugAddr           string // debug endpoints are served if it's not empty
+	debugServer         *http.Server
 	httpHandler         *http.ServeMux
 	httpServer          *http.Server
 
+	stopBackground context.CancelFunc // stops background goroutines, like watching messages
+	watcherDone    chan struct{}
+
 	listenDone chan struct{} // used for synchronization of readig wsURL.
 	wsURL      string
 }
 
-// ListenAndServe listens and serves websocket handshakes on path "/ws".
+// ListenAndServe listens and serves websocket handshakes on path "/ws",
+// over TLS if [WithTLS] is used.
 func (s *Server) ListenAndServe(addr string) error {
-	go ReadChangeStream(s.Watcher, s.roomServer)
-
-	s.httpServer = &http.Server{Addr: addr, Handler: s.httpHandler}
-	s.httpServer.RegisterOnShutdown(func() {
-		err := s.wsHandler.shutdown()
+	if s.certFile != "" {
+		certs, err := newCertReloader(s.certFile, s.keyFile)
 		if err != nil {
-			slog.Error("can not shutdown websocket", "err", err)
+			close(s.listenDone)
+			return err
 		}
-	})
+		s.certs = certs
+	}
+
+	if s.debugAddr != "" {
+		if err := s.listenDebug(); err != nil {
+			close(s.listenDone)
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopBackground = cancel
+
+	go func() {
+		defer close(s.watcherDone)
+		ReadChangeStream(ctx, s.Watcher, s.roomServer)
+	}()
+	if s.heartbeatInterval > 0 {
+		go s.wsHandler.runHeartbeats(s.heartbeatInterval, s.heartbeatTimeout)
+	}
+
+	if s.viewersInterval > 0 {
+		go s.roomServer.broadcastViewers(ctx, s.viewersInterval)
+	}
+	go s.roomServer.refreshDegradedRooms(ctx, degradedRoomsInterval)
+	if s.certs != nil {
+		go s.certs.watch(ctx, certCheckInterval)
+	}
+	if s.watchPermissions {
+		if w, ok := s.Authz.(topicChangesWatcher); ok {
+			go s.roomServer.watchPermissions(ctx, w)
+		}
+		go s.roomServer.reconcileRoomsEvery(ctx, permissionReconcileInterval)
+	}
+
+	s.httpServer = &http.Server{Addr: addr, Handler: s.httpHandler}
 
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -80,21 +321,60 @@ func (s *Server) ListenAndServe(addr string) error {
 		return err
 	}
 
-	s.wsURL = "ws://" + ln.Addr().String()
+	scheme := "ws://"
+	if s.certs != nil {
+		ln = tls.NewListener(ln, s.certs.tlsConfig())
+		scheme = "wss://"
+	}
+
+	s.wsURL = scheme + ln.Addr().String()
 	close(s.listenDone)
 
 	return s.httpServer.Serve(ln)
 }
 
 func (s *Server) setupWsHandler() {
-	s.wsHandler = newWsHandler(s.onlineUsersPresence, s.roomDispatcher)
-	handler := wsHandler.setupHttpMiddlewares(s.wsHandler)
+	s.wsHandler = newWsHandler(s.onlineUsersPresence, s.roomDispatcher, s.commands, wsHandlerConf{s.sendQueue, s.compress, s.reconnectJitter, s.connLimits, s.authn, s.anonymous, s.cookieOrigins()})
 
-	handler = AllowedOriginsMiddleware(handler, s.AllowedOrigins)
+	handler := AllowedOriginsMiddleware(s.wsHandler, s.AllowedOrigins)
 
 	s.httpHandler.Handle("/ws", handler)
 }
 
+// serves debug endpoints on s.debugAddr, see [WithDebugEndpoints].
+// s.debugAddr is updated to the listening address.
+func (s *Server) listenDebug() error {
+	ln, err := net.Listen("tcp", s.debugAddr)
+	if err != nil {
+		return fmt.Errorf("can not listen for debug endpoints: %w", err)
+	}
+	s.debugAddr = ln.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /debug/send-queues", s.wsHandler.serveSendQueues)
+	s.debugServer = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.debugServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("serving debug endpoints failed", "err", err)
+		}
+	}()
+	return nil
+}
+
+// returns origins whose handshakes are authenticated by [authz.AccessTokenCookie],
+// or nil if no origin is allowed explicitly.
+func (s *Server) cookieOrigins() *origins.Policy {
+	policy := origins.MustParse(s.AllowedOrigins...)
+	if policy.Empty() || policy.AllowsAny() {
+		if s.authn != nil {
+			slog.Warn("access token cookies are not accepted, since allowed origins are not set")
+		}
+		return nil
+	}
+	return policy
+}
+
 func (s *Server) registerEventHandlers() {
 	s.roomDispatcher.SubscribeOnClientEvents(func(e clientEvent) {
 		if e.EventType() == clientConnected {
@@ -102,8 +382,12 @@ func (s *Server) registerEventHandlers() {
 			if err != nil {
 				slog.Error("onClientConneted fails", slog.String("clientId", e.client.ClientId()), "err", err)
 				s.wsHandler.closeClient(e.client, InternalError)
+				return
 			}
+			s.presenceNotifier.onClientConnected(e.client)
 		} else {
+			s.presenceNotifier.onClientDisconnected(e.client)
+			s.commands.typing.onClientDisconnected(e.client)
 			err := s.roomServer.onClientDisconnected(e.client)
 			if err != nil {
 				slog.Error("onClientDisconneted fails", slog.String("clientId", e.client.ClientId()), "err", err)
@@ -112,32 +396,84 @@ func (s *Server) registerEventHandlers() {
 	})
 }
 
+// isTopicWatched reports whether messages of the topic may have a receiver on this server.
+//
+// In [ExplicitSubscription] mode only topics with a room are watched.
+// In [AutoSubscription] mode rooms are created by messages,
+// so all topics are watched while any client is connected.
+func (s *Server) isTopicWatched(topicId string) bool {
+	if s.roomServer.hasRoom(topicId) {
+		return true
+	}
+	return !s.roomServer.explicitMode() && !s.onlineUsersPresence.IsEmpty()
+}
+
+// Shutdown drains the server gracefully:
+//   - new websocket upgrades are rejected and the listener is closed,
+//   - watching messages and broadcasting viewers are stopped,
+//   - pending frames of clients are written until ctx is done,
+//   - clients are closed with [ServiceRestart] code and a randomized reconnect hint,
+//   - websockets and the presence are closed.
+//
+// [Server.ListenAndServe] returns [http.ErrServerClosed] immediately,
+// so callers should wait for Shutdown to return.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	s.wsHandler.draining.Store(true)
+	httpErr := s.httpServer.Shutdown(ctx)
+	if s.debugServer != nil {
+		httpErr = errors.Join(httpErr, s.debugServer.Shutdown(ctx))
+	}
+
+	s.stopBackground()
+	select {
+	case <-s.watcherDone:
+	case <-ctx.Done():
+		slog.Warn("watching messages is not stopped", "err", ctx.Err())
+	}
+
+	s.wsHandler.drain(ctx)
+	wsErr := s.wsHandler.shutdown()
+
+	var presenceErr error
+	if p, ok := s.onlineUsersPresence.(interface{ Close(context.Context) error }); ok {
+		presenceErr = p.Close(ctx)
+	}
+
+	return errors.Join(httpErr, wsErr, presenceErr)
+}
+
+// ReloadCertificate reloads the TLS certificate files, e.g. on SIGHUP.
+// The current certificate is kept in case of errors.
+func (s *Server) ReloadCertificate() error {
+	<-s.listenDone
+	if s.certs == nil {
+		return errors.New("ws.Server: TLS is not enabled")
+	}
+	return s.certs.reload()
 }
 
-// return websocket endpoint (like ws://127.0.0.1:7100/ws).
+// return websocket endpoint (like ws://127.0.0.1:7100/ws, or wss:// with TLS).
 func (s *Server) WsEndpoint() string {
 	<-s.listenDone
 	return s.wsURL + "/ws"
 }
 
 // A middleware to check Origin http header to prevent CSRF attack in websocket handshakes in browsers.
+// allowedOrigins are patterns of [origins.Parse].
 //
 // If Origin header is empty or is allowed, it will pass.
 // Buf if origin is not allowed returns 403 response.
 func AllowedOriginsMiddleware(h http.Handler, allowedOrigins []string) http.Handler {
-	if len(allowedOrigins) == 0 {
+	policy := origins.MustParse(allowedOrigins...)
+	if policy.Empty() {
 		return h
 	}
 
 	errBody := []byte("Origin is not allowed.")
-	slices.Sort(allowedOrigins)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
 
-		_, found := slices.BinarySearch(allowedOrigins, origin)
-		if found || origin == "" {
+		if origin == "" || policy.Allowed(origin) {
 			h.ServeHTTP(w, r)
 		} else {
 			w.WriteHeader(403)
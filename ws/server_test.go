@@ -3,18 +3,28 @@ package ws
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	nettyws "github.com/go-netty/go-netty-ws"
 )
 
+// userIdTokens authenticates tokens which are userIds. used for testing.
+type userIdTokens struct{}
+
+func (userIdTokens) Authenticate(token string) (string, time.Time, error) {
+	return token, time.Time{}, nil
+}
+
 // returns new connection. used for testing.
 func newWsConnection(endpoint, userId string) (nettyws.Conn, error) {
 	ws := nettyws.NewWebsocket(nettyws.WithClientHeader(
 		http.Header{
-			"Cookie": []string{fmt.Sprintf("userId=%s", userId)},
+			"Authorization": []string{fmt.Sprintf("Bearer %s", userId)},
 		},
 	))
 	return ws.Open(endpoint)
@@ -24,7 +34,7 @@ func TestConnectingDisconnecting_handling_events(t *testing.T) {
 	watcher := newMockWatcher()
 	mockAuthz := NewMockTestAuthz()
 
-	wsServer := NewServer(watcher, mockAuthz)
+	wsServer := NewServer(watcher, mockAuthz, WithAuthenticator(userIdTokens{}))
 	defer func() {
 		err := wsServer.Shutdown(context.Background())
 		if err != nil {
@@ -85,6 +95,9 @@ func TestAllowedOriginMiddleware(t *testing.T) {
 		{"allowed origin", "example.com", []string{"example.com"}, true},
 		{"not-exists", "attacker.com", []string{"example.com"}, false},
 		{"subdomain-not-exists", "sub.example.com", []string{"example.com"}, false},
+		{"wildcard subdomain", "https://pr-1.example.com", []string{"https://*.example.com"}, true},
+		{"wildcard without subdomain", "https://example.com", []string{"https://*.example.com"}, false},
+		{"wrong scheme", "http://example.com", []string{"https://example.com"}, false},
 	}
 
 	for _, tt := range tests {
@@ -114,3 +127,109 @@ func TestAllowedOriginMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_cookieOrigins(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins []string
+		origin         string
+		accepted       bool
+	}{
+		{"allowed origin", []string{"https://chat.example.com"}, "https://chat.example.com", true},
+		{"cross origin", []string{"https://chat.example.com"}, "https://attacker.example", false},
+		{"empty allowed origins", nil, "https://attacker.example", false},
+		{"all origins", []string{"*"}, "https://attacker.example", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{AllowedOrigins: tt.allowedOrigins}
+			policy := s.cookieOrigins()
+
+			if accepted := policy != nil && policy.Allowed(tt.origin); accepted != tt.accepted {
+				t.Errorf("cookies of the origin should be accepted=%v, got %v", tt.accepted, accepted)
+			}
+		})
+	}
+}
+
+type filteredWatcher struct {
+	*mockWatcher
+	watched func(topicId string) bool
+}
+
+// SetTopicFilter implements TopicFilterSetter.
+func (w *filteredWatcher) SetTopicFilter(watched func(topicId string) bool) {
+	w.watched = watched
+}
+
+func TestServer_topicFilter(t *testing.T) {
+	tests := []struct {
+		mode SubscriptionMode
+		// watched states of "topic" when the server is empty,
+		// when a client connected and when the topic has a room.
+		expected [3]bool
+	}{
+		{AutoSubscription, [3]bool{false, true, true}},
+		{ExplicitSubscription, [3]bool{false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			watcher := &filteredWatcher{mockWatcher: newMockWatcher()}
+			s := NewServer(watcher, mockAuthorizedTopics{}, WithSubscriptionMode(tt.mode))
+
+			if watcher.watched == nil {
+				t.Fatal("server should set the topic filter of the watcher")
+			}
+
+			cli := Client{"client", "user", &mockConn{}}
+			ctx := context.Background()
+
+			got := [3]bool{}
+			got[0] = watcher.watched("topic")
+
+			s.onlineUsersPresence.Connect(ctx, cli)
+			got[1] = watcher.watched("topic")
+
+			s.roomServer.subscribe(cli, "topic")
+			got[2] = watcher.watched("topic")
+
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestServer_debugEndpoints(t *testing.T) {
+	wsServer := NewServer(newMockWatcher(), NewMockTestAuthz(), WithDebugEndpoints("127.0.0.1:"))
+	go func() {
+		err := wsServer.ListenAndServe("127.0.0.1:")
+		if err != http.ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	defer wsServer.Shutdown(context.Background())
+
+	endpoint := strings.Replace(wsServer.WsEndpoint(), "ws://", "http://", 1)
+	res, err := http.Get(strings.TrimSuffix(endpoint, "/ws") + "/debug/send-queues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("debug endpoints should not be served with websockets, got status %d", res.StatusCode)
+	}
+
+	res, err = http.Get("http://" + wsServer.debugAddr + "/debug/send-queues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("debug endpoints should be served on their addr, got status %d and %s", res.StatusCode, body)
+	}
+}
@@ -0,0 +1,117 @@
+package ws
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// how often certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves the certificate of files, and reloads it when the files change.
+// Only new handshakes use a reloaded certificate, so connections are not dropped.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // the latest modification time of the files
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate files. The current certificate is kept in case of errors.
+func (r *certReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("can not load tls certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+
+	slog.Info("tls certificate is loaded", "certFile", r.certFile)
+	return nil
+}
+
+// returns the latest modification time of the certificate and key files.
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("can not stat tls file: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reloads the certificate if its files are modified.
+func (r *certReloader) reloadIfModified() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	modified := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+
+	if !modified {
+		return nil
+	}
+	return r.reload()
+}
+
+// watch reloads the certificate when its files are modified, every interval until ctx is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reloadIfModified(); err != nil {
+				slog.Error("can not reload tls certificate", "err", err)
+			}
+		}
+	}
+}
+
+// GetCertificate implements [tls.Config.GetCertificate].
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"}, // websocket handshakes need HTTP/1.1
+	}
+}
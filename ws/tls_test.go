@@ -0,0 +1,124 @@
+package ws
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writes a self-signed certificate of commonName to files, and returns their paths.
+func writeTestCert(t *testing.T, dir, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+	return certFile, keyFile
+}
+
+func servedCommonName(t *testing.T, r *certReloader) string {
+	t.Helper()
+
+	cert, _ := r.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "old.example.com")
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.reloadIfModified(); err != nil || servedCommonName(t, r) != "old.example.com" {
+		t.Errorf("not modified certificate should be kept, err: %v", err)
+	}
+
+	writeTestCert(t, dir, "new.example.com")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	if err := r.reloadIfModified(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := servedCommonName(t, r); cn != "new.example.com" {
+		t.Errorf("modified certificate should be reloaded, got %s", cn)
+	}
+
+	os.WriteFile(certFile, []byte("invalid"), 0o600)
+	if err := r.reload(); err == nil {
+		t.Error("reloading invalid certificate should fail")
+	}
+	if cn := servedCommonName(t, r); cn != "new.example.com" {
+		t.Errorf("the current certificate should be kept on errors, got %s", cn)
+	}
+}
+
+func TestServer_tls(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "localhost")
+
+	wsServer := NewServer(newMockWatcher(), NewMockTestAuthz(), WithTLS(certFile, keyFile))
+	go func() {
+		err := wsServer.ListenAndServe("127.0.0.1:")
+		if err != http.ErrServerClosed {
+			t.Error(err)
+		}
+	}()
+	defer wsServer.Shutdown(context.Background())
+
+	endpoint := wsServer.WsEndpoint()
+	if !strings.HasPrefix(endpoint, "wss://") {
+		t.Fatalf("endpoint should have wss scheme, got %s", endpoint)
+	}
+
+	u, _ := url.Parse(endpoint)
+	conn, err := tls.Dial("tcp", u.Host, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "localhost" {
+		t.Errorf("the certificate of files should be served, got %s", cn)
+	}
+}
@@ -0,0 +1,203 @@
+package ws
+
+import (
+	"chat-system/core/messages"
+	"context"
+	"encoding/json"
+	"errors"
+	"iter"
+	"sync"
+	"time"
+)
+
+// commands sent by clients and pushed to other members of the room.
+const (
+	CmdTypingStart EnvelopeType = "typing.start"
+	CmdTypingStop  EnvelopeType = "typing.stop"
+)
+
+const (
+	typingTTL = 5 * time.Second // typing indicators are stopped if not started again
+
+	// at most typingRateLimit typing commands in typingRateWindow per client.
+	typingRateLimit  = 5
+	typingRateWindow = time.Second
+)
+
+var errRateLimited = errors.New("too many commands")
+
+// payload of pushed [CmdTypingStart] and [CmdTypingStop] envelopes.
+type typingPayload struct {
+	TopicID string `json:"topicId"`
+	UserId  string `json:"userId"`
+}
+
+type typingKey struct {
+	topicId  string
+	clientId string
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// typingIndicators fans out typing indicators to other members of rooms.
+// They are only kept in memory, and expire after ttl.
+type typingIndicators struct {
+	rooms *roomServer
+	ttl   time.Duration
+
+	mu      sync.Mutex
+	typing  map[typingKey]*time.Timer
+	windows map[string]*rateWindow // clientId -> rate limit window
+}
+
+func newTypingIndicators(rooms *roomServer, ttl time.Duration) *typingIndicators {
+	return &typingIndicators{
+		rooms:   rooms,
+		ttl:     ttl,
+		typing:  make(map[typingKey]*time.Timer),
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// reports whether the client can send another typing command. mu must be held.
+func (t *typingIndicators) allow(c Client) bool {
+	now := time.Now()
+
+	w, ok := t.windows[c.ClientId()]
+	if !ok || now.Sub(w.start) >= typingRateWindow {
+		t.windows[c.ClientId()] = &rateWindow{now, 1}
+		return true
+	}
+
+	w.count++
+	return w.count <= typingRateLimit
+}
+
+// start fans out [CmdTypingStart] to the room, or extends
+// the indicator's expiration if the client is already typing.
+func (t *typingIndicators) start(c Client, topicId string) error {
+	room, err := t.joinedRoom(c, topicId)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+
+	if !t.allow(c) {
+		t.mu.Unlock()
+		return errRateLimited
+	}
+
+	key := typingKey{topicId, c.ClientId()}
+	timer, typing := t.typing[key]
+	if typing {
+		timer.Reset(t.ttl)
+	} else {
+		t.typing[key] = time.AfterFunc(t.ttl, func() { t.expire(c, key) })
+	}
+
+	t.mu.Unlock()
+
+	if !typing {
+		t.broadcast(room, c, CmdTypingStart)
+	}
+	return nil
+}
+
+// stop fans out [CmdTypingStop] to the room if the client is typing.
+func (t *typingIndicators) stop(c Client, topicId string) error {
+	room, err := t.joinedRoom(c, topicId)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+
+	if !t.allow(c) {
+		t.mu.Unlock()
+		return errRateLimited
+	}
+
+	key := typingKey{topicId, c.ClientId()}
+	typing := t.remove(key)
+
+	t.mu.Unlock()
+
+	if typing {
+		t.broadcast(room, c, CmdTypingStop)
+	}
+	return nil
+}
+
+// removes the indicator and reports whether it existed. mu must be held.
+func (t *typingIndicators) remove(key typingKey) bool {
+	timer, typing := t.typing[key]
+	if typing {
+		timer.Stop()
+		delete(t.typing, key)
+	}
+	return typing
+}
+
+func (t *typingIndicators) expire(c Client, key typingKey) {
+	t.mu.Lock()
+	typing := t.remove(key)
+	t.mu.Unlock()
+
+	if room := t.rooms.existingRoom(key.topicId); typing && room != nil {
+		t.broadcast(room, c, CmdTypingStop)
+	}
+}
+
+// stops typing indicators of the client and forgets its rate limit.
+func (t *typingIndicators) onClientDisconnected(c Client) {
+	t.mu.Lock()
+
+	delete(t.windows, c.ClientId())
+
+	stopped := make([]typingKey, 0)
+	for key := range t.typing {
+		if key.clientId == c.ClientId() && t.remove(key) {
+			stopped = append(stopped, key)
+		}
+	}
+
+	t.mu.Unlock()
+
+	for _, key := range stopped {
+		if room := t.rooms.existingRoom(key.topicId); room != nil {
+			t.broadcast(room, c, CmdTypingStop)
+		}
+	}
+}
+
+// returns the room of topicId if the client is joined to it.
+func (t *typingIndicators) joinedRoom(c Client, topicId string) (*room, error) {
+	room := t.rooms.existingRoom(topicId)
+
+	if room == nil || !room.hasClient(c) {
+		return nil, messages.ErrNotAuthorized{Subject: c.UserId(), ResorceType: "room", ResorceId: topicId}
+	}
+	return room, nil
+}
+
+// sends the indicator to clients of the room except clients of the typing user.
+func (t *typingIndicators) broadcast(r *room, c Client, typ EnvelopeType) {
+	payload, _ := json.Marshal(typingPayload{r.ID, c.UserId()})
+	data, _ := json.Marshal(&Envelope{Type: typ, Payload: payload})
+
+	clients, _ := r.onlinePersons.GetOnlineClients(context.Background())
+	others := func(yield func(Client) bool) {
+		for cli := range clients {
+			if cli.UserId() != c.UserId() && !yield(cli) {
+				return
+			}
+		}
+	}
+
+	key := "typing/" + r.ID + "/" + c.UserId()
+	r.clientsFanOut(context.Background(), key, &encodedFrame{json: data}, iter.Seq[Client](others))
+}
@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// returns types of received envelopes.
+func (c *recordingConn) envelopeTypes(t *testing.T) []EnvelopeType {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	types := []EnvelopeType{}
+	for _, frame := range c.frames {
+		e := Envelope{}
+		if err := json.Unmarshal(frame, &e); err != nil {
+			t.Fatalf("invalid frame %s: %v", frame, err)
+		}
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestTypingIndicators(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	typing := newTypingIndicators(rooms, 50*time.Millisecond)
+
+	typerConn, otherDeviceConn, memberConn := &recordingConn{}, &recordingConn{}, &recordingConn{}
+	typer := Client{"typer-1", "typer", typerConn}
+	otherDevice := Client{"typer-2", "typer", otherDeviceConn}
+	member := Client{"member-1", "member", memberConn}
+
+	for _, c := range []Client{typer, otherDevice, member} {
+		if err := rooms.subscribe(c, "topic"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 2 { // starting again only extends the expiration
+		if err := typing.start(typer, "topic"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := memberConn.envelopeTypes(t); len(got) != 1 || got[0] != CmdTypingStart {
+		t.Errorf("member should receive one %s, got %v", CmdTypingStart, got)
+	}
+	if got := otherDeviceConn.envelopeTypes(t); len(got) != 0 {
+		t.Errorf("devices of the typing user should not receive indicators, got %v", got)
+	}
+	if got := typerConn.envelopeTypes(t); len(got) != 0 {
+		t.Errorf("the typing client should not receive indicators, got %v", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if got := memberConn.envelopeTypes(t); len(got) != 2 || got[1] != CmdTypingStop {
+		t.Errorf("expired indicator should be stopped, got %v", got)
+	}
+
+	if err := typing.stop(typer, "topic"); err != nil {
+		t.Fatal(err)
+	}
+	if got := memberConn.envelopeTypes(t); len(got) != 2 {
+		t.Errorf("stopping an expired indicator should not be broadcasted, got %v", got)
+	}
+
+	typing.start(typer, "topic")
+	typing.onClientDisconnected(typer)
+
+	if got := memberConn.envelopeTypes(t); len(got) != 4 || got[3] != CmdTypingStop {
+		t.Errorf("indicators of disconnected clients should be stopped, got %v", got)
+	}
+}
+
+func TestCommandHandler_typing(t *testing.T) {
+	rooms := NewRoomServer(mockDeviceGetter{}, mockAuthorizedTopics{})
+	h := newCommandHandler(rooms, nil)
+	ctx := context.Background()
+
+	cli := Client{"client", "user", &recordingConn{}}
+	payload := json.RawMessage(`{"topicId":"topic"}`)
+
+	reply := h.handle(ctx, cli, &Envelope{ID: "1", Type: CmdTypingStart, Payload: payload})
+	if reply.Type != ReplyError || errorCode(t, reply) != ErrCodeForbidden {
+		t.Errorf("typing in a not joined room should be forbidden, got %s %s", reply.Type, reply.Payload)
+	}
+
+	if err := rooms.subscribe(cli, "topic"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range typingRateLimit {
+		typ := CmdTypingStart
+		if i%2 == 1 {
+			typ = CmdTypingStop
+		}
+
+		reply := h.handle(ctx, cli, &Envelope{ID: "1", Type: typ, Payload: payload})
+		if reply.Type != ReplyAck {
+			t.Fatalf("%s should be acked, got %s %s", typ, reply.Type, reply.Payload)
+		}
+	}
+
+	reply = h.handle(ctx, cli, &Envelope{ID: "2", Type: CmdTypingStart, Payload: payload})
+	if reply.Type != ReplyError || errorCode(t, reply) != ErrCodeRateLimited {
+		t.Errorf("expected rate_limited error, got %s %s", reply.Type, reply.Payload)
+	}
+
+	h.typing.onClientDisconnected(cli)
+}
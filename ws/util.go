@@ -1,9 +1,6 @@
 package ws
 
 import (
-	"fmt"
-	"hash/fnv"
-	"log/slog"
 	"math/rand/v2"
 )
 
@@ -23,80 +20,6 @@ func findAndDelete[T comparable](list []T, elem T) []T {
 	return list
 }
 
-type workerJob struct {
-	cli     Client
-	message []byte
-}
-type shardedWriter struct {
-	num        uint32
-	workerJobs []chan workerJob
-}
-
-// Creates multiple job chans and goroutins
-// to shard jobs by the hash of the client's userId.
-func newShardedWriter(num uint32) shardedWriter {
-	if num == 0 {
-		panic(fmt.Errorf("can not create new shardedWriter with zero workers"))
-	}
-
-	j := make([]chan workerJob, num)
-	for i := range j {
-		j[i] = make(chan workerJob, 30)
-	}
-
-	return shardedWriter{num: num, workerJobs: j}
-}
-
-// Writes to client's [Conn] in a seperate goroutine.
-// It logs errors.
-//
-//  1. It prevent DoS in case of slow connection (ex. full tcp queues) by sharding
-//     clients between goroutines.
-//  2. It prevents race conditions (ex. unordered messages or unexpected closed connection errors)
-func (w *shardedWriter) writeTo(cli Client, msg []byte) {
-	index := getHash(cli.userId) % w.num
-	w.workerJobs[index] <- workerJob{cli: cli, message: msg}
-}
-
-func (w *shardedWriter) run() {
-	for i := uint32(0); i < w.num; i++ {
-		go func(workerIdx uint32) {
-			jobs := w.workerJobs[workerIdx]
-			for job := range jobs {
-				w.write(&job.cli, job.message)
-			}
-		}(i)
-	}
-}
-
-func (w shardedWriter) write(client *Client, bytes []byte) {
-	conn := client.Conn()
-	if conn == nil {
-		slog.Error("client's connection is nil")
-		return
-	}
-
-	if err := conn.Write(bytes); err != nil {
-		// never here
-		slog.Error("can not write to client's connection",
-			slog.String("userId", client.UserId()),
-			slog.String("clientId", client.ClientId()),
-			"err", err)
-	}
-}
-
-func (w shardedWriter) Close() {
-	for _, ch := range w.workerJobs {
-		close(ch)
-	}
-}
-
-func getHash(s string) uint32 {
-	h := fnv.New32a()
-	h.Write([]byte(s))
-	return h.Sum32()
-}
-
 // returns "-A8df" like random string which starts with '-'.
 func randomClientIdSuffix() string {
 	const s = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -20,6 +20,29 @@ func (w wsAuthorizer) WhoCanWatchTopic(topicId string) ([]string, error) {
 	return w.authz.WhoHasRel(context.TODO(), "watch", "topic", topicId)
 }
 
+// WhoCanWatchTopicAsFreshAs implements whoCanWatchTopicAsFreshAs.
+func (w wsAuthorizer) WhoCanWatchTopicAsFreshAs(topicId, token string) ([]string, error) {
+	return w.authz.WhoHasRelAsFreshAs(context.TODO(), token, "watch", "topic", topicId)
+}
+
+// WhoCanModerateTopic implements whoCanModerateTopic.
+func (w wsAuthorizer) WhoCanModerateTopic(topicId string) ([]string, error) {
+	return w.authz.WhoHasRel(context.TODO(), "moderate", "topic", topicId)
+}
+
+// WatchTopicChanges implements topicChangesWatcher. Only relationships of topics
+// are watched, e.g. changes of a group's members are not noticed.
+func (w wsAuthorizer) WatchTopicChanges(ctx context.Context, cursor *string, changed func(topicId, token string)) error {
+	return w.authz.WatchObjects(ctx, "topic", cursor, changed)
+}
+
+// IsTopicPublic implements publicTopicChecker. A topic is public when
+// every user can watch it, e.g. by a `user:*` relationship in its "watch" permission,
+// so it is checked for [authz.AnonymousUserId].
+func (w wsAuthorizer) IsTopicPublic(topicId string) (bool, error) {
+	return w.authz.Check(context.TODO(), authz.AnonymousUserId, "watch", "topic", topicId)
+}
+
 func (w wsAuthorizer) TopicsWhichUserCanWatch(userId string, topicsToFilter []string) (topicIds []string, err error) {
 	authorizedTopics, err := w.authz.WhichObjsRelateToUser(context.TODO(), userId, "watch", "topic")
 	if err != nil {
@@ -1,10 +1,14 @@
 package ws
 
 import (
+	"errors"
 	"fmt"
 	"math/rand/v2"
 	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type TestAuthz struct {
@@ -79,3 +83,73 @@ func (t *TestAuthz) TopicsWhichUserCanWatch(userId string, topics []string) (top
 func (t TestAuthz) RandomUserId() string {
 	return t.authorizedUserIds[rand.Int()%2001]
 }
+
+// fakeAuthz is a configurable authorizer of tests. userIds can watch all topics,
+// and every user can watch public topics.
+type fakeAuthz struct {
+	mockAuthorizedTopics
+
+	userIds []string
+	delay   time.Duration // of each WhoCanWatchTopic call, so concurrent callers wait for it
+	blocked string        // WhoCanWatchTopic of this topic waits until unblock is closed
+	unblock chan struct{}
+	fails   atomic.Int32 // number of WhoCanWatchTopic calls which fail
+	calls   atomic.Int32 // number of WhoCanWatchTopic calls
+
+	mu     sync.Mutex
+	public map[string]bool // topicId -> the topic is public
+	tokens []string        // passed to WhoCanWatchTopicAsFreshAs
+}
+
+// WhoCanWatchTopic implements whoCanReadTopic.
+func (m *fakeAuthz) WhoCanWatchTopic(topicId string) ([]string, error) {
+	m.calls.Add(1)
+	time.Sleep(m.delay)
+
+	if topicId == m.blocked {
+		<-m.unblock
+	}
+
+	if m.fails.Add(-1) >= 0 {
+		return nil, errors.New("authz is not available")
+	}
+	return m.userIds, nil
+}
+
+// WhoCanWatchTopicAsFreshAs implements whoCanWatchTopicAsFreshAs.
+func (m *fakeAuthz) WhoCanWatchTopicAsFreshAs(topicId, token string) ([]string, error) {
+	m.mu.Lock()
+	m.tokens = append(m.tokens, token)
+	m.mu.Unlock()
+
+	return m.WhoCanWatchTopic(topicId)
+}
+
+// returns tokens which are passed to WhoCanWatchTopicAsFreshAs.
+func (m *fakeAuthz) freshTokens() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.tokens)
+}
+
+// IsTopicPublic implements publicTopicChecker.
+func (m *fakeAuthz) IsTopicPublic(topicId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.public[topicId], nil
+}
+
+// makes the topic public or private.
+func (m *fakeAuthz) setPublic(topicId string, public bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.public == nil {
+		m.public = make(map[string]bool)
+	}
+	m.public[topicId] = public
+}
+
+var _ whoCanReadTopic = &fakeAuthz{}
+var _ publicTopicChecker = &fakeAuthz{}
+var _ whoCanWatchTopicAsFreshAs = &fakeAuthz{}